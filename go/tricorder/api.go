@@ -4,6 +4,8 @@ import (
 	"errors"
 	"github.com/Symantec/tricorder/go/tricorder/messages"
 	"github.com/Symantec/tricorder/go/tricorder/units"
+	"net/http"
+	"net/rpc"
 	"time"
 )
 
@@ -23,6 +25,16 @@ var (
 	ErrWrongUnit = errors.New("tricorder: Wrong unit")
 	// RegisterMetric returns this if passed metric type is not supported.
 	ErrWrongType = errors.New("tricorder: Metric not of a valid type")
+	// SketchDistribution.Merge returns this if the sketches being merged
+	// have different relative accuracies.
+	ErrIncompatibleSketch = errors.New("tricorder: Incompatible sketch")
+	// RegisterStruct returns this if a tricorder struct tag has an
+	// unknown option.
+	ErrBadTag = errors.New("tricorder: Bad struct tag")
+	// RegisterMetric returns this if passed metric is a plain variable
+	// and the group has an update timeout.
+	ErrVariableWithTimeout = errors.New(
+		"tricorder: Plain variable in group with update timeout")
 )
 
 // DirectoryGroup combines a group and directory for the purpose of
@@ -78,6 +90,77 @@ func (g *Group) RegisterUpdateFunc(updateFunc func() time.Time) {
 	(*region)(g).registerUpdateFunc(updateFunc)
 }
 
+// SetUpdateTimeout sets how long tricorder waits for the update function
+// of this group. If the update function takes longer, tricorder stops
+// waiting, serves the metrics in this group with the timestamp of the
+// last successful update, and marks them stale. While a timed out update
+// function is still running, tricorder does not call it again.
+// Since requests read the metrics of this group while a timed out update
+// function may still be changing them, a group with an update timeout
+// cannot have metrics that are plain variables. Store values with
+// Counter, Gauge, or IntGauge or in variables that callbacks read under
+// a lock instead. RegisterMetric returns ErrVariableWithTimeout for a
+// plain variable in a group with an update timeout, and SetUpdateTimeout
+// panics if plain variables were already registered in this group.
+// Unless SetRecoverPanics is true, a panic in a timed out update
+// function crashes the program like any other panic.
+// A zero or negative timeout, the default, means wait forever.
+func (g *Group) SetUpdateTimeout(timeout time.Duration) {
+	(*region)(g).setUpdateTimeout(timeout)
+}
+
+// SetMinUpdateInterval sets the minimum time between calls to the update
+// function of this group. Requests arriving sooner than interval after the
+// last call reuse the values and timestamp that call produced. A zero or
+// negative interval, the default, means call the update function for
+// every request that does not overlap with another.
+func (g *Group) SetMinUpdateInterval(interval time.Duration) {
+	(*region)(g).setMinUpdateInterval(interval)
+}
+
+// UpdateInBackground calls the update function of this group right away
+// and then every interval in its own goroutine. Requests for metrics in
+// this group never call the update function or wait for it. They get the
+// values and timestamp of the last completed update.
+// Because requests may read the metrics of this group while the update
+// function runs, the update function should store values with Counter,
+// Gauge, or IntGauge or in variables that callbacks read under a lock.
+// SetUpdateTimeout has no effect in this mode. Since nothing would
+// recover a panic in the background goroutine, tricorder always recovers
+// panics in the update function in this mode regardless of
+// SetRecoverPanics: it marks the metrics of this group stale, counts the
+// panic in /proc/tricorder/update-failures, and logs it.
+// Even when switching modes, at most one call to the update function
+// runs at a time.
+// Calling UpdateInBackground again replaces the interval; calling it with
+// zero or negative interval goes back to calling the update function on
+// request. Close stops the background updates.
+func (g *Group) UpdateInBackground(interval time.Duration) {
+	(*region)(g).updateInBackground(interval)
+}
+
+// SetRecoverPanics controls whether tricorder recovers from panics in the
+// update function of this group. If true, tricorder serves the metrics in
+// this group with the timestamp of the last successful update and marks
+// them stale when the update function panics. Values the update function
+// stored before panicking stay. The default is false.
+//
+// Tricorder counts timeouts and recovered panics in the
+// /proc/tricorder/update-failures metric.
+func (g *Group) SetRecoverPanics(recoverPanics bool) {
+	(*region)(g).setRecoverPanics(recoverPanics)
+}
+
+// Close releases the resources of this group. After Close, tricorder
+// no longer calls the update function of this group, and metrics in
+// this group keep reporting the timestamp of the last update. Calling
+// RegisterUpdateFunc on a closed group has no effect. Close is meant for
+// groups whose metrics are being unregistered; DefaultGroup should never
+// be closed.
+func (g *Group) Close() {
+	(*region)(g).Close()
+}
+
 // RegisterMetric registers metric in this group. It is the same as
 // calling RegisterMetricInGroup(path, metric, g, unit, description)
 func (g *Group) RegisterMetric(
@@ -85,15 +168,26 @@ func (g *Group) RegisterMetric(
 	metric interface{},
 	unit units.Unit,
 	description string) error {
-	return root.registerMetric(
-		newPathSpec(path), metric, (*region)(g), unit, description)
+	return defaultRegistry.registerMetric(
+		path, metric, g, unit, description)
 }
 
 // ReadMyMetrics reads all the current tricorder metrics in this process
 // at or under path. If no metrics found under path, ReadMyMetrics returns
 // an empty slice
 func ReadMyMetrics(path string) messages.MetricList {
-	return readMyMetrics(path)
+	return defaultRegistry.readMyMetrics(path)
+}
+
+// SelectMyMetrics reads all the current tricorder metrics in this process
+// that selector selects. selector is either a glob such as
+// "/proc/*/latency" or "/**/errors" where * matches anything within one
+// path segment and ** matches any number of path segments, or a regular
+// expression on absolute paths prefixed with "~" such as "~errors$".
+// A glob matching a directory selects every metric under it.
+// SelectMyMetrics returns an error if selector is a bad regular expression.
+func SelectMyMetrics(selector string) (messages.MetricList, error) {
+	return defaultRegistry.selectMyMetricsByString(selector)
 }
 
 // RegisterMetric registers a single metric with the health system in the
@@ -119,12 +213,8 @@ func RegisterMetric(
 	metric interface{},
 	unit units.Unit,
 	description string) error {
-	return root.registerMetric(
-		newPathSpec(path),
-		metric,
-		(*region)(DefaultGroup),
-		unit,
-		description)
+	return defaultRegistry.registerMetric(
+		path, metric, DefaultGroup, unit, description)
 }
 
 // RegisterMetricInGroup works just like RegisterMetric but allows
@@ -136,14 +226,293 @@ func RegisterMetricInGroup(
 	g *Group,
 	unit units.Unit,
 	description string) error {
-	return root.registerMetric(
-		newPathSpec(path), metric, (*region)(g), unit, description)
+	return defaultRegistry.registerMetric(
+		path, metric, g, unit, description)
 }
 
 // UnregisterPath unregisters the metric or DirectorySpec at the given path.
 // UnregisterPath ignores requests to unregister the root path.
 func UnregisterPath(path string) {
-	root.unregisterPath(newPathSpec(path))
+	defaultRegistry.unregisterPath(path)
+}
+
+// Watcher reports changes to the metrics at or under a path.
+// Watcher instances are safe to use with multiple goroutines.
+type Watcher watcher
+
+// Watch returns a Watcher that checks the metrics at or under path every
+// interval. Each time it checks, the returned Watcher sends the metrics
+// that changed since the previous check on its C channel as a
+// messages.MetricList in Go RPC form. The first list that the returned
+// Watcher sends contains every metric at or under path. The Watcher never
+// sends an empty list.
+//
+// A distribution changes when values are added to it or, for windowed
+// distributions, when values leave its window. A list changes when its
+// timestamp changes. Any other metric changes when its value changes.
+// Metrics that get unregistered are not reported.
+//
+// If the receiver falls behind, the Watcher waits for the receiver
+// before checking again so that no change is lost.
+// Caller must call Stop on the returned Watcher when done with it.
+// Watch panics if interval is not positive.
+func Watch(path string, interval time.Duration) *Watcher {
+	return DefaultRegistry.Watch(path, interval)
+}
+
+// Stop stops this Watcher. After Stop returns, this Watcher sends no
+// further lists and eventually closes its C channel.
+func (w *Watcher) Stop() {
+	(*watcher)(w).Stop()
+}
+
+// Persister periodically saves metrics to a file so that a later process
+// can restore them. Persister instances are safe to use with multiple
+// goroutines.
+type Persister persister
+
+// Persist restores the metrics saved in filename, if it exists, and then
+// saves the metrics at or under paths to filename every interval.
+//
+// Persist does not change metrics that are already registered. Rather,
+// each saved metric gets restored when a metric with the same path, type,
+// and unit gets registered. Therefore, programs should call Persist at
+// startup before registering their metrics. Restoring adds the saved
+// value to the value of the metric being registered.
+//
+// Persist saves and restores only monotonic metrics: Counter instances
+// and CumulativeDistribution instances. Gauges, including variables of
+// numeric types, are neither saved nor restored. Restoring a
+// CumulativeDistribution requires the same bucketer as before. Saved
+// metrics with no matching registration are saved again so that they are
+// not lost. Restored metrics have the IsRestored and Epoch fields set in
+// JSON and Go RPC so that consumers can detect restarts.
+//
+// Persist panics if interval is not positive. It returns an error if
+// filename exists but cannot be read.
+// Caller should call Stop on the returned Persister before exiting.
+func Persist(filename string, interval time.Duration, paths ...string) (
+	*Persister, error) {
+	return DefaultRegistry.Persist(filename, interval, paths...)
+}
+
+// Save saves the metrics to the file right away.
+func (p *Persister) Save() error {
+	return (*persister)(p).Save()
+}
+
+// Stop stops the periodic saving and saves the metrics one last time.
+func (p *Persister) Stop() error {
+	return (*persister)(p).Stop()
+}
+
+// HistoryResolution describes one resolution at which History keeps the
+// history of metrics.
+type HistoryResolution struct {
+	// The time between points
+	Step time.Duration
+	// How far back the history goes
+	Length time.Duration
+}
+
+var (
+	// DefaultHistoryResolutions keeps 1 second resolution for 10 minutes
+	// and 1 minute resolution for 24 hours.
+	DefaultHistoryResolutions = []HistoryResolution{
+		{Step: time.Second, Length: 10 * time.Minute},
+		{Step: time.Minute, Length: 24 * time.Hour},
+	}
+)
+
+// History records the history of metrics in memory.
+type History history
+
+// RecordHistory starts recording the history of the numeric metrics and
+// distributions at or under paths. It samples the metrics at the finest
+// resolution and keeps their history at each resolution in a fixed size
+// ring buffer. For each step, a resolution keeps the last sample taken
+// within that step. For distributions, the history has the count, sum,
+// minimum, maximum, and average. If resolutions is empty, RecordHistory
+// uses DefaultHistoryResolutions.
+//
+// The history is available at /metricsapi/history/<path> and through the
+// MetricsServer.GetHistory RPC method. The from and to query parameters
+// are seconds since Jan 1, 1970 or durations relative to now such as
+// -5m. The step query parameter is a duration such as 10s.
+//
+// A registry records only one history. Calling RecordHistory again stops
+// the previous History.
+// RecordHistory panics if a resolution has a non-positive Step or a Length
+// less than its Step.
+func RecordHistory(
+	resolutions []HistoryResolution, paths ...string) *History {
+	return DefaultRegistry.RecordHistory(resolutions, paths...)
+}
+
+// Stop stops recording history.
+func (h *History) Stop() {
+	(*history)(h).Stop()
+}
+
+// Registry represents a tree of metrics isolated from all other trees.
+// The package level functions such as RegisterMetric and RegisterDirectory
+// work on DefaultRegistry. Libraries and tests that want their metrics
+// kept apart from the rest of the process can create their own Registry
+// instances.
+//
+// Metrics within a Registry may belong to any Group.
+// Registry instances are safe to use with multiple goroutines.
+type Registry registry
+
+var (
+	// The registry that the package level functions use. Package
+	// tricorder registers the http handlers and go rpc methods of
+	// this registry at init time.
+	DefaultRegistry = (*Registry)(defaultRegistry)
+)
+
+// NewRegistry returns a new, empty registry. Unlike DefaultRegistry,
+// the returned registry has no metrics under /proc, and its http handlers
+// and go rpc methods are not registered anywhere. To serve the returned
+// registry, use its ServeHTTP and RegisterRpc methods.
+func NewRegistry() *Registry {
+	return (*Registry)(newRegistry())
+}
+
+// RegisterMetric works just like the package level RegisterMetric
+// except that it registers the metric with this registry.
+func (r *Registry) RegisterMetric(
+	path string,
+	metric interface{},
+	unit units.Unit,
+	description string) error {
+	return (*registry)(r).registerMetric(
+		path, metric, DefaultGroup, unit, description)
+}
+
+// RegisterMetricInGroup works just like the package level
+// RegisterMetricInGroup except that it registers the metric with this
+// registry.
+func (r *Registry) RegisterMetricInGroup(
+	path string,
+	metric interface{},
+	g *Group,
+	unit units.Unit,
+	description string) error {
+	return (*registry)(r).registerMetric(
+		path, metric, g, unit, description)
+}
+
+// RegisterStruct works just like the package level RegisterStruct
+// except that it registers the metrics with this registry.
+func (r *Registry) RegisterStruct(
+	path string, s interface{}, g *Group) error {
+	return (*DirectorySpec)(r.root).RegisterStruct(path, s, g)
+}
+
+// GetDirectory works just like the package level GetDirectory except
+// that path is within this registry.
+func (r *Registry) GetDirectory(path string) (*DirectorySpec, error) {
+	dir, err := (*registry)(r).getDirectory(path)
+	return (*DirectorySpec)(dir), err
+}
+
+// RegisterDirectory works just like the package level RegisterDirectory
+// except that it registers the directory with this registry.
+func (r *Registry) RegisterDirectory(
+	path string) (dirSpec *DirectorySpec, err error) {
+	dir, err := (*registry)(r).registerDirectory(path)
+	return (*DirectorySpec)(dir), err
+}
+
+// UnregisterPath works just like the package level UnregisterPath
+// except that path is within this registry.
+func (r *Registry) UnregisterPath(path string) {
+	(*registry)(r).unregisterPath(path)
+}
+
+// NewCounterVec works just like the package level NewCounterVec
+// except that it registers the family with this registry.
+func (r *Registry) NewCounterVec(
+	path string,
+	unit units.Unit,
+	description string,
+	labelNames ...string) (*CounterVec, error) {
+	return (*DirectorySpec)(r.root).NewCounterVec(
+		path, unit, description, labelNames...)
+}
+
+// NewGaugeVec works just like the package level NewGaugeVec
+// except that it registers the family with this registry.
+func (r *Registry) NewGaugeVec(
+	path string,
+	unit units.Unit,
+	description string,
+	labelNames ...string) (*GaugeVec, error) {
+	return (*DirectorySpec)(r.root).NewGaugeVec(
+		path, unit, description, labelNames...)
+}
+
+// NewDistributionVec works just like the package level NewDistributionVec
+// except that it registers the family with this registry.
+func (r *Registry) NewDistributionVec(
+	path string,
+	bucketer *Bucketer,
+	unit units.Unit,
+	description string,
+	labelNames ...string) (*DistributionVec, error) {
+	return (*DirectorySpec)(r.root).NewDistributionVec(
+		path, bucketer, unit, description, labelNames...)
+}
+
+// RecordHistory works just like the package level RecordHistory except
+// that it records the history of the metrics in this registry.
+func (r *Registry) RecordHistory(
+	resolutions []HistoryResolution, paths ...string) *History {
+	return (*History)((*registry)(r).recordHistory(resolutions, paths...))
+}
+
+// Persist works just like the package level Persist except that it
+// persists the metrics in this registry.
+func (r *Registry) Persist(
+	filename string, interval time.Duration, paths ...string) (
+	*Persister, error) {
+	p, err := (*registry)(r).persist(filename, interval, paths...)
+	return (*Persister)(p), err
+}
+
+// Watch works just like the package level Watch except that it watches
+// the metrics in this registry.
+func (r *Registry) Watch(path string, interval time.Duration) *Watcher {
+	return (*Watcher)((*registry)(r).watch(pathSelector(path), interval))
+}
+
+// ReadMyMetrics works just like the package level ReadMyMetrics
+// except that it reads the metrics in this registry.
+func (r *Registry) ReadMyMetrics(path string) messages.MetricList {
+	return (*registry)(r).readMyMetrics(path)
+}
+
+// SelectMyMetrics works just like the package level SelectMyMetrics
+// except that it reads the metrics in this registry.
+func (r *Registry) SelectMyMetrics(selector string) (
+	messages.MetricList, error) {
+	return (*registry)(r).selectMyMetricsByString(selector)
+}
+
+// ServeHTTP serves the web UI of this registry at "/metrics", its REST API
+// at "/metricsapi", and its static content at "/metricsstatic" in the same
+// way that package tricorder serves DefaultRegistry on
+// http.DefaultServeMux. Requests for any other path get a 404 error.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.ServeHTTP(w, req)
+}
+
+// RegisterRpc registers the MetricsServer go rpc methods for this
+// registry with server. Since each rpc server can have only one
+// MetricsServer, RegisterRpc returns an error if server already has one.
+func (r *Registry) RegisterRpc(server *rpc.Server) error {
+	return (*registry)(r).registerRpc(server)
 }
 
 // Bucketer represents the organization of values into buckets for
@@ -224,6 +593,140 @@ func (c *CumulativeDistribution) Add(value interface{}) {
 	(*distribution)(c).Add(value)
 }
 
+// Quantile returns the approximate value at quantile q of this
+// distribution. For example, Quantile(0.99) returns the approximate 99th
+// percentile. Quantile interpolates within buckets the same way that
+// tricorder estimates the median except that Quantile(0) and Quantile(1)
+// return the exact min and max. Quantile returns 0 if this distribution
+// is empty and panics if q is not between 0 and 1.
+func (c *CumulativeDistribution) Quantile(q float64) float64 {
+	return (*distribution)(c).Quantile(q)
+}
+
+// SetQuantiles sets the quantiles that this distribution exports
+// in addition to its median, replacing any previously set quantiles.
+// For example, SetQuantiles(0.5, 0.9, 0.99, 0.999) exports p50, p90, p99,
+// and p99.9. SetQuantiles panics if any quantile is not between 0 and 1.
+func (c *CumulativeDistribution) SetQuantiles(quantiles ...float64) {
+	(*distribution)(c).SetQuantiles(quantiles)
+}
+
+// NewWindowedDistribution creates a new WindowedDistribution that uses
+// this bucketer to distribute values and that includes only the values
+// added within the last window of time. The distribution divides window
+// into the given number of slices. More slices means the window slides
+// more smoothly at the cost of more memory.
+// NewWindowedDistribution panics if window <= 0 or slices < 1.
+func (b *Bucketer) NewWindowedDistribution(
+	window time.Duration, slices int) *WindowedDistribution {
+	return (*WindowedDistribution)(newWindowedDistribution(
+		b, window, slices, time.Now))
+}
+
+// WindowedDistribution represents a metric that is a distribution of
+// only the values added within a sliding window of time such as the last
+// five minutes. WindowedDistribution instances report the length of their
+// window in the Window field of messages.Distribution. Because values
+// leave the window as time passes, a WindowedDistribution is never
+// cumulative.
+//
+// Internally, the window consists of slices. The oldest slice leaves the
+// window all at once, so the reported values go back anywhere from the
+// window less one slice to the full window.
+//
+// WindowedDistribution instances are safe to use with multiple goroutines.
+type WindowedDistribution windowedDistribution
+
+// Add adds a single value to this WindowedDistribution instance.
+// value can be a float32, float64, or a time.Duration.
+// If a time.Duration, Add converts it to this instance's assigned unit.
+// Add panics if value is not a float32, float64, or time.Duration or
+// this instance has no assigned unit.
+func (w *WindowedDistribution) Add(value interface{}) {
+	(*windowedDistribution)(w).Add(value)
+}
+
+// SetQuantiles works like CumulativeDistribution.SetQuantiles.
+func (w *WindowedDistribution) SetQuantiles(quantiles ...float64) {
+	(*windowedDistribution)(w).SetQuantiles(quantiles)
+}
+
+// SketchBucketer represents logarithmic buckets allocated on demand
+// such that each value is within a fixed relative error of its bucket.
+// Unlike Bucketer, SketchBucketer requires no advance knowledge of the
+// range of values. SketchBucketer instances are immutable.
+type SketchBucketer sketchBucketer
+
+// NewSketchBucketer returns a SketchBucketer with the given relative
+// accuracy. NewSketchBucketer(0.01) means that reported quantiles are
+// within 1% of the actual values. NewSketchBucketer panics if
+// relativeAccuracy is not strictly between 0 and 1.
+func NewSketchBucketer(relativeAccuracy float64) *SketchBucketer {
+	return (*SketchBucketer)(newSketchBucketer(relativeAccuracy))
+}
+
+// NewSketchDistribution creates a new SketchDistribution that uses this
+// bucketer.
+func (b *SketchBucketer) NewSketchDistribution() *SketchDistribution {
+	return (*SketchDistribution)(newSketchDistribution(
+		(*sketchBucketer)(b)))
+}
+
+// SketchDistribution represents a cumulative distribution whose
+// quantiles have bounded relative error. A SketchDistribution reports
+// its non-empty buckets in the Sketch field of messages.Distribution.
+// SketchDistributions with the same relative accuracy can be merged even
+// if they come from different processes.
+// SketchDistribution instances are safe to use with multiple goroutines.
+type SketchDistribution sketchDistribution
+
+// Add adds a single value to this SketchDistribution instance.
+// value can be a float32, float64, or a time.Duration.
+// If a time.Duration, Add converts it to this instance's assigned unit.
+// Add panics if value is not a float32, float64, or time.Duration or
+// this instance has no assigned unit.
+func (d *SketchDistribution) Add(value interface{}) {
+	(*sketchDistribution)(d).Add(value)
+}
+
+// Merge adds all the values in other to this instance.
+// Merge returns ErrIncompatibleSketch if the two instances have different
+// relative accuracies.
+func (d *SketchDistribution) Merge(other *SketchDistribution) error {
+	return (*sketchDistribution)(d).Merge((*sketchDistribution)(other))
+}
+
+// MergeMessage adds all the values in dist to this instance. dist
+// typically comes from reading a SketchDistribution in another process.
+// MergeMessage returns ErrIncompatibleSketch if dist has no Sketch or if
+// its relative accuracy differs from that of this instance.
+// It is the caller's responsibility to ensure that dist has the same unit
+// as this instance.
+func (d *SketchDistribution) MergeMessage(dist *messages.Distribution) error {
+	return (*sketchDistribution)(d).MergeMessage(dist)
+}
+
+// Quantile works like CumulativeDistribution.Quantile except that the
+// result is within the relative accuracy of this instance.
+func (d *SketchDistribution) Quantile(q float64) float64 {
+	return (*sketchDistribution)(d).Quantile(q)
+}
+
+// SetQuantiles works like CumulativeDistribution.SetQuantiles.
+func (d *SketchDistribution) SetQuantiles(quantiles ...float64) {
+	(*sketchDistribution)(d).SetQuantiles(quantiles)
+}
+
+// Sum returns the sum of the values in this distribution.
+func (d *SketchDistribution) Sum() float64 {
+	return (*sketchDistribution)(d).Sum()
+}
+
+// Count returns the number of values in this distribution
+func (d *SketchDistribution) Count() uint64 {
+	return (*sketchDistribution)(d).Count()
+}
+
 // Unlike in CumulativeDistributions,values in NonCumulativeDistributions
 // can change shifting from bucket to bucket.
 type NonCumulativeDistribution distribution
@@ -297,6 +800,16 @@ func (d *NonCumulativeDistribution) Count() uint64 {
 	return (*distribution)(d).Count()
 }
 
+// Quantile works like CumulativeDistribution.Quantile.
+func (d *NonCumulativeDistribution) Quantile(q float64) float64 {
+	return (*distribution)(d).Quantile(q)
+}
+
+// SetQuantiles works like CumulativeDistribution.SetQuantiles.
+func (d *NonCumulativeDistribution) SetQuantiles(quantiles ...float64) {
+	(*distribution)(d).SetQuantiles(quantiles)
+}
+
 const (
 	// Indicates that passed slice may change.
 	MutableSlice = true
@@ -350,6 +863,94 @@ func (l *List) Change(aSlice interface{}, sliceIsMutable bool) {
 	(*listType)(l).ChangeWithTimeStamp(aSlice, sliceIsMutable, time.Now())
 }
 
+// Counter represents a metric that counts something such as the number
+// of requests served. A counter starts at 0 and never decreases.
+// Register a Counter by passing a *Counter to RegisterMetric.
+// The messages.Metric of a registered Counter has IsMonotonic set.
+// Counter instances are safe to use with multiple goroutines.
+type Counter counter
+
+// Inc adds 1 to this counter.
+func (c *Counter) Inc() {
+	(*counter)(c).Add(1)
+}
+
+// Add adds delta to this counter.
+func (c *Counter) Add(delta uint64) {
+	(*counter)(c).Add(delta)
+}
+
+// Value returns the current value of this counter.
+func (c *Counter) Value() uint64 {
+	return (*counter)(c).Value()
+}
+
+// Gauge represents a float64 metric that can go up and down such as a
+// temperature. A gauge starts at 0.
+// Register a Gauge by passing a *Gauge to RegisterMetric.
+// Gauge instances are safe to use with multiple goroutines.
+type Gauge gauge
+
+// Set sets the value of this gauge to x.
+func (g *Gauge) Set(x float64) {
+	(*gauge)(g).Set(x)
+}
+
+// Add adds delta to this gauge.
+func (g *Gauge) Add(delta float64) {
+	(*gauge)(g).Add(delta)
+}
+
+// Sub subtracts delta from this gauge.
+func (g *Gauge) Sub(delta float64) {
+	(*gauge)(g).Add(-delta)
+}
+
+// Value returns the current value of this gauge.
+func (g *Gauge) Value() float64 {
+	return (*gauge)(g).Value()
+}
+
+// IntGauge represents an int64 metric that can go up and down such as
+// the number of requests in flight. An IntGauge starts at 0.
+// Register an IntGauge by passing a *IntGauge to RegisterMetric.
+// IntGauge instances are safe to use with multiple goroutines.
+type IntGauge intGauge
+
+// Set sets the value of this gauge to x.
+func (g *IntGauge) Set(x int64) {
+	(*intGauge)(g).Set(x)
+}
+
+// Add adds delta to this gauge.
+func (g *IntGauge) Add(delta int64) {
+	(*intGauge)(g).Add(delta)
+}
+
+// Sub subtracts delta from this gauge.
+func (g *IntGauge) Sub(delta int64) {
+	(*intGauge)(g).Add(-delta)
+}
+
+// Value returns the current value of this gauge.
+func (g *IntGauge) Value() int64 {
+	return (*intGauge)(g).Value()
+}
+
+// RemoteConfig configures how a directory that MountRemote creates
+// fetches the metrics of the other process.
+type RemoteConfig struct {
+	// How long after a fetch a query starts a new one. One second if
+	// zero.
+	RefreshInterval time.Duration
+	// How long a fetch may take. Two seconds if zero.
+	Timeout time.Duration
+	// How long queries wait for a fetch in progress counting from when
+	// it started. 200 milliseconds if zero. Negative means queries never
+	// wait.
+	MaxWait time.Duration
+}
+
 // DirectorySpec represents a specific directory in the heirarchy of
 // metrics.
 type DirectorySpec directory
@@ -358,8 +959,42 @@ type DirectorySpec directory
 // path exists, returns nil, ErrNotFound. If the path is a metric, returns
 // nil, ErrPathInUse.
 func GetDirectory(path string) (*DirectorySpec, error) {
-	dir, err := root.getDirectoryAndError(newPathSpec(path))
-	return (*DirectorySpec)(dir), err
+	return DefaultRegistry.GetDirectory(path)
+}
+
+// RegisterStruct registers the exported fields of the struct that s
+// points to as metrics under the directory path. All the metrics belong
+// to group g so that tricorder reads them consistently.
+//
+// Each field of a supported type becomes a metric. Supported types are
+// the types RegisterMetric accepts for variables, Counter, Gauge,
+// IntGauge, *List, and the distribution pointer types such as
+// *CumulativeDistribution. Fields of other struct types become
+// subdirectories containing their own fields. Pointer fields must be
+// non-nil.
+//
+// The tricorder tag of a field controls how it gets registered:
+//
+//	type RpcStats struct {
+//		Requests Counter       `tricorder:",desc=Number of requests"`
+//		Latency  time.Duration `tricorder:"latency,unit=Milliseconds"`
+//		internal int64         // Unexported fields are skipped
+//		Ignored  int64         `tricorder:"-"`
+//	}
+//
+// The first part of the tag is the name of the metric. If empty, the name
+// is the field name in kebab-case e.g "NumGoroutines" becomes
+// "num-goroutines". unit is one of the values in the units package e.g
+// Seconds or Bytes. It defaults to Seconds for time.Duration and None for
+// everything else. desc is the description and must come last since it
+// may contain commas. A tag of "-" skips the field.
+//
+// RegisterStruct returns ErrWrongType if s is not a pointer to a struct or
+// if a field has an unsupported type; ErrBadTag if a tag has an unknown
+// option; and ErrWrongUnit if a tag has an unknown unit. Fields registered
+// before the error stay registered.
+func RegisterStruct(path string, s interface{}, g *Group) error {
+	return DefaultRegistry.RegisterStruct(path, s, g)
 }
 
 // RegisterDirectory returns the the DirectorySpec registered with path.
@@ -368,8 +1003,7 @@ func GetDirectory(path string) (*DirectorySpec, error) {
 // RegisterDirectory returns ErrPathInUse if path is already associated
 // with a metric.
 func RegisterDirectory(path string) (dirSpec *DirectorySpec, err error) {
-	r, e := root.registerDirectory(newPathSpec(path))
-	return (*DirectorySpec)(r), e
+	return DefaultRegistry.RegisterDirectory(path)
 }
 
 // RegisterMetric works just like the package level RegisterMetric
@@ -399,6 +1033,13 @@ func (d *DirectorySpec) RegisterMetricInGroup(
 	return (*directory)(d).registerMetric(newPathSpec(path), metric, (*region)(g), unit, description)
 }
 
+// RegisterStruct works just like the package level RegisterStruct
+// except that path is relative to this DirectorySpec.
+func (d *DirectorySpec) RegisterStruct(
+	path string, s interface{}, g *Group) error {
+	return (*directory)(d).registerStruct(newPathSpec(path), s, (*region)(g))
+}
+
 // RegisterDirectory works just like the package level RegisterDirectory
 // except that path is relative to this DirectorySpec.
 func (d *DirectorySpec) RegisterDirectory(
@@ -407,6 +1048,31 @@ func (d *DirectorySpec) RegisterDirectory(
 	return (*DirectorySpec)(r), e
 }
 
+// MountRemote makes the metrics of another process that serves tricorder
+// metrics appear in a new directory at name relative to this
+// DirectorySpec. address is host:port to read the metrics with go rpc or
+// http://host:port to read them with the REST API. A nil config means
+// the default configuration.
+//
+// The new directory fetches the metrics of the other process in the
+// background: once when MountRemote is called and then when a query
+// reaches the new directory at least config.RefreshInterval after the
+// last fetch. Queries wait for a fetch in progress until config.MaxWait
+// after it started; after that they see the metrics of the last fetch
+// that finished. Metadata and name only listings never start or wait for
+// a fetch. A remote
+// metric at /a/b shows up at name/a/b relative to this DirectorySpec.
+// The new directory also contains a _status metric which is "ok" if the
+// last fetch worked or why it failed otherwise. When a fetch fails,
+// the new directory keeps the metrics of the last fetch that worked with
+// their old timestamps.
+//
+// MountRemote returns ErrPathInUse if name is already in use.
+func (d *DirectorySpec) MountRemote(
+	name, address string, config *RemoteConfig) error {
+	return (*directory)(d).mountRemote(newPathSpec(name), address, config)
+}
+
 // Returns the absolute path this object represents
 func (d *DirectorySpec) AbsPath() string {
 	return (*directory)(d).AbsPath()
@@ -428,6 +1094,202 @@ func (d *DirectorySpec) UnregisterDirectory() {
 	(*directory)(d).unregisterDirectory()
 }
 
+// NewCounterVec works just like the package level NewCounterVec except
+// that path is relative to this DirectorySpec.
+func (d *DirectorySpec) NewCounterVec(
+	path string,
+	unit units.Unit,
+	description string,
+	labelNames ...string) (*CounterVec, error) {
+	checkLabelNames(labelNames)
+	dir, err := (*directory)(d).registerNewDirectory(newPathSpec(path))
+	if err != nil {
+		return nil, err
+	}
+	return (*CounterVec)(newCounterVecInDir(
+		dir, unit, description, labelNames)), nil
+}
+
+// NewGaugeVec works just like the package level NewGaugeVec except
+// that path is relative to this DirectorySpec.
+func (d *DirectorySpec) NewGaugeVec(
+	path string,
+	unit units.Unit,
+	description string,
+	labelNames ...string) (*GaugeVec, error) {
+	checkLabelNames(labelNames)
+	dir, err := (*directory)(d).registerNewDirectory(newPathSpec(path))
+	if err != nil {
+		return nil, err
+	}
+	return (*GaugeVec)(newGaugeVecInDir(
+		dir, unit, description, labelNames)), nil
+}
+
+// NewDistributionVec works just like the package level NewDistributionVec
+// except that path is relative to this DirectorySpec.
+func (d *DirectorySpec) NewDistributionVec(
+	path string,
+	bucketer *Bucketer,
+	unit units.Unit,
+	description string,
+	labelNames ...string) (*DistributionVec, error) {
+	checkLabelNames(labelNames)
+	dir, err := (*directory)(d).registerNewDirectory(newPathSpec(path))
+	if err != nil {
+		return nil, err
+	}
+	return (*DistributionVec)(newDistributionVecInDir(
+		dir, bucketer, unit, description, labelNames)), nil
+}
+
+// CounterVec represents a family of counters that share a path and
+// description but differ in the values of their labels such as a
+// family of request counters labeled by method and status code.
+//
+// A CounterVec creates its counters lazily. The counter for label values
+// v1, v2, ... vn lives at path/name1=v1/name2=v2/.../namen=vn
+// where name1 ... namen are the label names of the family. Any '%' or '/'
+// in a label value is escaped as "%25" or "%2F" respectively.
+// The Labels field of each counter's messages.Metric holds its labels.
+//
+// CounterVec instances are safe to use with multiple goroutines.
+type CounterVec metricVec
+
+// NewCounterVec registers a new family of counters at path in the
+// default registry. labelNames are the names of the labels of each counter
+// in the family. Label names must be non-empty and may not contain '/'
+// or '='. NewCounterVec panics if labelNames is empty or if any label name
+// is invalid.
+//
+// NewCounterVec returns ErrPathInUse if path is already associated with
+// a metric or directory.
+func NewCounterVec(
+	path string,
+	unit units.Unit,
+	description string,
+	labelNames ...string) (*CounterVec, error) {
+	return DefaultRegistry.NewCounterVec(
+		path, unit, description, labelNames...)
+}
+
+// With returns the counter for the given label values creating it if
+// needed.
+//
+// If creating the counter would exceed the maximum cardinality of this
+// instance, With returns the counter whose label values are all "other"
+// instead.
+//
+// With panics if the number of label values does not equal the number of
+// label names of this instance.
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	return (*metricVec)(c).With(labelValues).(*Counter)
+}
+
+// Delete removes the counter for the given label values.
+// Delete panics if the number of label values does not equal the number
+// of label names of this instance.
+func (c *CounterVec) Delete(labelValues ...string) {
+	(*metricVec)(c).Delete(labelValues)
+}
+
+// SetMaxCardinality sets the maximum number of distinct counters
+// this instance creates not counting the overflow counter whose label
+// values are all "other". 0, the default, means no limit.
+// SetMaxCardinality panics if max is negative.
+func (c *CounterVec) SetMaxCardinality(max int) {
+	(*metricVec)(c).SetMaxCardinality(max)
+}
+
+// GaugeVec represents a family of gauges that share a path and
+// description but differ in the values of their labels. GaugeVec
+// organizes its gauges the same way that CounterVec organizes its
+// counters.
+//
+// GaugeVec instances are safe to use with multiple goroutines.
+type GaugeVec metricVec
+
+// NewGaugeVec registers a new family of gauges at path in the default
+// registry. labelNames work the same way as in NewCounterVec.
+//
+// NewGaugeVec returns ErrPathInUse if path is already associated with
+// a metric or directory.
+func NewGaugeVec(
+	path string,
+	unit units.Unit,
+	description string,
+	labelNames ...string) (*GaugeVec, error) {
+	return DefaultRegistry.NewGaugeVec(
+		path, unit, description, labelNames...)
+}
+
+// With returns the gauge for the given label values creating it if
+// needed. With handles maximum cardinality the same way as
+// CounterVec.With.
+// With panics if the number of label values does not equal the number of
+// label names of this instance.
+func (g *GaugeVec) With(labelValues ...string) *Gauge {
+	return (*metricVec)(g).With(labelValues).(*Gauge)
+}
+
+// Delete removes the gauge for the given label values.
+// Delete panics if the number of label values does not equal the number
+// of label names of this instance.
+func (g *GaugeVec) Delete(labelValues ...string) {
+	(*metricVec)(g).Delete(labelValues)
+}
+
+// SetMaxCardinality works like CounterVec.SetMaxCardinality.
+func (g *GaugeVec) SetMaxCardinality(max int) {
+	(*metricVec)(g).SetMaxCardinality(max)
+}
+
+// DistributionVec represents a family of cumulative distributions that
+// share a path, description, and bucketer but differ in the values of
+// their labels. DistributionVec organizes its distributions the same
+// way that CounterVec organizes its counters.
+//
+// DistributionVec instances are safe to use with multiple goroutines.
+type DistributionVec metricVec
+
+// NewDistributionVec registers a new family of cumulative distributions
+// at path in the default registry. Each distribution in the family uses
+// bucketer. labelNames work the same way as in NewCounterVec.
+//
+// NewDistributionVec returns ErrPathInUse if path is already associated
+// with a metric or directory.
+func NewDistributionVec(
+	path string,
+	bucketer *Bucketer,
+	unit units.Unit,
+	description string,
+	labelNames ...string) (*DistributionVec, error) {
+	return DefaultRegistry.NewDistributionVec(
+		path, bucketer, unit, description, labelNames...)
+}
+
+// With returns the distribution for the given label values creating it
+// if needed. With handles maximum cardinality the same way as
+// CounterVec.With.
+// With panics if the number of label values does not equal the number of
+// label names of this instance.
+func (d *DistributionVec) With(
+	labelValues ...string) *CumulativeDistribution {
+	return (*metricVec)(d).With(labelValues).(*CumulativeDistribution)
+}
+
+// Delete removes the distribution for the given label values.
+// Delete panics if the number of label values does not equal the number
+// of label names of this instance.
+func (d *DistributionVec) Delete(labelValues ...string) {
+	(*metricVec)(d).Delete(labelValues)
+}
+
+// SetMaxCardinality works like CounterVec.SetMaxCardinality.
+func (d *DistributionVec) SetMaxCardinality(max int) {
+	(*metricVec)(d).SetMaxCardinality(max)
+}
+
 // RegisterFlags registers each application flag as a metric under /proc/flags
 // in the default group.
 func RegisterFlags() {
@@ -0,0 +1,124 @@
+// Package client reads the metrics of remote processes that serve them
+// with tricorder.
+//
+// A Client reads metrics using either go rpc or the JSON REST API.
+// Either way, it returns metrics in go rpc form.
+//
+//	c, err := client.New("myhost:8080", nil)
+//	if err != nil {
+//		...
+//	}
+//	defer c.Close()
+//	metrics, err := c.List(ctx, "/proc")
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"io"
+	"time"
+)
+
+// Transport is how a Client talks to a process.
+type Transport string
+
+const (
+	// Go rpc via the MetricsServer service
+	GoRPC Transport = "rpc"
+	// The JSON REST API under /metricsapi
+	REST Transport = "http"
+)
+
+const (
+	// The default timeout for each attempt
+	DefaultTimeout = 10 * time.Second
+	// The default number of connections kept open for reuse
+	DefaultMaxIdleConns = 2
+)
+
+// Config configures a Client. The zero value is a valid configuration.
+type Config struct {
+	// GoRPC if empty
+	Transport Transport
+	// How long each attempt may take. DefaultTimeout if zero.
+	Timeout time.Duration
+	// How many times to try again after failing to reach the process.
+	// Errors that the process reports are never retried.
+	Retries int
+	// How long to wait before trying again. Doubles with each retry.
+	// Zero means try again right away.
+	RetryDelay time.Duration
+	// How many connections to keep open for reuse.
+	// DefaultMaxIdleConns if zero.
+	MaxIdleConns int
+}
+
+// Client reads metrics from one remote process. Client is safe to use
+// from multiple goroutines. Each method gives up when ctx is done.
+type Client client
+
+// New returns a client for the process at address, a host:port. New does
+// not connect to the process. Clients connect as needed. A nil config
+// means the default configuration.
+func New(address string, config *Config) (*Client, error) {
+	c, err := newClient(address, config)
+	return (*Client)(c), err
+}
+
+// List returns the metrics at or under path.
+func (c *Client) List(ctx context.Context, path string) (
+	messages.MetricList, error) {
+	return (*client)(c).List(ctx, path)
+}
+
+// Get returns the metric at path. If there is no such metric, Get
+// returns messages.ErrMetricNotFound.
+func (c *Client) Get(ctx context.Context, path string) (
+	*messages.Metric, error) {
+	return (*client)(c).Get(ctx, path)
+}
+
+// Select returns the metrics that pattern selects. pattern is a glob or
+// a regular expression as described in the tricorder package.
+func (c *Client) Select(ctx context.Context, pattern string) (
+	messages.MetricList, error) {
+	return (*client)(c).Select(ctx, pattern)
+}
+
+// ListMetadata lists the directories and metrics under the path of
+// request without the values of the metrics.
+func (c *Client) ListMetadata(
+	ctx context.Context, request messages.MetadataRequest) (
+	messages.MetadataList, error) {
+	return (*client)(c).ListMetadata(ctx, request)
+}
+
+// ListSince returns the metrics at or under path that changed since
+// token. An empty token means every metric. Pass the Token field of the
+// result in the next call to get only what changes after this call.
+// The process evaluates every metric under path to find the changes.
+func (c *Client) ListSince(ctx context.Context, path, token string) (
+	*messages.ChangedMetrics, error) {
+	return (*client)(c).ListSince(ctx, path, token)
+}
+
+// Close closes the connections that c keeps open.
+func (c *Client) Close() error {
+	return (*client)(c).Close()
+}
+
+// DecodeJson reads a metric list in the JSON form that the REST API
+// serves and returns it in go rpc form.
+func DecodeJson(r io.Reader) (messages.MetricList, error) {
+	return decodeJson(json.NewDecoder(r))
+}
+
+// IsConnectionError returns true if err means that a Client method
+// could not reach the process as opposed to the process reporting an
+// error. Failures on the process's end such as 5xx statuses from the
+// REST API are retried but are not connection errors.
+func IsConnectionError(err error) bool {
+	retryable, ok := err.(*retryableError)
+	return ok && retryable.unreachable
+}
@@ -0,0 +1,478 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"io"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// What rpc.DialHTTP expects back from the CONNECT request
+	kRpcConnected = "200 Connected to Go RPC"
+)
+
+// transport fetches metrics from a process once, without retrying.
+type transport interface {
+	// call calls the MetricsServer method with request and stores the
+	// result, in go rpc form, in response.
+	call(ctx context.Context, method string, request, response interface{}) error
+	close() error
+}
+
+// retryableError means trying again may help. Either the process could
+// not be reached or it reported a failure on its end such as a 5xx
+// status.
+type retryableError struct {
+	err error
+	// True if the process could not be reached.
+	unreachable bool
+}
+
+// giveUp returns what a Client method returns after it stops retrying.
+// Only errors reaching the process stay retryableErrors so that
+// IsConnectionError reports just those.
+func (e *retryableError) giveUp() error {
+	if e.unreachable {
+		return e
+	}
+	return e.err
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+type client struct {
+	transport  transport
+	timeout    time.Duration
+	retries    int
+	retryDelay time.Duration
+}
+
+func newClient(address string, config *Config) (*client, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	maxIdleConns := config.MaxIdleConns
+	if maxIdleConns <= 0 {
+		maxIdleConns = DefaultMaxIdleConns
+	}
+	result := &client{
+		timeout:    timeout,
+		retries:    config.Retries,
+		retryDelay: config.RetryDelay,
+	}
+	switch config.Transport {
+	case GoRPC, "":
+		result.transport = newRpcTransport(address, maxIdleConns)
+	case REST:
+		result.transport = newRestTransport(address, maxIdleConns)
+	default:
+		return nil, fmt.Errorf("Unknown transport: %s", config.Transport)
+	}
+	return result, nil
+}
+
+// call calls method retrying as configured.
+func (c *client) call(
+	ctx context.Context,
+	method string,
+	request, response interface{}) error {
+	delay := c.retryDelay
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		err := c.transport.call(attemptCtx, method, request, response)
+		cancel()
+		retryable, ok := err.(*retryableError)
+		if !ok {
+			return err
+		}
+		if attempt >= c.retries || ctx.Err() != nil {
+			return retryable.giveUp()
+		}
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return retryable.giveUp()
+			}
+			delay *= 2
+		}
+	}
+}
+
+func (c *client) List(ctx context.Context, path string) (
+	messages.MetricList, error) {
+	var result messages.MetricList
+	if err := c.call(ctx, "MetricsServer.ListMetrics", path, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *client) Get(ctx context.Context, path string) (
+	*messages.Metric, error) {
+	var result messages.Metric
+	if err := c.call(ctx, "MetricsServer.GetMetric", path, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *client) Select(ctx context.Context, pattern string) (
+	messages.MetricList, error) {
+	var result messages.MetricList
+	if err := c.call(
+		ctx, "MetricsServer.SelectMetrics", pattern, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *client) ListMetadata(
+	ctx context.Context, request messages.MetadataRequest) (
+	messages.MetadataList, error) {
+	var result messages.MetadataList
+	if err := c.call(
+		ctx, "MetricsServer.ListMetadata", request, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *client) ListSince(ctx context.Context, path, token string) (
+	*messages.ChangedMetrics, error) {
+	var result messages.ChangedMetrics
+	if err := c.call(
+		ctx,
+		"MetricsServer.ListMetricsSince",
+		messages.ChangedSinceRequest{Path: path, Since: token},
+		&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *client) Close() error {
+	return c.transport.close()
+}
+
+// rpcTransport keeps up to maxIdleConns idle connections for reuse.
+// Each call has a connection to itself so that a call abandoned
+// mid-flight never leaves a stray reply on a shared connection.
+type rpcTransport struct {
+	address string
+	idle    chan *rpc.Client
+}
+
+func newRpcTransport(address string, maxIdleConns int) *rpcTransport {
+	return &rpcTransport{
+		address: address,
+		idle:    make(chan *rpc.Client, maxIdleConns),
+	}
+}
+
+// dial connects to the process the way rpc.DialHTTP does but gives up
+// when ctx is done.
+func (t *rpcTransport) dial(ctx context.Context) (*rpc.Client, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", t.address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	io.WriteString(conn, "CONNECT "+rpc.DefaultRPCPath+" HTTP/1.0\n\n")
+	resp, err := http.ReadResponse(
+		bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err == nil && resp.Status != kRpcConnected {
+		err = errors.New("unexpected HTTP response: " + resp.Status)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return rpc.NewClient(conn), nil
+}
+
+func (t *rpcTransport) get(ctx context.Context) (*rpc.Client, error) {
+	select {
+	case c := <-t.idle:
+		return c, nil
+	default:
+		return t.dial(ctx)
+	}
+}
+
+func (t *rpcTransport) put(c *rpc.Client) {
+	select {
+	case t.idle <- c:
+	default:
+		c.Close()
+	}
+}
+
+func (t *rpcTransport) call(
+	ctx context.Context,
+	method string,
+	request, response interface{}) error {
+	c, err := t.get(ctx)
+	if err != nil {
+		return &retryableError{err: err, unreachable: true}
+	}
+	call := c.Go(method, request, response, nil)
+	select {
+	case <-call.Done:
+	case <-ctx.Done():
+		c.Close()
+		return &retryableError{err: ctx.Err(), unreachable: true}
+	}
+	if serverErr, ok := call.Error.(rpc.ServerError); ok {
+		t.put(c)
+		if string(serverErr) == messages.ErrMetricNotFound.Error() {
+			return messages.ErrMetricNotFound
+		}
+		return serverErr
+	}
+	if call.Error != nil {
+		// The connection is broken. Don't reuse it.
+		c.Close()
+		return &retryableError{err: call.Error, unreachable: true}
+	}
+	t.put(c)
+	return nil
+}
+
+func (t *rpcTransport) close() error {
+	for {
+		select {
+		case c := <-t.idle:
+			c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+type restTransport struct {
+	address string
+	client  *http.Client
+}
+
+func newRestTransport(address string, maxIdleConns int) *restTransport {
+	return &restTransport{
+		address: address,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConnsPerHost: maxIdleConns,
+			},
+		},
+	}
+}
+
+// restRequest returns the path and query under /metricsapi for the
+// MetricsServer method and its request.
+func restRequest(method string, request interface{}) (
+	string, url.Values, error) {
+	var path string
+	var query url.Values
+	switch method {
+	case "MetricsServer.ListMetrics":
+		path, _ = request.(string)
+	case "MetricsServer.GetMetric":
+		path, _ = request.(string)
+		query = url.Values{"singleton": {"true"}}
+	case "MetricsServer.SelectMetrics":
+		pattern, _ := request.(string)
+		path = "/"
+		query = url.Values{"select": {pattern}}
+	case "MetricsServer.ListMetadata":
+		r, _ := request.(messages.MetadataRequest)
+		path = r.Path
+		query = url.Values{"list": {"metadata"}}
+		if r.NamesOnly {
+			query.Set("list", "names")
+		}
+		if r.MaxDepth > 0 {
+			query.Set("depth", strconv.Itoa(r.MaxDepth))
+		}
+	case "MetricsServer.ListMetricsSince":
+		r, _ := request.(messages.ChangedSinceRequest)
+		path = r.Path
+		query = url.Values{"since": {r.Since}}
+	default:
+		return "", nil, fmt.Errorf("Unsupported method: %s", method)
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path, query, nil
+}
+
+func (t *restTransport) call(
+	ctx context.Context,
+	method string,
+	request, response interface{}) error {
+	path, query, err := restRequest(method, request)
+	if err != nil {
+		return err
+	}
+	u := url.URL{
+		Scheme:   "http",
+		Host:     t.address,
+		Path:     "/metricsapi" + path,
+		RawQuery: query.Encode(),
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return &retryableError{err: err, unreachable: true}
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusNotFound:
+		return messages.ErrMetricNotFound
+	case resp.StatusCode >= 500:
+		return &retryableError{
+			err: fmt.Errorf("%s: %s", u.String(), resp.Status)}
+	default:
+		return fmt.Errorf("%s: %s", u.String(), resp.Status)
+	}
+	decoder := json.NewDecoder(resp.Body)
+	switch r := response.(type) {
+	case *messages.MetricList:
+		if *r, err = decodeJson(decoder); err != nil {
+			return err
+		}
+	case *messages.Metric:
+		var raw jsonMetric
+		if err := decoder.Decode(&raw); err != nil {
+			return err
+		}
+		m, err := raw.AsGoRPC()
+		if err != nil {
+			return err
+		}
+		*r = *m
+	case *messages.MetadataList:
+		if err := decoder.Decode(r); err != nil {
+			return err
+		}
+		for _, m := range *r {
+			m.ConvertToGoRPC()
+		}
+	case *messages.ChangedMetrics:
+		var raw struct {
+			Token   string        `json:"token"`
+			Metrics []*jsonMetric `json:"metrics"`
+		}
+		if err := decoder.Decode(&raw); err != nil {
+			return err
+		}
+		r.Token = raw.Token
+		if r.Metrics, err = asGoRPCList(raw.Metrics); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unsupported response type: %T", response)
+	}
+	return nil
+}
+
+func (t *restTransport) close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
+
+// jsonMetric is a metric from the REST API. Value stays raw until we know
+// the kind of the metric.
+type jsonMetric struct {
+	messages.Metric
+	Value json.RawMessage `json:"value"`
+}
+
+// AsGoRPC returns this metric in go rpc form.
+func (j *jsonMetric) AsGoRPC() (*messages.Metric, error) {
+	result := j.Metric
+	if len(j.Value) > 0 && string(j.Value) != "null" {
+		value, err := jsonValue(j.Value, j.Kind, j.SubType)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", j.Path, err)
+		}
+		result.Value = value
+	}
+	if err := result.ConvertToGoRPC(); err != nil {
+		return nil, fmt.Errorf("%s: %v", j.Path, err)
+	}
+	return &result, nil
+}
+
+// decodeJson decodes a metric list in JSON form and returns it in go rpc
+// form.
+func decodeJson(decoder *json.Decoder) (messages.MetricList, error) {
+	var raw []*jsonMetric
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return asGoRPCList(raw)
+}
+
+// asGoRPCList returns the metrics in raw in go rpc form.
+func asGoRPCList(raw []*jsonMetric) (messages.MetricList, error) {
+	result := make(messages.MetricList, len(raw))
+	for i := range raw {
+		var err error
+		if result[i], err = raw[i].AsGoRPC(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// jsonValue decodes raw into the go type that the JSON API uses for kind
+// so that int64 values stay exact and lists have the right element type.
+func jsonValue(raw json.RawMessage, kind, subType types.Type) (
+	interface{}, error) {
+	var zero interface{}
+	var err error
+	switch kind {
+	case types.Dist:
+		zero = (*messages.Distribution)(nil)
+	case types.List:
+		zero, err = subType.SafeNilSlice()
+	default:
+		zero, err = kind.SafeZeroValue()
+	}
+	if err != nil {
+		return nil, err
+	}
+	ptr := reflect.New(reflect.TypeOf(zero))
+	if err := json.Unmarshal(raw, ptr.Interface()); err != nil {
+		return nil, err
+	}
+	return ptr.Elem().Interface(), nil
+}
@@ -0,0 +1,260 @@
+package client_test
+
+import (
+	"context"
+	"github.com/Symantec/tricorder/go/tricorder"
+	"github.com/Symantec/tricorder/go/tricorder/client"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newServer serves a registry over both go rpc and the REST API.
+func newServer(t *testing.T) *httptest.Server {
+	result := newUnstartedServer(t)
+	result.Start()
+	return result
+}
+
+// newUnstartedServer works like newServer except that the caller must
+// start the returned server.
+func newUnstartedServer(t *testing.T) *httptest.Server {
+	reg := tricorder.NewRegistry()
+	var count tricorder.Counter
+	count.Add(3)
+	big := int64(1<<62 + 1)
+	elapsed := tricorder.NewList(
+		[]time.Duration{time.Second, 1500 * time.Millisecond},
+		tricorder.ImmutableSlice)
+	latency := tricorder.NewArbitraryBucketer(10.0).NewCumulativeDistribution()
+	reg.RegisterMetric("/a/count", &count, units.None, "A count")
+	reg.RegisterMetric("/a/big", &big, units.None, "A big number")
+	reg.RegisterMetric("/a/elapsed", elapsed, units.Millisecond, "Times")
+	reg.RegisterMetric("/b/latency", latency, units.Millisecond, "Latency")
+	latency.Add(5.0)
+	latency.Add(15.0)
+	rpcServer := rpc.NewServer()
+	if err := reg.RegisterRpc(rpcServer); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, rpcServer)
+	mux.Handle("/", reg)
+	return httptest.NewUnstartedServer(mux)
+}
+
+func metricPaths(list messages.MetricList) (result []string) {
+	for _, m := range list {
+		result = append(result, m.Path)
+	}
+	return
+}
+
+func assertValueEquals(t *testing.T, expected, actual interface{}) bool {
+	t.Helper()
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+		return false
+	}
+	return true
+}
+
+func TestClient(t *testing.T) {
+	server := newServer(t)
+	defer server.Close()
+	ctx := context.Background()
+	for _, transport := range []client.Transport{client.GoRPC, client.REST} {
+		c, err := client.New(
+			server.Listener.Addr().String(),
+			&client.Config{Transport: transport})
+		if err != nil {
+			t.Fatal(err)
+		}
+		list, err := c.List(ctx, "/a")
+		if assertValueEquals(t, nil, err) {
+			assertValueEquals(
+				t,
+				[]string{"/a/big", "/a/count", "/a/elapsed"},
+				metricPaths(list))
+			// Results are in go rpc form whatever the transport
+			assertValueEquals(t, int64(1<<62+1), list[0].Value)
+			assertValueEquals(t, types.List, list[2].Kind)
+			assertValueEquals(t, types.GoDuration, list[2].SubType)
+			assertValueEquals(
+				t,
+				[]time.Duration{time.Second, 1500 * time.Millisecond},
+				list[2].Value)
+			_, isTime := list[0].TimeStamp.(time.Time)
+			assertValueEquals(t, true, isTime)
+		}
+		// Paths need not start with a slash
+		list, err = c.List(ctx, "b")
+		if assertValueEquals(t, nil, err) {
+			assertValueEquals(t, []string{"/b/latency"}, metricPaths(list))
+		}
+		metadata, err := c.ListMetadata(
+			ctx, messages.MetadataRequest{Path: "/a", MaxDepth: 1})
+		if assertValueEquals(t, nil, err) &&
+			assertValueEquals(t, 3, len(metadata)) {
+			assertValueEquals(t, "/a/elapsed", metadata[2].Path)
+			assertValueEquals(t, types.GoDuration, metadata[2].SubType)
+			assertValueEquals(t, "Times", metadata[2].Description)
+		}
+		changed, err := c.ListSince(ctx, "/a", "")
+		if assertValueEquals(t, nil, err) {
+			assertValueEquals(
+				t,
+				[]string{"/a/big", "/a/count", "/a/elapsed"},
+				metricPaths(changed.Metrics))
+			assertValueEquals(t, int64(1<<62+1), changed.Metrics[0].Value)
+		}
+		changed, err = c.ListSince(ctx, "/a", changed.Token)
+		if assertValueEquals(t, nil, err) {
+			assertValueEquals(t, 0, len(changed.Metrics))
+		}
+		m, err := c.Get(ctx, "/b/latency")
+		if assertValueEquals(t, nil, err) {
+			dist := m.Value.(*messages.Distribution)
+			assertValueEquals(t, uint64(2), dist.Count)
+			assertValueEquals(t, 2, len(dist.Ranges))
+		}
+		_, err = c.Get(ctx, "/a/missing")
+		assertValueEquals(t, messages.ErrMetricNotFound, err)
+		list, err = c.Select(ctx, "/*/*count")
+		if assertValueEquals(t, nil, err) {
+			assertValueEquals(t, []string{"/a/count"}, metricPaths(list))
+		}
+		if _, err := c.Select(ctx, "~("); err == nil {
+			t.Errorf("%s: Expected error for bad selector", transport)
+		}
+		c.Close()
+	}
+	if _, err := client.New(
+		"localhost:1", &client.Config{Transport: "bogus"}); err == nil {
+		t.Error("Expected error for unknown transport")
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	server := newServer(t)
+	defer server.Close()
+	var attempts int32
+	flaky := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&attempts, 1) < 3 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			server.Config.Handler.ServeHTTP(w, r)
+		}))
+	defer flaky.Close()
+	c, err := client.New(
+		flaky.Listener.Addr().String(),
+		&client.Config{
+			Transport:  client.REST,
+			Retries:    2,
+			RetryDelay: time.Millisecond,
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	m, err := c.Get(context.Background(), "/a/count")
+	if assertValueEquals(t, nil, err) {
+		assertValueEquals(t, uint64(3), m.Value)
+	}
+	assertValueEquals(t, int32(3), atomic.LoadInt32(&attempts))
+
+	// Errors the process reports are not retried.
+	atomic.StoreInt32(&attempts, 2)
+	_, err = c.Get(context.Background(), "/a/missing")
+	assertValueEquals(t, messages.ErrMetricNotFound, err)
+	assertValueEquals(t, int32(3), atomic.LoadInt32(&attempts))
+	assertValueEquals(t, false, client.IsConnectionError(err))
+
+	// Failures on the process's end are retried but are not connection
+	// errors.
+	atomic.StoreInt32(&attempts, -10)
+	_, err = c.Get(context.Background(), "/a/count")
+	assertValueEquals(t, false, err == nil || client.IsConnectionError(err))
+	assertValueEquals(t, int32(-7), atomic.LoadInt32(&attempts))
+
+	// Neither are responses that don't decode.
+	atomic.StoreInt32(&attempts, 0)
+	garbled := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&attempts, 1)
+			w.Write([]byte("garbage"))
+		}))
+	defer garbled.Close()
+	c, err = client.New(
+		garbled.Listener.Addr().String(),
+		&client.Config{Transport: client.REST, Retries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_, err = c.Get(context.Background(), "/a/count")
+	assertValueEquals(t, false, err == nil || client.IsConnectionError(err))
+	assertValueEquals(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestClientTimeout(t *testing.T) {
+	hung := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+	defer hung.Close()
+	for _, transport := range []client.Transport{client.GoRPC, client.REST} {
+		c, err := client.New(
+			hung.Listener.Addr().String(),
+			&client.Config{
+				Transport: transport,
+				Timeout:   10 * time.Millisecond,
+				Retries:   1,
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		if _, err := c.List(
+			context.Background(), "/"); !client.IsConnectionError(err) {
+			t.Errorf("%s: Expected timeout, got %v", transport, err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: Took too long: %v", transport, elapsed)
+		}
+		c.Close()
+	}
+}
+
+func TestRpcConnectionReuse(t *testing.T) {
+	server := newUnstartedServer(t)
+	var conns int32
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+	c, err := client.New(server.Listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for i := 0; i < 5; i++ {
+		if _, err := c.Get(context.Background(), "/a/count"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertValueEquals(t, int32(1), atomic.LoadInt32(&conns))
+}
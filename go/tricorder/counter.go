@@ -0,0 +1,62 @@
+package tricorder
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// counter represents a monotonically increasing count. Same as Counter.
+type counter struct {
+	// Accessed atomically
+	value uint64
+}
+
+func (c *counter) Add(delta uint64) {
+	atomic.AddUint64(&c.value, delta)
+}
+
+func (c *counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// gauge represents a float64 that can go up and down. Same as Gauge.
+type gauge struct {
+	// The bits of the float64 value. Accessed atomically.
+	bits uint64
+}
+
+func (g *gauge) Set(x float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(x))
+}
+
+func (g *gauge) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&g.bits, old, updated) {
+			return
+		}
+	}
+}
+
+func (g *gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// intGauge represents an int64 that can go up and down. Same as IntGauge.
+type intGauge struct {
+	// Accessed atomically
+	value int64
+}
+
+func (g *intGauge) Set(x int64) {
+	atomic.StoreInt64(&g.value, x)
+}
+
+func (g *intGauge) Add(delta int64) {
+	atomic.AddInt64(&g.value, delta)
+}
+
+func (g *intGauge) Value() int64 {
+	return atomic.LoadInt64(&g.value)
+}
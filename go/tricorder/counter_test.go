@@ -0,0 +1,89 @@
+package tricorder
+
+import (
+	"bytes"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"sync"
+	"testing"
+)
+
+func TestCounterAndGauges(t *testing.T) {
+	var counter Counter
+	var gauge Gauge
+	var intGauge IntGauge
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				counter.Inc()
+				gauge.Add(0.5)
+				intGauge.Sub(2)
+			}
+		}()
+	}
+	wg.Wait()
+	counter.Add(5)
+	assertValueEquals(t, uint64(1005), counter.Value())
+	assertValueEquals(t, 500.0, gauge.Value())
+	assertValueEquals(t, int64(-2000), intGauge.Value())
+	gauge.Set(-1.25)
+	gauge.Sub(1.0)
+	assertValueEquals(t, -2.25, gauge.Value())
+	intGauge.Set(7)
+	intGauge.Add(3)
+	assertValueEquals(t, int64(10), intGauge.Value())
+
+	reg := NewRegistry()
+	reg.RegisterMetric("/counter", &counter, units.None, "A counter")
+	reg.RegisterMetric("/gauge", &gauge, units.Celsius, "A gauge")
+	reg.RegisterMetric("/int-gauge", &intGauge, units.None, "An int gauge")
+	list := reg.ReadMyMetrics("/")
+	if assertValueEquals(t, 3, len(list)) {
+		assertValueEquals(t, uint64(1005), list[0].Value)
+		assertValueEquals(t, true, list[0].IsMonotonic)
+		assertValueEquals(t, -2.25, list[1].Value)
+		assertValueEquals(t, false, list[1].IsMonotonic)
+		assertValueEquals(t, int64(10), list[2].Value)
+		assertValueEquals(t, false, list[2].IsMonotonic)
+	}
+	counter.Inc()
+	assertValueEquals(
+		t, uint64(1006), reg.ReadMyMetrics("/counter")[0].Value)
+
+	var buffer bytes.Buffer
+	if err := reg.root.GetAllMetrics(
+		newPrometheusCollector(&buffer), nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP counter_total A counter
+# TYPE counter_total counter
+counter_total 1006
+# HELP gauge_celsius A gauge
+# TYPE gauge_celsius gauge
+gauge_celsius -2.25
+# HELP int_gauge An int gauge
+# TYPE int_gauge gauge
+int_gauge 10
+`
+	assertValueEquals(t, expected, buffer.String())
+}
+
+func TestGaugeVec(t *testing.T) {
+	reg := NewRegistry()
+	inFlight, err := reg.NewGaugeVec(
+		"/in-flight", units.None, "In flight", "method")
+	if err != nil {
+		t.Fatalf("Got error %v creating gauge vec", err)
+	}
+	inFlight.With("Get").Add(3)
+	inFlight.With("Get").Sub(1)
+	inFlight.With("Put").Set(4)
+	list := reg.ReadMyMetrics("/in-flight")
+	if assertValueEquals(t, 2, len(list)) {
+		assertValueEquals(t, "/in-flight/method=Get", list[0].Path)
+		assertValueEquals(t, 2.0, list[0].Value)
+		assertValueEquals(t, 4.0, list[1].Value)
+	}
+}
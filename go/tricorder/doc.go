@@ -21,7 +21,9 @@ Use the tricorder package in your application like so:
 Viewing Metrics with a Web Browser
 
 Package tricorder uses the net/http package register its web UI at path "/metrics".
-Package tricorder registers static content such as CSS files at "/metricsstatic".
+Package tricorder registers static content such as CSS and JavaScript files at
+"/metricsstatic". The HTML pages draw each distribution as a bar chart and
+draw a sparkline next to each numeric metric by polling the REST API.
 
 URL formats to view metrics:
 
@@ -34,6 +36,16 @@ URL formats to view metrics:
 		Does not expand metrics under subdirectories such as
 		dirpath/asubdir but shows subdirectories such as
 		dirpath/subdir as a hyper link instead.
+	http://yourhostname.com/metrics/dirpath?q=latency
+		View all metrics anywhere under dirpath whose path or
+		description contains 'latency' ignoring case in HTML.
+		Every page has a search box which searches every
+		metric.
+	http://yourhostname.com/metrics/dirpath?refresh=10
+		View dirpath in HTML reloading every 10 seconds while
+		keeping the scroll position and expanded subdirectories.
+		Subdirectories expand inline when clicking their
+		triangles.
 	http://yourhostname.com/metrics/single/metric/path?format=text
 		Value of metric single/metric/path in plain text.
 	http://yourhostname.com/metrics/dirpath/?format=text
@@ -44,6 +56,33 @@ URL formats to view metrics:
 			dirpath/subdir/ametric 21.3
 			dirpath/first 12345
 			dirpath/second 5.28
+	http://yourhostname.com/metrics/dirpath/?format=text&q=latency
+		Same as above showing only metrics whose path or
+		description contains 'latency' ignoring case.
+	http://yourhostname.com/metrics/dirpath/?format=prometheus
+		Shows all metrics starting with 'dirpath/' in the prometheus
+		text exposition format. Paths become metric names such as
+		dirpath_subdir_ametric, and the unit becomes a suffix such as
+		_seconds or _bytes. Cumulative distributions become histograms;
+		non cumulative distributions become gauge histograms.
+		Strings and lists are omitted.
+
+Selecting metrics
+
+Every URL of the web UI or the REST API that shows the metrics under a
+path also accepts a select parameter to show only some of them. A selector is a glob relative to the
+path where * matches anything within a path segment and ** matches any
+number of path segments, or a regular expression on absolute paths
+prefixed with ~. A glob that matches a directory selects every metric
+under it.
+
+	http://yourhostname.com/metrics/proc?select=*latency
+		View the metrics directly under /proc whose names end in
+		latency and everything under such directories.
+	http://yourhostname.com/metricsapi/?select=~/errors$
+		Returns every metric named errors no matter how deep.
+	http://yourhostname.com/metrics/dirpath?format=text&select=~errors$
+		Shows every metric under dirpath whose path ends in errors.
 
 Fetching metrics using go RPC
 
@@ -56,6 +95,19 @@ Recursively lists all metrics under a particular path.
 Request is the absolute path as a string.
 Response is a messages.MetricList type.
 
+MetricsServer.SelectMetrics
+
+Lists all metrics that a selector selects. Request is the selector as a
+string. Response is a messages.MetricList type.
+
+MetricsServer.ListMetadata
+
+Lists the directories and metrics under a particular path without the
+values of the metrics. Unlike ListMetrics, it never calls callbacks or
+group update functions, so it is cheap even for large trees.
+Request is a messages.MetadataRequest which can limit the depth and ask
+for names only. Response is a messages.MetadataList type.
+
 MetricsServer.GetMetric
 
 Gets a single metric with a particular path or returns
@@ -63,6 +115,21 @@ messages.ErrMetricNotFound if there is no such metric.
 Request is the absolute path as a string.
 Response is a messages.Metric type.
 
+MetricsServer.ListMetricsSince
+
+Recursively lists the metrics under a particular path that changed since
+the previous call. Request is a messages.ChangedSinceRequest.
+Response is a messages.ChangedMetrics type which includes the token to
+pass in the next request. An empty token means list every metric.
+A distribution changes when its generation changes; a list, when its
+timestamp changes; any other metric, when its value changes.
+
+Tricorder finds changes by evaluating every metric under the path and
+comparing it with what the last query saw, so ListMetricsSince saves
+bandwidth but costs the process as much as ListMetrics. Since tricorder
+compares only with the last query, a metric that changes and then
+changes back between two queries does not count as changed.
+
 Example:
 
 	import "github.com/Symantec/tricorder/go/tricorder/messages"
@@ -76,6 +143,10 @@ Example:
 		// we found /a/metric
 	}
 
+Package github.com/Symantec/tricorder/go/tricorder/client wraps these
+methods and the REST API in a Client type that handles timeouts,
+retries and connection reuse.
+
 Fetching metrics using REST API
 
 Package tricorder registers its REST API at "/metricsapi"
@@ -87,10 +158,36 @@ REST urls:
 	http://yourhostname.com/metricsapi/a/path
 		Returns a possibly empty json array array of every metric
 		anywhere under /a/path.
+	http://yourhostname.com/metricsapi/a/path?depth=1
+		Like the above but returns only the metrics at most one
+		level under /a/path, that is directly under it, without
+		evaluating anything deeper.
 	http://yourhostname.com/metricsapi/path/to/metric?singleton=true
 		Returns a metric json object with absolute path
 		/path/to/metric or gives a 404 error if no such metric
 		exists.
+	http://yourhostname.com/metricsapi/a/path?list=metadata&depth=2
+		Returns a json array of the directories and metrics at most
+		two levels under /a/path. Metrics have their path, kind,
+		unit, and description but no value. Omit depth for no limit.
+	http://yourhostname.com/metricsapi/a/path?list=names
+		Like list=metadata but returns only paths.
+	http://yourhostname.com/metricsapi/a/path?since=token
+		Returns a json object with a "metrics" array of the metrics
+		anywhere under /a/path that changed since the query that
+		returned token along with a new "token" for the next query.
+		A distribution changes when its generation changes; a list,
+		when its timestamp changes; any other metric, when its value
+		changes. Pass an empty token in the first query to get every
+		metric. Like MetricsServer.ListMetricsSince, since queries
+		evaluate every metric under /a/path and miss changes that
+		revert before the next query.
+	http://yourhostname.com/metricsapi/a/path?watch=5s
+		Streams the metrics anywhere under /a/path as Server-Sent
+		Events checking for changes every 5 seconds. The data of
+		each event is a json array of the metrics that changed
+		since the previous event. The first event includes every
+		metric.
 
 Sample metric json object:
 
@@ -173,6 +270,38 @@ Metric types:
 			tricorder.None,
 			"duration value description")
 
+	tricorder.Counter
+		tricorder.RegisterMetric(
+			"a/path/to/counter",
+			&counter,
+			tricorder.None,
+			"counter description")
+		...
+		counter.Inc()
+
+	tricorder.Gauge, tricorder.IntGauge
+		tricorder.RegisterMetric(
+			"a/path/to/gauge",
+			&gauge,
+			tricorder.None,
+			"gauge description")
+		...
+		gauge.Set(12.5)
+
+Unlike plain variables, Counter, Gauge, and IntGauge instances are safe to
+update from multiple goroutines without any additional synchronization.
+
+To register many related variables at once, put them in a struct and
+register the struct. Tags on the fields control the metric names, units,
+and descriptions. Nested structs become subdirectories.
+
+	type ServerStats struct {
+		Connections int64         `tricorder:",desc=Open connections"`
+		Uptime      time.Duration `tricorder:"uptime,unit=Seconds"`
+	}
+	var stats ServerStats
+	tricorder.RegisterStruct("/server", &stats, group)
+
 If code generates a metric's value, register the callback function like so
 
 	func generateAnInt() int {
@@ -184,6 +313,19 @@ If code generates a metric's value, register the callback function like so
 		tricorder.None,
 		"generated int description")
 
+A callback function may also return an error along with its value.
+When the error is non-nil, tricorder reports the error instead of the
+value and increments /proc/tricorder/callback-errors.
+
+	func readTemperature() (float64, error) {
+		return sensor.Read()
+	}
+	tricorder.RegisterMetric(
+		"path/to/temperature",
+		readTemperature,
+		units.Celsius,
+		"temperature description")
+
 Tricorder can collect a distribution of values in a metric.
 With distributions, the client program must manually add values.
 Although Distributions store values internally as float64, they can
@@ -210,6 +352,28 @@ no assigned unit. Distributions are safe to use from multiple goroutines.
 		globalDist.Add(getAnotherFloatValue())
 	}
 
+To export quantiles such as the 99th percentile along with the median,
+call SetQuantiles on the distribution.
+
+	globalDist.SetQuantiles(0.5, 0.9, 0.99, 0.999)
+
+When the range of values is not known in advance, use a sketch
+distribution. Its buckets are allocated on demand such that every
+reported quantile is within a fixed relative error. Sketch distributions
+with the same accuracy can be merged, even across processes, using the
+Sketch field of messages.Distribution.
+
+	// Quantiles accurate to within 1%
+	latencyDist := tricorder.NewSketchBucketer(0.01).NewSketchDistribution()
+
+A windowed distribution reports only the values added within a sliding
+window of time, so recent changes such as a latency spike stay visible
+no matter how long the program has been running.
+
+	// The last 5 minutes in 10 slices of 30 seconds each
+	recentDist := tricorder.PowersOfTen.NewWindowedDistribution(
+		5*time.Minute, 10)
+
 Tricorder can store a list of values in a metric. In lists,
 values are always of the same type.
 List instances are safe to use from multiple goroutines.
@@ -225,5 +389,66 @@ List instances are safe to use from multiple goroutines.
 	func doSomethingDuringProgram() {
 		globalList.Change([]int{1,4,9,16}, tricorder.ImmutableSlice)
 	}
+
+Metric Families
+
+A metric family is a group of metrics that share a path and description
+but differ in the values of their labels. tricorder.CounterVec and
+tricorder.DistributionVec create the metrics of a family lazily. Each
+label becomes a path segment of the form name=value, and the Labels field
+of messages.Metric holds the labels of each metric.
+
+	requests, _ := tricorder.NewCounterVec(
+		"/rpc/requests",
+		units.None,
+		"Request count",
+		"method", "code")
+	requests.SetMaxCardinality(100)
+
+	func handle(method string, code int) {
+		// Increments /rpc/requests/method=Get/code=200
+		requests.With(method, strconv.Itoa(code)).Inc()
+	}
+
+Once a family reaches its maximum cardinality, new label values
+are recorded in the metric whose label values are all "other".
+
+Isolated Registries
+
+The package level functions register metrics with
+tricorder.DefaultRegistry which is served on http.DefaultServeMux
+and the default go rpc server. Libraries and tests that need their own
+metric tree can create one with tricorder.NewRegistry() and serve it
+explicitly.
+
+	reg := tricorder.NewRegistry()
+	reg.RegisterMetric(
+		"path/to/value", &value, units.None, "A value")
+	go http.ListenAndServe(":8081", reg)
+	server := rpc.NewServer()
+	reg.RegisterRpc(server)
+
+Mounting Remote Processes
+
+A process can show the metrics of helper processes that serve tricorder
+metrics on their own ports along with its own metrics. MountRemote
+creates a directory that mirrors the metric tree of another process.
+The mirrored metrics show up everywhere local metrics do with their
+paths under the new directory.
+
+	sidecars, _ := tricorder.RegisterDirectory("/sidecars")
+	// Read over go rpc
+	sidecars.MountRemote("cache", "localhost:7001", nil)
+	// Read over the REST API, giving up on slow fetches sooner
+	sidecars.MountRemote(
+		"indexer",
+		"http://localhost:7002",
+		&tricorder.RemoteConfig{Timeout: time.Second})
+
+Queries start fetching the remote metrics in the background as they
+need them. They wait a short while for a fetch in progress and then
+see the metrics of the last fetch that finished. If a fetch fails, the
+directory keeps the metrics of the last fetch that worked, and its
+_status metric says why the fetch failed.
 */
 package tricorder
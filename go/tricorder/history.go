@@ -0,0 +1,318 @@
+package tricorder
+
+import (
+	"github.com/Symantec/tricorder/go/tricorder/duration"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyPoint is a single sample of a metric.
+type historyPoint struct {
+	TimeStamp time.Time
+	Value     float64
+	Count     uint64
+	Sum       float64
+	Min       float64
+	Max       float64
+}
+
+func (p *historyPoint) AsMessage() *messages.HistoryPoint {
+	return &messages.HistoryPoint{
+		TimeStamp: duration.TimeToFloat(p.TimeStamp),
+		Value:     p.Value,
+		Count:     p.Count,
+		Sum:       p.Sum,
+		Min:       p.Min,
+		Max:       p.Max,
+	}
+}
+
+// historyRing is a fixed size ring buffer of points at one resolution.
+// Each point is the last sample taken within its step.
+type historyRing struct {
+	step   time.Duration
+	points []historyPoint
+	// Index of the oldest point
+	start int
+	size  int
+}
+
+func newHistoryRing(resolution HistoryResolution) *historyRing {
+	return &historyRing{
+		step:   resolution.Step,
+		points: make([]historyPoint, resolution.Length/resolution.Step),
+	}
+}
+
+// Length returns how far back this ring goes when full.
+func (r *historyRing) Length() time.Duration {
+	return time.Duration(len(r.points)) * r.step
+}
+
+func (r *historyRing) at(i int) *historyPoint {
+	return &r.points[(r.start+i)%len(r.points)]
+}
+
+// Add adds p to this ring. If the newest point is in the same step as
+// p, p replaces it.
+func (r *historyRing) Add(p historyPoint) {
+	if r.size > 0 {
+		last := r.at(r.size - 1)
+		if last.TimeStamp.Truncate(r.step).Equal(
+			p.TimeStamp.Truncate(r.step)) {
+			*last = p
+			return
+		}
+	}
+	if r.size < len(r.points) {
+		*r.at(r.size) = p
+		r.size++
+		return
+	}
+	r.points[r.start] = p
+	r.start = (r.start + 1) % len(r.points)
+}
+
+// Newest returns the time of the newest point or the zero time if this
+// ring is empty.
+func (r *historyRing) Newest() time.Time {
+	if r.size == 0 {
+		return time.Time{}
+	}
+	return r.at(r.size - 1).TimeStamp
+}
+
+// Points returns the points from from to to inclusive keeping only the
+// last point in each step.
+func (r *historyRing) Points(
+	from, to time.Time, step time.Duration) []*messages.HistoryPoint {
+	var result []*messages.HistoryPoint
+	var lastSlot time.Time
+	for i := 0; i < r.size; i++ {
+		p := r.at(i)
+		if p.TimeStamp.Before(from) || p.TimeStamp.After(to) {
+			continue
+		}
+		slot := p.TimeStamp.Truncate(step)
+		if len(result) > 0 && slot.Equal(lastSlot) {
+			result[len(result)-1] = p.AsMessage()
+		} else {
+			result = append(result, p.AsMessage())
+		}
+		lastSlot = slot
+	}
+	return result
+}
+
+// metricHistory is the history of one metric at every resolution.
+type metricHistory struct {
+	Description string
+	Unit        units.Unit
+	Kind        types.Type
+	// In ascending order of step
+	rings []*historyRing
+}
+
+// history samples metrics on a schedule and keeps their history.
+// Same as History.
+type history struct {
+	registry    *registry
+	paths       []string
+	resolutions []HistoryResolution
+	now         func() time.Time
+	stopCh      chan struct{}
+	stopOnce    sync.Once
+	// Protects metrics
+	lock    sync.Mutex
+	metrics map[string]*metricHistory
+}
+
+func newHistory(
+	r *registry,
+	resolutions []HistoryResolution,
+	paths []string,
+	now func() time.Time) *history {
+	if len(resolutions) == 0 {
+		resolutions = DefaultHistoryResolutions
+	}
+	sorted := make([]HistoryResolution, len(resolutions))
+	copy(sorted, resolutions)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Step < sorted[j].Step
+	})
+	for _, resolution := range sorted {
+		if resolution.Step <= 0 || resolution.Length < resolution.Step {
+			panic(panicBadHistoryResolution)
+		}
+	}
+	return &history{
+		registry:    r,
+		paths:       paths,
+		resolutions: sorted,
+		now:         now,
+		stopCh:      make(chan struct{}),
+		metrics:     make(map[string]*metricHistory),
+	}
+}
+
+func (r *registry) recordHistory(
+	resolutions []HistoryResolution, paths ...string) *history {
+	result := newHistory(r, resolutions, paths, time.Now)
+	r.historyLock.Lock()
+	if r.history != nil {
+		r.history.Stop()
+	}
+	r.history = result
+	r.historyLock.Unlock()
+	go result.loop()
+	return result
+}
+
+func (r *registry) getHistory() *history {
+	r.historyLock.Lock()
+	defer r.historyLock.Unlock()
+	return r.history
+}
+
+func (h *history) Stop() {
+	h.stopOnce.Do(func() { close(h.stopCh) })
+}
+
+func (h *history) loop() {
+	// Sample at the finest resolution.
+	ticker := time.NewTicker(h.resolutions[0].Step)
+	defer ticker.Stop()
+	h.sample()
+	for {
+		select {
+		case <-ticker.C:
+			h.sample()
+		case <-h.stopCh:
+			return
+		}
+	}
+}
+
+// asHistoryPoint converts m, in Go RPC form, to a point. It returns false
+// if m is not a numeric metric or a distribution.
+func asHistoryPoint(m *messages.Metric, ts time.Time) (
+	result historyPoint, ok bool) {
+	if m.Err != "" {
+		return
+	}
+	result.TimeStamp = ts
+	if m.Kind == types.Dist {
+		dist, isDist := m.Value.(*messages.Distribution)
+		if !isDist || dist == nil {
+			return
+		}
+		result.Value = dist.Average
+		result.Count = dist.Count
+		result.Sum = dist.Sum
+		result.Min = dist.Min
+		result.Max = dist.Max
+		return result, true
+	}
+	if m.Kind == types.GoTime || !m.Kind.CanToFromFloat() {
+		return
+	}
+	result.Value = m.Kind.ToFloat(m.Value)
+	return result, true
+}
+
+// sample records the current value of each metric under the paths.
+func (h *history) sample() {
+	ts := h.now()
+	var list messages.MetricList
+	for _, path := range h.paths {
+		list = append(list, h.registry.readMyMetrics(path)...)
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, m := range list {
+		point, ok := asHistoryPoint(m, ts)
+		if !ok {
+			continue
+		}
+		mh := h.metrics[m.Path]
+		if mh == nil || mh.Kind != m.Kind {
+			mh = &metricHistory{
+				rings: make([]*historyRing, len(h.resolutions))}
+			for i := range h.resolutions {
+				mh.rings[i] = newHistoryRing(h.resolutions[i])
+			}
+			h.metrics[m.Path] = mh
+		}
+		mh.Description = m.Description
+		mh.Unit = m.Unit
+		mh.Kind = m.Kind
+		for _, ring := range mh.rings {
+			ring.Add(point)
+		}
+	}
+	// Forget metrics that have been gone longer than we keep history.
+	for path, mh := range h.metrics {
+		coarsest := mh.rings[len(mh.rings)-1]
+		if ts.Sub(coarsest.Newest()) > coarsest.Length() {
+			delete(h.metrics, path)
+		}
+	}
+}
+
+// Get returns the history of the metrics at or under path from from to
+// to. The zero value of to means now; the zero value of from means as far
+// back as the finest resolution goes. It uses the finest resolution that
+// covers from and whose step is no more than step. If step is larger than
+// that resolution, Get keeps only the last point in each step.
+func (h *history) Get(
+	path string, from, to time.Time, step time.Duration) messages.HistoryList {
+	now := h.now()
+	if to.IsZero() {
+		to = now
+	}
+	if from.IsZero() {
+		from = to.Add(-h.resolutions[0].Length)
+	}
+	// Find the finest resolution covering from.
+	index := len(h.resolutions) - 1
+	for i, resolution := range h.resolutions {
+		if now.Sub(from) <= resolution.Length {
+			index = i
+			break
+		}
+	}
+	if step < h.resolutions[index].Step {
+		step = h.resolutions[index].Step
+	}
+	path = "/" + newPathSpec(path).String()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	result := make(messages.HistoryList, 0)
+	for metricPath, mh := range h.metrics {
+		if path != "/" && metricPath != path &&
+			!strings.HasPrefix(metricPath, path+"/") {
+			continue
+		}
+		points := mh.rings[index].Points(from, to, step)
+		if points == nil {
+			points = make([]*messages.HistoryPoint, 0)
+		}
+		result = append(result, &messages.MetricHistory{
+			Path:        metricPath,
+			Description: mh.Description,
+			Unit:        mh.Unit,
+			Kind:        mh.Kind,
+			Step:        duration.ToFloat(step),
+			Points:      points,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
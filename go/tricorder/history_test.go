@@ -0,0 +1,197 @@
+package tricorder
+
+import (
+	"encoding/json"
+	"github.com/Symantec/tricorder/go/tricorder/duration"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+func historyValues(h *messages.MetricHistory) (result []float64) {
+	for _, p := range h.Points {
+		result = append(result, p.Value)
+	}
+	return
+}
+
+// kHistoryBase is a time aligned to every step the history tests use.
+var kHistoryBase = kUsualTimeStamp.Truncate(time.Hour)
+
+func TestHistoryRing(t *testing.T) {
+	ring := newHistoryRing(
+		HistoryResolution{Step: time.Second, Length: 3 * time.Second})
+	assertValueEquals(t, 3*time.Second, ring.Length())
+	for i := 0; i < 5; i++ {
+		ring.Add(historyPoint{
+			TimeStamp: kHistoryBase.Add(time.Duration(i) * time.Second),
+			Value:     float64(i)})
+	}
+	// A later sample in the same step replaces the earlier one
+	ring.Add(historyPoint{
+		TimeStamp: kHistoryBase.Add(4500 * time.Millisecond),
+		Value:     4.5})
+	assertValueEquals(
+		t, kHistoryBase.Add(4500*time.Millisecond), ring.Newest())
+	points := ring.Points(
+		kHistoryBase, kHistoryBase.Add(time.Hour), time.Second)
+	if assertValueEquals(t, 3, len(points)) {
+		assertValueEquals(t, 2.0, points[0].Value)
+		assertValueEquals(t, 3.0, points[1].Value)
+		assertValueEquals(t, 4.5, points[2].Value)
+	}
+	// Downsample to 2 second steps
+	points = ring.Points(
+		kHistoryBase, kHistoryBase.Add(time.Hour), 2*time.Second)
+	if assertValueEquals(t, 2, len(points)) {
+		assertValueEquals(t, 3.0, points[0].Value)
+		assertValueEquals(t, 4.5, points[1].Value)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	reg := NewRegistry()
+	var count Counter
+	var name string
+	latency := NewArbitraryBucketer(10.0).NewCumulativeDistribution()
+	reg.RegisterMetric("/a/count", &count, units.None, "count")
+	reg.RegisterMetric("/a/name", &name, units.None, "name")
+	reg.RegisterMetric("/a/latency", latency, units.Millisecond, "latency")
+	reg.RegisterMetric("/b/count", new(Counter), units.None, "other")
+	clock := &fakeClock{now: kHistoryBase}
+	h := newHistory(
+		(*registry)(reg),
+		[]HistoryResolution{
+			{Step: time.Minute, Length: time.Hour},
+			{Step: time.Second, Length: 10 * time.Second},
+		},
+		[]string{"/a"},
+		clock.Now)
+	for i := 0; i < 120; i++ {
+		count.Inc()
+		latency.Add(float64(i))
+		h.sample()
+		clock.Advance(time.Second)
+	}
+	// Recent history comes from the finest resolution
+	list := h.Get("/a", clock.Now().Add(-5*time.Second), time.Time{}, 0)
+	assertValueDeepEquals(
+		t, []string{"/a/count", "/a/latency"}, historyPaths(list))
+	assertValueDeepEquals(
+		t, []float64{116, 117, 118, 119, 120}, historyValues(list[0]))
+	assertValueEquals(t, 1.0, list[0].Step)
+	dist := list[1].Points[4]
+	assertValueEquals(t, uint64(120), dist.Count)
+	assertValueEquals(t, 0.0, dist.Min)
+	assertValueEquals(t, 119.0, dist.Max)
+	assertValueEquals(t, 59.5, dist.Value)
+
+	// Asking for a coarser step downsamples
+	list = h.Get("/a/count", clock.Now().Add(-5*time.Second), time.Time{}, 2*time.Second)
+	assertValueDeepEquals(
+		t, []float64{116, 118, 120}, historyValues(list[0]))
+
+	// Older history comes from the coarser resolution
+	list = h.Get("/a/count", clock.Now().Add(-time.Hour), time.Time{}, 0)
+	assertValueEquals(t, 60.0, list[0].Step)
+	assertValueDeepEquals(
+		t, []float64{60, 120}, historyValues(list[0]))
+
+	assertValueEquals(t, 0, len(h.Get("/b", time.Time{}, time.Time{}, 0)))
+}
+
+func historyPaths(list messages.HistoryList) (result []string) {
+	for _, h := range list {
+		result = append(result, h.Path)
+	}
+	return
+}
+
+func TestHistoryAPI(t *testing.T) {
+	reg := NewRegistry()
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metricsapi/history/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assertValueEquals(t, http.StatusNotFound, resp.StatusCode)
+
+	var count Counter
+	count.Add(3)
+	reg.RegisterMetric("/a/count", &count, units.None, "count")
+	h := reg.RecordHistory(
+		[]HistoryResolution{{Step: time.Hour, Length: 24 * time.Hour}}, "/")
+	defer h.Stop()
+	// Make sure the first sample is in.
+	for i := 0; i < 100; i++ {
+		if len((*history)(h).Get("/", time.Time{}, time.Time{}, 0)) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp, err = http.Get(server.URL + "/metricsapi/history/a?from=-1h&step=2h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list messages.HistoryList
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assertValueEquals(t, 1, len(list)) {
+		assertValueEquals(t, "/a/count", list[0].Path)
+		assertValueEquals(t, 7200.0, list[0].Step)
+		assertValueDeepEquals(t, []float64{3}, historyValues(list[0]))
+	}
+	resp, err = http.Get(server.URL + "/metricsapi/history/a?from=yesterday")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assertValueEquals(t, http.StatusBadRequest, resp.StatusCode)
+
+	rpcServer := rpc.NewServer()
+	reg.RegisterRpc(rpcServer)
+	serverConn, clientConn := net.Pipe()
+	go rpcServer.ServeConn(serverConn)
+	client := rpc.NewClient(clientConn)
+	defer client.Close()
+	var rpcList messages.HistoryList
+	if err := client.Call(
+		"MetricsServer.GetHistory",
+		messages.HistoryRequest{Path: "/a/count"},
+		&rpcList); err != nil {
+		t.Fatal(err)
+	}
+	if assertValueEquals(t, 1, len(rpcList)) {
+		assertValueDeepEquals(t, []float64{3}, historyValues(rpcList[0]))
+	}
+}
+
+func TestParseHistoryTime(t *testing.T) {
+	now := kUsualTimeStamp
+	ts, err := parseHistoryTime("-5m", now)
+	if assertValueEquals(t, nil, err) {
+		assertValueEquals(t, now.Add(-5*time.Minute), ts)
+	}
+	ts, err = parseHistoryTime("1234567890.5", now)
+	if assertValueEquals(t, nil, err) {
+		assertValueEquals(t, 1234567890.5, duration.TimeToFloat(ts))
+	}
+	ts, err = parseHistoryTime("", now)
+	if assertValueEquals(t, nil, err) {
+		assertValueEquals(t, true, ts.IsZero())
+	}
+	if _, err := parseHistoryTime("5m", now); err == nil {
+		t.Error("Expected error")
+	}
+}
@@ -35,8 +35,15 @@ const (
 		  \ {{end}} \
 		\ {{end}} \
 		</table>
+	        \ {{with $top.BarChart .}} \
+	          <svg class="histogram" width="{{.Width}}" height="{{.Height}}">
+	          \ {{range .Bars}} \
+	            <rect x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="{{.Height}}"><title>{{.Title}}</title></rect>
+	          \ {{end}} \
+	          </svg><br>
+	        \ {{end}} \
 	        \ {{if .Count}} \
-		<span class="summary"> min: {{$top.ToFloat32 .Min}} max: {{$top.ToFloat32 .Max}} avg: {{$top.ToFloat32 .Average}} &#126;median: {{$top.ToFloat32 .Median}} sum: {{$top.ToFloat32 .Sum}} count: {{.Count}}</span><br><br>
+		<span class="summary"> min: {{$top.ToFloat32 .Min}} max: {{$top.ToFloat32 .Max}} avg: {{$top.ToFloat32 .Average}} &#126;median: {{$top.ToFloat32 .Median}} sum: {{$top.ToFloat32 .Sum}} count: {{.Count}}{{range .Quantiles}} &#126;{{.Name}}: {{$top.ToFloat32 .Value}}{{end}}{{if .Window}} window: {{.Window}}{{end}}</span><br><br>
 	        \ {{end}} \
 	      \ {{end}} \
 	    \ {{else if .IsList}} \
@@ -46,25 +53,46 @@ const (
 	        <li>{{.}}</li>
 	      \ {{end}} \
 	      </ul>
+	    \ {{else if .Err}} \
+	      {{.Metric.AbsPath}} <span class="error">error: {{.Err}}</span> <span class="parens">({{$top.HtmlType .Metric.Type}}: {{.Metric.Description}}{{if .HasUnit}}; unit: {{.Metric.Unit}}{{end}})</span><br>
 	    \ {{else}} \
-	      {{.Metric.AbsPath}} {{.AsHtmlString}} <span class="parens">({{$top.HtmlType .Metric.Type}}: {{.Metric.Description}}{{if .HasUnit}}; unit: {{.Metric.Unit}}{{end}})</span><br>
+	      {{.Metric.AbsPath}} {{.AsHtmlString}}{{if .IsNumeric}} <svg class="sparkline" width="{{$top.SparklineWidth}}" height="{{$top.SparklineHeight}}" data-path="{{.Metric.AbsPath}}"></svg>{{end}}{{if .IsStale}} <span class="error">stale</span>{{end}} <span class="parens">({{$top.HtmlType .Metric.Type}}: {{.Metric.Description}}{{if .HasUnit}}; unit: {{.Metric.Unit}}{{end}})</span><br>
 	    \ {{end}} \
 	  \ {{end}} \
 	\ {{end}} \
-	<html>
-	<head>
-	  <link rel="stylesheet" type="text/css" href="/metricsstatic/theme.css">
-	</head>
-	<body>
-	\ {{with $top := .}} \
-	  \ {{if .Directory}} \
+	\ {{define "DIRECTORY"}} \
+	  \ {{with $top := .}} \
 	    \ {{range .Directory.List}} \
 	      \ {{if .Directory}} \
-	        <a href="{{$top.Link .Directory}}">{{.Directory.AbsPath}}</a><br>
+	        <details class="directory" data-path="{{.Directory.AbsPath}}"><summary><a href="{{$top.Link .Directory}}">{{.Directory.AbsPath}}</a></summary><div class="contents"></div></details>
               \ {{else}} \
 	        \ {{template "METRIC" $top.AsMetricView .Metric}} \
 	      \ {{end}} \
 	    \ {{end}} \
+	  \ {{end}} \
+	\ {{end}} \
+	<html>
+	<head>
+	  <link rel="stylesheet" type="text/css" href="/metricsstatic/theme.css">
+	  <script src="/metricsstatic/sparkline.js"></script>
+	  <script src="/metricsstatic/browser.js"></script>
+	</head>
+	<body{{if .Refresh}} data-refresh="{{.Refresh}}"{{end}}>
+	<form class="search" method="get" action="/metrics/">
+	  <input type="search" name="q" value="{{.Query}}" placeholder="Search paths and descriptions">
+	  \ {{if .Refresh}} \
+	    <input type="hidden" name="refresh" value="{{.Refresh}}">
+	  \ {{end}} \
+	</form>
+	\ {{with $top := .}} \
+	  \ {{if .Selecting}} \
+	    \ {{range .Matches}} \
+	      \ {{template "METRIC" $top.AsMetricView .}} \
+	    \ {{else}} \
+	      No metrics match.
+	    \ {{end}} \
+	  \ {{else if .Directory}} \
+	    \ {{template "DIRECTORY" .}} \
 	  \ {{else}} \
 	    \ {{template "METRIC" .}} \
 	  \ {{end}} \
@@ -78,7 +106,228 @@ const (
 	themeCss = `
 	.summary {color:#999999; font-style: italic;}
 	.parens {color:#999999;}
+	.error {color:#cc0000;}
+	.histogram rect {fill:#4682b4;}
+	.histogram rect:hover {fill:#cc6600;}
+	.sparkline {vertical-align: middle;}
+	.sparkline polyline {fill: none; stroke:#4682b4; stroke-width: 1;}
+	.search {margin-bottom: 1em;}
+	.search input {width: 30em;}
+	details.directory > .contents {margin-left: 1.5em;}
+	  `
+
+	// sparklineJs polls /metricsapi once per interval for the metrics
+	// directly under each directory with sparklines on the page and draws the recent values of each
+	// metric with a sparkline. It is served from /metricsstatic rather
+	// than inlined in the page because the Content-Security-Policy
+	// forbids inline scripts.
+	sparklineJs = `
+	(function() {
+	  var kPollMillis = 2000;
+	  var kMaxPoints = 60;
+	  var kSvgNs = "http://www.w3.org/2000/svg";
+
+	  function draw(svg, values) {
+	    var width = svg.width.baseVal.value;
+	    var height = svg.height.baseVal.value;
+	    var min = Math.min.apply(null, values);
+	    var max = Math.max.apply(null, values);
+	    var span = max - min;
+	    var points = [];
+	    for (var i = 0; i < values.length; i++) {
+	      var x = width * (i + kMaxPoints - values.length) / (kMaxPoints - 1);
+	      var y = span > 0 ? (height - 1) * (max - values[i]) / span : height / 2;
+	      points.push(x.toFixed(1) + "," + (y + 0.5).toFixed(1));
+	    }
+	    var line = svg.firstChild;
+	    if (!line) {
+	      line = document.createElementNS(kSvgNs, "polyline");
+	      svg.appendChild(line);
+	    }
+	    line.setAttribute("points", points.join(" "));
+	  }
+
+	  // poll lists the metrics directly under dir with one request and
+	  // adds the value of each one that has a sparkline in sparklines
+	  // which maps paths to sparklines.
+	  function poll(dir, sparklines) {
+	    var request = new XMLHttpRequest();
+	    request.onload = function() {
+	      if (request.status != 200) {
+	        return;
+	      }
+	      JSON.parse(request.responseText).forEach(function(metric) {
+	        var sparkline = sparklines[metric.path];
+	        var value = parseFloat(metric.value);
+	        if (!sparkline || isNaN(value)) {
+	          return;
+	        }
+	        sparkline.values.push(value);
+	        if (sparkline.values.length > kMaxPoints) {
+	          sparkline.values.shift();
+	        }
+	        draw(sparkline.svg, sparkline.values);
+	      });
+	    };
+	    request.open("GET", "/metricsapi" + encodeURI(dir || "/") + "?depth=1");
+	    request.send();
+	  }
+
+	  // isShown returns true if any sparkline in sparklines is still on
+	  // the page.
+	  function isShown(sparklines) {
+	    return Object.keys(sparklines).some(function(path) {
+	      return document.body.contains(sparklines[path].svg);
+	    });
+	  }
+
+	  // tricorderStartSparklines starts drawing the sparklines within root.
+	  // It polls each directory that has sparklines once per interval.
+	  window.tricorderStartSparklines = function(root) {
+	    var byDir = {};
+	    var svgs = root.querySelectorAll("svg.sparkline");
+	    Array.prototype.forEach.call(svgs, function(svg) {
+	      var path = svg.getAttribute("data-path");
+	      var dir = path.substring(0, path.lastIndexOf("/"));
+	      byDir[dir] = byDir[dir] || {};
+	      byDir[dir][path] = {svg: svg, values: []};
+	    });
+	    Object.keys(byDir).forEach(function(dir) {
+	      var sparklines = byDir[dir];
+	      poll(dir, sparklines);
+	      var timer = setInterval(function() {
+	        // Stop once the sparklines are gone e.g after a collapse.
+	        if (!isShown(sparklines)) {
+	          clearInterval(timer);
+	        } else if (!document.hidden) {
+	          poll(dir, sparklines);
+	        }
+	      }, kPollMillis);
+	    });
+	  };
+
+	  document.addEventListener("DOMContentLoaded", function() {
+	    window.tricorderStartSparklines(document);
+	  });
+	})();
+	  `
+
+	// browserJs expands directories inline and reloads the page every
+	// refresh seconds when the page has a refresh parameter. Across
+	// reloads it keeps the scroll position and the expanded directories.
+	browserJs = `
+	(function() {
+	  var kOpenKey = "tricorder.open." + location.pathname;
+	  var kScrollKey = "tricorder.scroll." + location.pathname;
+	  var refresh = 0;
+	  var pendingLoads = 0;
+	  var savedScroll = null;
+
+	  function openPaths() {
+	    try {
+	      return JSON.parse(sessionStorage.getItem(kOpenKey)) || [];
+	    } catch (e) {
+	      return [];
+	    }
+	  }
+
+	  function setOpen(path, isOpen) {
+	    var paths = openPaths().filter(function(p) { return p != path; });
+	    if (isOpen) {
+	      paths.push(path);
+	    }
+	    sessionStorage.setItem(kOpenKey, JSON.stringify(paths));
+	  }
+
+	  // restoreScroll scrolls back to where the page was before the last
+	  // reload once every expanded directory has loaded.
+	  function restoreScroll() {
+	    if (savedScroll !== null && pendingLoads == 0) {
+	      window.scrollTo(0, savedScroll);
+	      savedScroll = null;
+	    }
+	  }
+
+	  function expand(details) {
+	    if (details.getAttribute("data-loaded")) {
+	      return;
+	    }
+	    details.setAttribute("data-loaded", "true");
+	    var url = "/metrics" + encodeURI(details.getAttribute("data-path")) +
+	        "?fragment=true";
+	    if (refresh > 0) {
+	      url += "&refresh=" + refresh;
+	    }
+	    var request = new XMLHttpRequest();
+	    pendingLoads++;
+	    request.onloadend = function() {
+	      pendingLoads--;
+	      if (request.status == 200) {
+	        var contents = details.querySelector(".contents");
+	        contents.innerHTML = request.responseText;
+	        init(contents);
+	        if (window.tricorderStartSparklines) {
+	          window.tricorderStartSparklines(contents);
+	        }
+	      } else {
+	        details.removeAttribute("data-loaded");
+	      }
+	      restoreScroll();
+	    };
+	    request.open("GET", url);
+	    request.send();
+	  }
+
+	  function init(root) {
+	    var open = openPaths();
+	    var all = root.querySelectorAll("details.directory");
+	    Array.prototype.forEach.call(all, function(details) {
+	      var path = details.getAttribute("data-path");
+	      details.addEventListener("toggle", function() {
+	        setOpen(path, details.open);
+	        if (details.open) {
+	          expand(details);
+	        }
+	      });
+	      if (open.indexOf(path) != -1) {
+	        details.open = true;
+	        expand(details);
+	      }
+	    });
+	  }
+
+	  function reload() {
+	    var active = document.activeElement;
+	    // Don't interrupt someone typing a search.
+	    if (active && active.name == "q") {
+	      setTimeout(reload, refresh * 1000);
+	      return;
+	    }
+	    sessionStorage.setItem(kScrollKey, String(window.scrollY));
+	    location.reload();
+	  }
+
+	  document.addEventListener("DOMContentLoaded", function() {
+	    refresh = parseInt(document.body.getAttribute("data-refresh")) || 0;
+	    var scroll = sessionStorage.getItem(kScrollKey);
+	    sessionStorage.removeItem(kScrollKey);
+	    if (scroll !== null) {
+	      savedScroll = parseInt(scroll) || 0;
+	    }
+	    init(document);
+	    restoreScroll();
+	    if (refresh > 0) {
+	      setTimeout(reload, refresh * 1000);
+	    }
+	  });
+	})();
 	  `
+
+	kSparklineWidth     = 100
+	kSparklineHeight    = 16
+	kHistogramBarWidth  = 8
+	kHistogramBarGap    = 1
+	kHistogramBarHeight = 40
 )
 
 var (
@@ -104,16 +353,39 @@ type htmlView struct {
 	Directory *directory
 	Metric    *metric
 	Session   *session
+	// The search query, if any
+	Query string
+	// True if showing only the metrics matching the query or selector
+	Selecting bool
+	// The metrics matching the query or selector
+	Matches []*metric
+	// Seconds between reloads. 0 means no reloading.
+	Refresh int
 }
 
 func (v *htmlView) AsMetricView(m *metric) *htmlView {
-	return &htmlView{Metric: m, Session: v.Session}
+	return &htmlView{Metric: m, Session: v.Session, Refresh: v.Refresh}
 }
 
 func (v *htmlView) AsHtmlString() string {
 	return v.Metric.AsHtmlString(v.Session)
 }
 
+// Err returns the error the callback of the metric returned or the empty
+// string if there was no error.
+func (v *htmlView) Err() string {
+	if err := v.Metric.Err(v.Session); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// IsStale returns true if the metric has the value of the last
+// successful update because the update function of its group failed.
+func (v *htmlView) IsStale() bool {
+	return v.Metric.IsStale(v.Session)
+}
+
 func (v *htmlView) HtmlStrings() interface{} {
 	return v.Metric.AsList().HtmlStrings(v.Metric.Unit())
 }
@@ -142,6 +414,9 @@ func (v *htmlView) HasUnit() bool {
 }
 
 func (v *htmlView) Link(d *directory) string {
+	if v.Refresh > 0 {
+		return fmt.Sprintf("%s%s?refresh=%d", htmlUrl, d.AbsPath(), v.Refresh)
+	}
 	return htmlUrl + d.AbsPath()
 }
 
@@ -149,35 +424,170 @@ func (v *htmlView) ToFloat32(f float64) float32 {
 	return float32(f)
 }
 
-func htmlEmitMetric(m *metric, s *session, w io.Writer) error {
-	v := &htmlView{Metric: m, Session: s}
+// IsNumeric returns true if the metric gets a sparkline.
+func (v *htmlView) IsNumeric() bool {
+	t := v.Metric.Type()
+	return t.IsInt() || t.IsUint() || t.IsFloat() || t == types.GoDuration
+}
+
+func (v *htmlView) SparklineWidth() int {
+	return kSparklineWidth
+}
+
+func (v *htmlView) SparklineHeight() int {
+	return kSparklineHeight
+}
+
+// htmlBar is a single bar in a histogram
+type htmlBar struct {
+	X, Y, Width, Height int
+	Title               string
+}
+
+// htmlBarChart is the histogram of a distribution
+type htmlBarChart struct {
+	Width, Height int
+	Bars          []htmlBar
+}
+
+// BarChart returns the histogram of the breakdown of s or nil if s is
+// empty. The histogram spans from the first non empty bucket to the last.
+func (v *htmlView) BarChart(s *snapshot) *htmlBarChart {
+	first, last := -1, -1
+	var maxCount uint64
+	for i, piece := range s.Breakdown {
+		if piece.Count == 0 {
+			continue
+		}
+		if first == -1 {
+			first = i
+		}
+		last = i
+		if piece.Count > maxCount {
+			maxCount = piece.Count
+		}
+	}
+	if first == -1 {
+		return nil
+	}
+	pieces := s.Breakdown[first : last+1]
+	result := &htmlBarChart{
+		Width:  len(pieces)*(kHistogramBarWidth+kHistogramBarGap) - kHistogramBarGap,
+		Height: kHistogramBarHeight,
+		Bars:   make([]htmlBar, len(pieces)),
+	}
+	for i, piece := range pieces {
+		height := int(piece.Count * kHistogramBarHeight / maxCount)
+		// Non empty buckets are always visible
+		if piece.Count > 0 && height == 0 {
+			height = 1
+		}
+		result.Bars[i] = htmlBar{
+			X:      i * (kHistogramBarWidth + kHistogramBarGap),
+			Y:      kHistogramBarHeight - height,
+			Width:  kHistogramBarWidth,
+			Height: height,
+			Title:  fmt.Sprintf("%s: %d", htmlRange(&piece), piece.Count),
+		}
+	}
+	return result
+}
+
+func htmlRange(piece *breakdownPiece) string {
+	if piece.First {
+		return fmt.Sprintf("<%v", float32(piece.End))
+	}
+	if piece.Last {
+		return fmt.Sprintf(">=%v", float32(piece.Start))
+	}
+	return fmt.Sprintf("%v-%v", float32(piece.Start), float32(piece.End))
+}
+
+// htmlOptions are the query parameters of an HTML page.
+type htmlOptions struct {
+	// Search for metrics matching this query
+	Query string
+	// Reload the page every Refresh seconds
+	Refresh int
+	// Emit only the contents of the directory for expanding it inline
+	Fragment bool
+}
+
+func htmlEmitMetric(
+	m *metric, s *session, options *htmlOptions, w io.Writer) error {
+	v := &htmlView{Metric: m, Session: s, Refresh: options.Refresh}
 	if err := htmlTemplate.Execute(w, v); err != nil {
 		return err
 	}
 	return nil
 }
 
-func htmlEmitDirectory(d *directory, s *session, w io.Writer) error {
-	v := &htmlView{Directory: d, Session: s}
+func htmlEmitDirectory(
+	d *directory, s *session, options *htmlOptions, w io.Writer) error {
+	v := &htmlView{Directory: d, Session: s, Refresh: options.Refresh}
+	if options.Fragment {
+		return htmlTemplate.ExecuteTemplate(w, "DIRECTORY", v)
+	}
 	if err := htmlTemplate.Execute(w, v); err != nil {
 		return err
 	}
 	return nil
 }
 
-func htmlEmitDirectoryOrMetric(
-	path string, w http.ResponseWriter) error {
-	d, m := root.GetDirectoryOrMetric(path)
+func htmlEmitMatches(
+	root, d *directory, m *metric, sel *selector, s *session,
+	options *htmlOptions, w io.Writer) error {
+	var matches metricListCollector
+	if err := root.GetAllMetricsBySelector(
+		sel.WithSearch(options.Query), &matches, s); err != nil {
+		return err
+	}
+	v := &htmlView{
+		Directory: d,
+		Metric:    m,
+		Session:   s,
+		Query:     options.Query,
+		Selecting: true,
+		Matches:   matches,
+		Refresh:   options.Refresh,
+	}
+	return htmlTemplate.Execute(w, v)
+}
+
+// htmlEmitDirectoryOrMetric emits the metrics that sel selects. When
+// sel is a plain path with no search query, it emits the directory or
+// metric at that path.
+func (reg *registry) htmlEmitDirectoryOrMetric(
+	sel *selector, options *htmlOptions, w http.ResponseWriter) error {
+	d, m := reg.root.getDirectoryOrMetric(sel.base)
 	if d == nil && m == nil {
 		fmt.Fprintf(w, "Path does not exist.")
 		return nil
 	}
 	s := newSession()
 	defer s.Close()
+	if options.Query != "" || !sel.IsPath() {
+		return htmlEmitMatches(reg.root, d, m, sel, s, options, w)
+	}
 	if m == nil {
-		return htmlEmitDirectory(d, s, w)
+		return htmlEmitDirectory(d, s, options, w)
 	}
-	return htmlEmitMetric(m, s, w)
+	return htmlEmitMetric(m, s, options, w)
+}
+
+// metricListCollector collects metrics including those whose callbacks
+// returned an error.
+type metricListCollector []*metric
+
+func (c *metricListCollector) Collect(m *metric, s *session) error {
+	*c = append(*c, m)
+	return nil
+}
+
+func (c *metricListCollector) CollectError(
+	m *metric, s *session, err error) error {
+	*c = append(*c, m)
+	return nil
 }
 
 type textCollector struct {
@@ -204,6 +614,16 @@ func textEmitDistribution(s *snapshot, w io.Writer) error {
 	if err != nil {
 		return err
 	}
+	for i := range s.Quantiles {
+		_, err := fmt.Fprintf(
+			w,
+			";%s:%s",
+			s.Quantiles[i].Name(),
+			strconv.FormatFloat(s.Quantiles[i].Value, 'f', -1, 32))
+		if err != nil {
+			return err
+		}
+	}
 	for _, piece := range s.Breakdown {
 		if piece.Count == 0 {
 			continue
@@ -257,17 +677,27 @@ func textEmitMetric(m *metric, s *session, w io.Writer) error {
 	return err
 }
 
-func textEmitDirectoryOrMetric(
-	path string, w http.ResponseWriter) error {
-	d, m := root.GetDirectoryOrMetric(path)
+func (reg *registry) textEmitDirectoryOrMetric(
+	sel *selector, query string, w http.ResponseWriter) error {
+	d, m := reg.root.getDirectoryOrMetric(sel.base)
 	if d == nil && m == nil {
 		fmt.Fprintf(w, "*Path does not exist.*")
 		return nil
 	}
+	if query != "" || !sel.IsPath() {
+		return reg.root.GetAllMetricsBySelector(
+			sel.WithSearch(query), &textCollector{W: w}, nil)
+	}
 	if m == nil {
 		return d.GetAllMetrics(&textCollector{W: w}, nil)
 	}
-	return textEmitMetric(m, nil, w)
+	s := newSession()
+	defer s.Close()
+	if err := m.Err(s); err != nil {
+		fmt.Fprintf(w, "*Error: %v*\n", err)
+		return nil
+	}
+	return textEmitMetric(m, s, w)
 }
 
 func setSecurityHeaders(w http.ResponseWriter) {
@@ -276,16 +706,31 @@ func setSecurityHeaders(w http.ResponseWriter) {
 	w.Header().Set("Content-Security-Policy", "default-src 'self' ;style-src 'self' 'unsafe-inline'")
 }
 
-func htmlAndTextHandlerFunc(w http.ResponseWriter, r *http.Request) {
+func (reg *registry) htmlAndTextHandlerFunc(
+	w http.ResponseWriter, r *http.Request) {
 	setSecurityHeaders(w)
 	r.ParseForm()
-	path := r.URL.Path
-	var err error
-	if r.Form.Get("format") == "text" {
+	sel, err := requestSelector(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+	query := r.Form.Get("q")
+	switch r.Form.Get("format") {
+	case "text":
 		w.Header().Set("Content-Type", "text/plain")
-		err = textEmitDirectoryOrMetric(path, w)
-	} else {
-		err = htmlEmitDirectoryOrMetric(path, w)
+		err = reg.textEmitDirectoryOrMetric(sel, query, w)
+	case "prometheus":
+		err = reg.prometheusEmitDirectoryOrMetric(sel, w)
+	default:
+		options := &htmlOptions{
+			Query:    query,
+			Fragment: r.Form.Get("fragment") == "true",
+		}
+		if refresh, err := strconv.Atoi(r.Form.Get("refresh")); err == nil && refresh > 0 {
+			options.Refresh = refresh
+		}
+		err = reg.htmlEmitDirectoryOrMetric(sel, options, w)
 	}
 	if err != nil {
 		handleError(w, err)
@@ -300,16 +745,22 @@ func hasTricorderHandler(w http.ResponseWriter, r *http.Request) {
 func newStatic() http.Handler {
 	result := http.NewServeMux()
 	addStatic(result, "/theme.css", themeCss)
+	addStatic(result, "/sparkline.js", sparklineJs)
+	addStatic(result, "/browser.js", browserJs)
 	return result
 }
 
-func initHtmlHandlers() {
-	http.Handle(
+func (reg *registry) registerHtmlHandlers(mux *http.ServeMux) {
+	mux.Handle(
 		htmlUrl+"/",
 		http.StripPrefix(
-			htmlUrl, http.HandlerFunc(htmlAndTextHandlerFunc)))
-	http.HandleFunc(hasTricorderUrl, hasTricorderHandler)
-	http.Handle(
+			htmlUrl, http.HandlerFunc(reg.htmlAndTextHandlerFunc)))
+	mux.HandleFunc(hasTricorderUrl, hasTricorderHandler)
+	mux.Handle(
 		"/metricsstatic/",
 		http.StripPrefix("/metricsstatic", newStatic()))
 }
+
+func initHtmlHandlers() {
+	defaultRegistry.registerHtmlHandlers(http.DefaultServeMux)
+}
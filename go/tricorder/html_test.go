@@ -0,0 +1,148 @@
+package tricorder
+
+import (
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBarChart(t *testing.T) {
+	dist := NewArbitraryBucketer(10.0, 20.0, 30.0, 40.0).NewCumulativeDistribution()
+	(*distribution)(dist).SetUnit(units.Millisecond)
+	v := &htmlView{}
+	assertValueEquals(t, (*htmlBarChart)(nil), v.BarChart((*distribution)(dist).Snapshot()))
+	for i := 0; i < 4; i++ {
+		dist.Add(15.0)
+	}
+	dist.Add(35.0)
+	chart := v.BarChart((*distribution)(dist).Snapshot())
+	// Only 10-20 through 30-40
+	if assertValueEquals(t, 3, len(chart.Bars)) {
+		assertValueEquals(t, htmlBar{
+			X: 0, Y: 0, Width: kHistogramBarWidth,
+			Height: kHistogramBarHeight, Title: "10-20: 4"}, chart.Bars[0])
+		assertValueEquals(t, 0, chart.Bars[1].Height)
+		assertValueEquals(t, kHistogramBarHeight/4, chart.Bars[2].Height)
+	}
+	assertValueEquals(t, 3*kHistogramBarWidth+2*kHistogramBarGap, chart.Width)
+}
+
+func getBody(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValueEquals(t, http.StatusOK, resp.StatusCode)
+	return string(body)
+}
+
+func TestHtmlCharts(t *testing.T) {
+	reg := NewRegistry()
+	var count Counter
+	var name string
+	dist := PowersOfTen.NewCumulativeDistribution()
+	reg.RegisterMetric("/a/count", &count, units.None, "count")
+	reg.RegisterMetric("/a/name", &name, units.None, "name")
+	reg.RegisterMetric("/a/dist", dist, units.None, "dist")
+	dist.Add(5.0)
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	page := getBody(t, server.URL+"/metrics/a")
+	for _, expected := range []string{
+		`<script src="/metricsstatic/sparkline.js"></script>`,
+		`<svg class="histogram"`,
+		`<title>1-10: 1</title>`,
+		`data-path="/a/count"`,
+	} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("Expected %s in page", expected)
+		}
+	}
+	if strings.Contains(page, `data-path="/a/name"`) {
+		t.Error("Expected no sparkline for strings")
+	}
+	js := getBody(t, server.URL+"/metricsstatic/sparkline.js")
+	if !strings.Contains(js, "/metricsapi") || !strings.Contains(js, "depth=1") {
+		t.Error("Expected sparkline.js to poll /metricsapi one level deep")
+	}
+	if strings.Contains(js, "singleton") {
+		t.Error("Expected sparkline.js to poll directories, not metrics")
+	}
+}
+
+func TestHtmlSearch(t *testing.T) {
+	reg := NewRegistry()
+	var requests, errors Counter
+	var temperature float64
+	reg.RegisterMetric("/net/requests", &requests, units.None, "Request count")
+	reg.RegisterMetric("/net/errors", &errors, units.None, "Failed requests")
+	reg.RegisterMetric("/hw/temperature", &temperature, units.Celsius, "CPU")
+	var fanEvaluated bool
+	reg.RegisterMetric(
+		"/hw/fan",
+		func() int64 {
+			fanEvaluated = true
+			return 0
+		},
+		units.None,
+		"Fan speed")
+	server := httptest.NewServer(reg)
+	defer server.Close()
+
+	// Matches path or description ignoring case
+	page := getBody(t, server.URL+"/metrics/?q=REQUEST&refresh=5")
+	for _, expected := range []string{
+		"/net/requests", "/net/errors", `value="REQUEST"`,
+		`action="/metrics/"`,
+		`name="refresh" value="5"`, `data-refresh="5"`} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("Expected %s in page", expected)
+		}
+	}
+	if strings.Contains(page, "/hw/temperature") {
+		t.Error("Expected no /hw/temperature in page")
+	}
+	// Metrics that don't match never get evaluated
+	assertValueEquals(t, false, fanEvaluated)
+	page = getBody(t, server.URL+"/metrics/hw?q=request")
+	if !strings.Contains(page, "No metrics match.") {
+		t.Error("Expected no matches under /hw")
+	}
+	assertValueEquals(
+		t,
+		"/net/errors 0\n",
+		getBody(t, server.URL+"/metrics/?q=failed&format=text"))
+}
+
+func TestHtmlDirectories(t *testing.T) {
+	reg := NewRegistry()
+	var value int64
+	reg.RegisterMetric("/a/b/value", &value, units.None, "value")
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	page := getBody(t, server.URL+"/metrics/a?refresh=10")
+	for _, expected := range []string{
+		`<script src="/metricsstatic/browser.js"></script>`,
+		`<details class="directory" data-path="/a/b">`,
+		`href="/metrics/a/b?refresh=10"`} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("Expected %s in page", expected)
+		}
+	}
+	fragment := getBody(t, server.URL+"/metrics/a/b?fragment=true")
+	if !strings.Contains(fragment, "/a/b/value") {
+		t.Error("Expected /a/b/value in fragment")
+	}
+	if strings.Contains(fragment, "<html>") {
+		t.Error("Expected fragment without page")
+	}
+	getBody(t, server.URL+"/metricsstatic/browser.js")
+}
@@ -58,6 +58,19 @@ func (w *gzipResponseWriter) Write(b []byte) (int, error) {
 	return w.W.Write(b)
 }
 
+// Flush flushes any compressed data and then the underlying
+// ResponseWriter so that streaming responses reach the client right away.
+func (w *gzipResponseWriter) Flush() {
+	if f, ok := w.W.(interface {
+		Flush() error
+	}); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 type gzipHandler struct {
 	H http.Handler
 }
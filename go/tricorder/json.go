@@ -3,12 +3,18 @@ package tricorder
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"github.com/Symantec/tricorder/go/tricorder/duration"
 	"github.com/Symantec/tricorder/go/tricorder/messages"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
-	jsonUrl = "/metricsapi"
+	jsonUrl        = "/metricsapi"
+	jsonHistoryUrl = jsonUrl + "/history"
 )
 
 func jsonAsMetric(m *metric, s *session) *messages.Metric {
@@ -24,19 +30,68 @@ func (c *jsonMetricsCollector) Collect(m *metric, s *session) (err error) {
 	return nil
 }
 
+// CollectError collects m like Collect. The collected metric reports
+// the callback error in its Err field.
+func (c *jsonMetricsCollector) CollectError(
+	m *metric, s *session, err error) error {
+	return c.Collect(m, s)
+}
+
 func jsonSetUpHeaders(h http.Header) {
 	h.Set("Content-Type", "application/json")
 	h.Set("X-Tricorder-Media-Type", "tricorder.v1")
 }
 
-func jsonHandlerFunc(w http.ResponseWriter, r *http.Request) {
+func (reg *registry) jsonHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	jsonSetUpHeaders(w.Header())
 	path := r.URL.Path
+	sel, err := requestSelector(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+	if depth := r.Form.Get("depth"); depth != "" {
+		maxDepth, err := strconv.Atoi(depth)
+		if err != nil {
+			httpError(w, http.StatusBadRequest)
+			return
+		}
+		sel = sel.WithMaxDepth(path, maxDepth)
+	}
+	if watch := r.Form.Get("watch"); watch != "" {
+		interval, err := time.ParseDuration(watch)
+		if err != nil || interval <= 0 {
+			httpError(w, http.StatusBadRequest)
+			return
+		}
+		reg.jsonWatch(w, r, sel, interval)
+		return
+	}
 	var content []byte
-	var err error
-	if r.Form.Get("singleton") != "" {
-		m := root.GetMetric(path)
+	if list := r.Form.Get("list"); list != "" {
+		request, ok := jsonMetadataRequest(path, list, r.Form.Get("depth"))
+		if !ok {
+			httpError(w, http.StatusBadRequest)
+			return
+		}
+		metadata := reg.listMetadata(request)
+		for _, m := range metadata {
+			m.ConvertToJson()
+		}
+		content, err = json.Marshal(metadata)
+	} else if since, ok := r.Form["since"]; ok {
+		changed, sinceErr := reg.changedSince(sel, since[0])
+		if sinceErr != nil {
+			httpError(w, http.StatusBadRequest)
+			return
+		}
+		for _, m := range changed.Metrics {
+			m.ConvertToJson()
+		}
+		content, err = json.Marshal(changed)
+	} else if r.Form.Get("singleton") != "" {
+		m := reg.root.GetMetric(path)
 		if m == nil {
 			httpError(w, http.StatusNotFound)
 			return
@@ -44,7 +99,7 @@ func jsonHandlerFunc(w http.ResponseWriter, r *http.Request) {
 		content, err = json.Marshal(jsonAsMetric(m, nil))
 	} else {
 		collector := make(jsonMetricsCollector, 0)
-		root.GetAllMetricsByPath(path, &collector, nil)
+		reg.root.GetAllMetricsBySelector(sel, &collector, nil)
 		content, err = json.Marshal(collector)
 	}
 	if err != nil {
@@ -56,6 +111,129 @@ func jsonHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	buffer.WriteTo(w)
 }
 
+// jsonMetadataRequest returns the request for the list and depth query
+// parameters. list is either "names" or "metadata"; depth is empty or a
+// number. jsonMetadataRequest returns false if either is bad.
+func jsonMetadataRequest(path, list, depth string) (
+	*messages.MetadataRequest, bool) {
+	result := &messages.MetadataRequest{Path: path}
+	switch list {
+	case "names":
+		result.NamesOnly = true
+	case "metadata":
+	default:
+		return nil, false
+	}
+	if depth != "" {
+		maxDepth, err := strconv.Atoi(depth)
+		if err != nil {
+			return nil, false
+		}
+		result.MaxDepth = maxDepth
+	}
+	return result, true
+}
+
+// jsonWatch streams the metrics sel selects that change as Server-Sent
+// Events until the client goes away. The data of each event is a json
+// array of the metrics that changed.
+func (reg *registry) jsonWatch(
+	w http.ResponseWriter,
+	r *http.Request,
+	sel *selector,
+	interval time.Duration) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, http.StatusNotImplemented)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	watcher := reg.watch(sel, interval)
+	defer watcher.Stop()
+	// Send headers right away
+	flusher.Flush()
+	for {
+		select {
+		case changed, ok := <-watcher.C:
+			if !ok {
+				return
+			}
+			for _, m := range changed {
+				m.ConvertToJson()
+			}
+			content, err := json.Marshal(changed)
+			if err != nil {
+				handleError(w, err)
+				return
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", content); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// parseHistoryTime parses a time given as seconds since Jan 1, 1970 or as
+// a negative duration relative to now such as "-5m". The empty string
+// means the zero time.
+func parseHistoryTime(str string, now time.Time) (time.Time, error) {
+	if str == "" {
+		return time.Time{}, nil
+	}
+	if strings.HasPrefix(str, "-") {
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(d), nil
+	}
+	seconds, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return duration.FloatToTime(seconds), nil
+}
+
+func (reg *registry) jsonHistoryHandlerFunc(
+	w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	jsonSetUpHeaders(w.Header())
+	h := reg.getHistory()
+	if h == nil {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	from, fromErr := parseHistoryTime(r.Form.Get("from"), now)
+	to, toErr := parseHistoryTime(r.Form.Get("to"), now)
+	var step time.Duration
+	var stepErr error
+	if stepStr := r.Form.Get("step"); stepStr != "" {
+		step, stepErr = time.ParseDuration(stepStr)
+	}
+	if fromErr != nil || toErr != nil || stepErr != nil || step < 0 {
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+	content, err := json.Marshal(h.Get(r.URL.Path, from, to, step))
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	var buffer bytes.Buffer
+	json.Indent(&buffer, content, "", "\t")
+	buffer.WriteTo(w)
+}
+
+func (reg *registry) registerJsonHandlers(mux *http.ServeMux) {
+	mux.Handle(jsonUrl+"/", http.StripPrefix(jsonUrl, gzipHandler{http.HandlerFunc(reg.jsonHandlerFunc)}))
+	mux.Handle(jsonHistoryUrl+"/", http.StripPrefix(jsonHistoryUrl, gzipHandler{http.HandlerFunc(reg.jsonHistoryHandlerFunc)}))
+}
+
 func initJsonHandlers() {
-	http.Handle(jsonUrl+"/", http.StripPrefix(jsonUrl, gzipHandler{http.HandlerFunc(jsonHandlerFunc)}))
+	defaultRegistry.registerJsonHandlers(http.DefaultServeMux)
 }
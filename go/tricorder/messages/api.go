@@ -14,6 +14,9 @@ var (
 	// The MetricServer.GetMetric RPC call returns this if no
 	// metric with given path exists.
 	ErrMetricNotFound = errors.New("messages: No metric found.")
+	// The MetricServer.GetHistory RPC call returns this if the process
+	// records no history.
+	ErrNoHistory = errors.New("messages: No history recorded.")
 )
 
 // RangeWithCount represents the number of values within a
@@ -29,6 +32,37 @@ type RangeWithCount struct {
 	Count uint64 `json:"count"`
 }
 
+// QuantileValue represents the estimated value at a particular quantile
+// of a distribution.
+type QuantileValue struct {
+	// The quantile between 0 and 1 e.g 0.99 for the 99th percentile.
+	Quantile float64 `json:"quantile"`
+	// The estimated value at the quantile.
+	Value float64 `json:"value"`
+}
+
+// Sketch represents the buckets of a distribution with bounded relative
+// error in sparse form. Positive bucket i holds values in
+// (gamma^(i-1), gamma^i] where gamma is
+// (1 + RelativeAccuracy) / (1 - RelativeAccuracy). Negative bucket i
+// holds values in [-gamma^i, -gamma^(i-1)). Only non-empty buckets are
+// included.
+type Sketch struct {
+	// The relative accuracy of the sketch e.g 0.01 for 1%.
+	RelativeAccuracy float64 `json:"relativeAccuracy"`
+	// The indexes of the non-empty positive buckets in ascending order
+	Indexes []int32 `json:"indexes,omitempty"`
+	// The counts of the non-empty positive buckets. Counts[i] is the
+	// count of the bucket at Indexes[i].
+	Counts []uint64 `json:"counts,omitempty"`
+	// The indexes of the non-empty negative buckets in ascending order
+	NegativeIndexes []int32 `json:"negativeIndexes,omitempty"`
+	// The counts of the non-empty negative buckets.
+	NegativeCounts []uint64 `json:"negativeCounts,omitempty"`
+	// The number of values that are zero or very close to zero.
+	ZeroCount uint64 `json:"zeroCount,omitempty"`
+}
+
 // Distribution represents a distribution of values.
 type Distribution struct {
 	// The minimum value
@@ -47,8 +81,17 @@ type Distribution struct {
 	Generation uint64 `json:"generation"`
 	// This field is true if this distribution is not cumulative.
 	IsNotCumulative bool `json:"isNotCumulative,omitempty"`
-	// The number of values within each range
+	// The number of values within each range. Nil if Sketch is non-nil.
 	Ranges []*RangeWithCount `json:"ranges,omitempty"`
+	// The approximate values of the quantiles the distribution exports
+	// in ascending order by quantile.
+	Quantiles []*QuantileValue `json:"quantiles,omitempty"`
+	// If non-zero, the distribution includes only values added within
+	// this many seconds.
+	Window float64 `json:"window,omitempty"`
+	// The buckets of a sketch distribution. Sketch distributions from
+	// different processes can be merged.
+	Sketch *Sketch `json:"sketch,omitempty"`
 }
 
 func (d *Distribution) Type() types.Type {
@@ -122,6 +165,27 @@ type Metric struct {
 	// GroupId of the metric's region. Metrics with the same group Id
 	// will always have the same timestamp.
 	GroupId int `json:"groupId"`
+	// If non-empty, the error the metric's callback function returned.
+	// In that case, Value is nil.
+	Err string `json:"err,omitempty"`
+	// If this metric belongs to a family such as a tricorder.CounterVec,
+	// the label names of the family mapped to the label values of this
+	// metric.
+	Labels map[string]string `json:"labels,omitempty"`
+	// True if the value of this metric never decreases such as with a
+	// tricorder.Counter. A decrease means that the process restarted.
+	IsMonotonic bool `json:"isMonotonic,omitempty"`
+	// True if the update function of the metric's group timed out or
+	// panicked. In that case, Value and TimeStamp come from the last
+	// successful update.
+	IsStale bool `json:"isStale,omitempty"`
+	// True if the value of this metric includes a value that an earlier
+	// process saved with tricorder.Persist.
+	IsRestored bool `json:"isRestored,omitempty"`
+	// If IsRestored is true, identifies the earlier process that saved
+	// the value this metric includes. A change in Epoch means that the
+	// process restarted.
+	Epoch string `json:"epoch,omitempty"`
 }
 
 // ConvertToGoRPC changes this metric in place to be go rpc compatible.
@@ -134,11 +198,185 @@ func (m *Metric) ConvertToJson() {
 	m.convertToJson()
 }
 
+// ChangedMetrics represents the metrics that changed since a particular
+// token.
+type ChangedMetrics struct {
+	// Pass this token in the next query to get only the metrics that
+	// change after this query.
+	Token string `json:"token"`
+	// The metrics that changed
+	Metrics MetricList `json:"metrics"`
+}
+
+// ChangedSinceRequest represents a request for the metrics under a path
+// that changed since a token.
+type ChangedSinceRequest struct {
+	// The absolute path
+	Path string
+	// The token from the previous query. The empty string means return
+	// every metric.
+	Since string
+}
+
+// HistoryPoint represents the value of a metric at one point in time.
+type HistoryPoint struct {
+	// Seconds since Jan 1, 1970
+	TimeStamp float64 `json:"timestamp"`
+	// The value of the metric or the average value for distributions.
+	// Durations are in seconds.
+	Value float64 `json:"value"`
+	// For distributions only, the count, sum, minimum, and maximum of the
+	// values in the distribution.
+	Count uint64  `json:"count,omitempty"`
+	Sum   float64 `json:"sum,omitempty"`
+	Min   float64 `json:"min,omitempty"`
+	Max   float64 `json:"max,omitempty"`
+}
+
+// MetricHistory represents the recorded history of a metric.
+type MetricHistory struct {
+	// The absolute path to the metric
+	Path string `json:"path"`
+	// The description of the metric
+	Description string `json:"description"`
+	// The unit of measurement of the values
+	Unit units.Unit `json:"unit,omitempty"`
+	// The metric's type
+	Kind types.Type `json:"kind"`
+	// Seconds between points
+	Step float64 `json:"step"`
+	// The points in chronological order
+	Points []*HistoryPoint `json:"points"`
+}
+
+// HistoryList represents the histories of several metrics.
+type HistoryList []*MetricHistory
+
+// HistoryRequest represents a request for the history of the metrics at
+// or under a path.
+type HistoryRequest struct {
+	// The absolute path
+	Path string
+	// The time range. The zero value of To means now; the zero value
+	// of From means as far back as the finest resolution goes.
+	From time.Time
+	To   time.Time
+	// The minimum time between points. Zero means use the finest
+	// resolution available for the time range.
+	Step time.Duration
+}
+
+// Metadata represents a directory or a metric without its value.
+type Metadata struct {
+	// The absolute path
+	Path string `json:"path"`
+	// True if this is a directory. The rest of the fields are for
+	// metrics only.
+	IsDirectory bool `json:"isDirectory,omitempty"`
+	// The remaining fields are the same as in Metric. They are empty
+	// when listing names only.
+	Description string     `json:"description,omitempty"`
+	Unit        units.Unit `json:"unit,omitempty"`
+	Kind        types.Type `json:"kind,omitempty"`
+	SubType     types.Type `json:"subType,omitempty"`
+	Bits        int        `json:"bits,omitempty"`
+}
+
+// ConvertToJson changes this metadata in place to be json compatible.
+func (m *Metadata) ConvertToJson() {
+	m.convertToJson()
+}
+
+// ConvertToGoRPC changes this metadata in place to be go rpc compatible.
+func (m *Metadata) ConvertToGoRPC() {
+	m.convertToGoRPC()
+}
+
+// MetadataList represents a directory listing. Directories come before
+// their contents.
+type MetadataList []*Metadata
+
+// MetadataRequest represents a request to list the directories and
+// metrics under a path without the values of the metrics.
+type MetadataRequest struct {
+	// The absolute path
+	Path string
+	// How many levels under Path to list. 1 means list only what is
+	// directly under Path. 0 or less means no limit.
+	MaxDepth int
+	// If true, list only the paths of directories and metrics.
+	NamesOnly bool
+}
+
 // MetricList represents a list of metrics. Clients should treat MetricList
 // instances as immutable. In particular, clients should not modify contained
 // Metric instances in place.
 type MetricList []*Metric
 
+// RangeDelta represents the change in the number of values within a
+// particular range of a distribution.
+type RangeDelta struct {
+	// Same as in RangeWithCount
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+	// The new count minus the old count
+	Count int64 `json:"count"`
+}
+
+// DistributionDelta represents the change in a distribution.
+type DistributionDelta struct {
+	// The new count minus the old count
+	Count int64 `json:"count"`
+	// The new sum minus the old sum
+	Sum float64 `json:"sum"`
+	// The change in each range. Nil if the two distributions have
+	// different ranges.
+	Ranges []*RangeDelta `json:"ranges,omitempty"`
+}
+
+// MetricDiff represents how a metric with a particular path differs
+// between two metric lists. Fields that did not change are empty.
+type MetricDiff struct {
+	// The absolute path
+	Path string `json:"path"`
+	// The old and new descriptions if the description changed.
+	OldDescription string `json:"oldDescription,omitempty"`
+	NewDescription string `json:"newDescription,omitempty"`
+	// The old and new units if the unit changed.
+	OldUnit units.Unit `json:"oldUnit,omitempty"`
+	NewUnit units.Unit `json:"newUnit,omitempty"`
+	// The old and new kinds if the kind changed. Values of different
+	// kinds are not compared.
+	OldKind types.Type `json:"oldKind,omitempty"`
+	NewKind types.Type `json:"newKind,omitempty"`
+	// For numeric metrics, the new value minus the old value. Durations
+	// and times are in the unit of the metric or seconds if the unit is
+	// not a time unit.
+	Delta *float64 `json:"delta,omitempty"`
+	// For distributions, the change in the distribution.
+	Distribution *DistributionDelta `json:"distribution,omitempty"`
+	// For all other metrics, true if the value changed.
+	ValueChanged bool `json:"valueChanged,omitempty"`
+}
+
+// MetricListDiff represents the differences between two metric lists.
+type MetricListDiff struct {
+	// The paths of the metrics found only in the new list
+	Added []string `json:"added"`
+	// The paths of the metrics found only in the old list
+	Removed []string `json:"removed"`
+	// The metrics in both lists that differ in ascending order by path
+	Changed []*MetricDiff `json:"changed"`
+}
+
+// Diff compares the metrics in before with the metrics in after.
+// The metrics may be in either Go RPC or JSON form including JSON decoded
+// with json.Decoder.UseNumber. Diff subtracts distributions with the same
+// ranges range by range.
+func Diff(before, after MetricList) *MetricListDiff {
+	return diff(before, after)
+}
+
 // AsJsonWithSubType takes a metric value, kind, subtype, and unit and returns
 // an acceptable JSON value, kind, and subType for given unit.
 // If kind is types.List, subType indicates the type of elements in the list.
@@ -0,0 +1,193 @@
+package messages
+
+import (
+	"encoding/json"
+	"github.com/Symantec/tricorder/go/tricorder/duration"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+func diff(before, after MetricList) *MetricListDiff {
+	beforeByPath := make(map[string]*Metric, len(before))
+	for _, m := range before {
+		beforeByPath[m.Path] = m
+	}
+	result := &MetricListDiff{
+		Added:   make([]string, 0),
+		Removed: make([]string, 0),
+		Changed: make([]*MetricDiff, 0),
+	}
+	afterPaths := make(map[string]bool, len(after))
+	for _, m := range after {
+		afterPaths[m.Path] = true
+		old, ok := beforeByPath[m.Path]
+		if !ok {
+			result.Added = append(result.Added, m.Path)
+			continue
+		}
+		if d := diffMetric(old, m); d != nil {
+			result.Changed = append(result.Changed, d)
+		}
+	}
+	for _, m := range before {
+		if !afterPaths[m.Path] {
+			result.Removed = append(result.Removed, m.Path)
+		}
+	}
+	sort.Strings(result.Added)
+	sort.Strings(result.Removed)
+	sort.Slice(result.Changed, func(i, j int) bool {
+		return result.Changed[i].Path < result.Changed[j].Path
+	})
+	return result
+}
+
+// diffMetric returns how old and m differ or nil if they don't.
+func diffMetric(old, m *Metric) *MetricDiff {
+	result := &MetricDiff{Path: m.Path}
+	changed := false
+	if old.Description != m.Description {
+		result.OldDescription = old.Description
+		result.NewDescription = m.Description
+		changed = true
+	}
+	if old.Unit != m.Unit {
+		result.OldUnit = old.Unit
+		result.NewUnit = m.Unit
+		changed = true
+	}
+	oldKind, kind := jsonKind(old.Kind), jsonKind(m.Kind)
+	if oldKind != kind {
+		result.OldKind = oldKind
+		result.NewKind = kind
+		return result
+	}
+	if old.Err != "" || m.Err != "" {
+		if old.Err != m.Err {
+			result.ValueChanged = true
+			changed = true
+		}
+	} else if kind == types.Dist {
+		oldDist, newDist := asDistribution(old.Value), asDistribution(m.Value)
+		if oldDist != nil && newDist != nil {
+			if d := diffDistribution(oldDist, newDist); d != nil {
+				result.Distribution = d
+				changed = true
+			}
+		}
+	} else if oldValue, ok := asFloat(old.Value, kind, old.Unit); ok {
+		if value, ok := asFloat(m.Value, kind, m.Unit); ok && value != oldValue {
+			delta := value - oldValue
+			result.Delta = &delta
+			changed = true
+		}
+	} else if !reflect.DeepEqual(old.Value, m.Value) {
+		result.ValueChanged = true
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return result
+}
+
+// asDistribution returns value as a distribution. value is either a
+// *Distribution or a distribution decoded from JSON into a map. It
+// returns nil if value is neither.
+func asDistribution(value interface{}) *Distribution {
+	switch v := value.(type) {
+	case *Distribution:
+		return v
+	case map[string]interface{}:
+		content, err := json.Marshal(v)
+		if err != nil {
+			return nil
+		}
+		var result Distribution
+		if json.Unmarshal(content, &result) != nil {
+			return nil
+		}
+		return &result
+	default:
+		return nil
+	}
+}
+
+// asFloat returns value, of the given JSON kind, as a float64 in unit.
+// Durations and times in JSON form are already in unit. It returns false
+// if value is not a number, duration, or time.
+func asFloat(
+	value interface{}, kind types.Type, unit units.Unit) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		result, err := v.Float64()
+		return result, err == nil
+	case string:
+		if kind != types.Duration && kind != types.Time {
+			return 0, false
+		}
+		result, err := strconv.ParseFloat(v, 64)
+		return result, err == nil
+	case time.Duration:
+		return duration.ToFloat(v) * units.FromSeconds(unit), true
+	case time.Time:
+		return duration.TimeToFloat(v) * units.FromSeconds(unit), true
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// diffDistribution returns how old and d differ or nil if they don't.
+func diffDistribution(old, d *Distribution) *DistributionDelta {
+	result := &DistributionDelta{
+		Count: int64(d.Count) - int64(old.Count),
+		Sum:   d.Sum - old.Sum,
+	}
+	if sameRanges(old.Ranges, d.Ranges) {
+		for i, r := range d.Ranges {
+			delta := int64(r.Count) - int64(old.Ranges[i].Count)
+			result.Ranges = append(result.Ranges, &RangeDelta{
+				Lower: r.Lower, Upper: r.Upper, Count: delta})
+		}
+	}
+	if result.Count == 0 && result.Sum == 0 && !anyRangeChanged(result.Ranges) {
+		return nil
+	}
+	return result
+}
+
+func sameRanges(old, ranges []*RangeWithCount) bool {
+	if len(old) != len(ranges) || len(ranges) == 0 {
+		return false
+	}
+	for i := range ranges {
+		if old[i].Lower != ranges[i].Lower || old[i].Upper != ranges[i].Upper {
+			return false
+		}
+	}
+	return true
+}
+
+func anyRangeChanged(ranges []*RangeDelta) bool {
+	for _, r := range ranges {
+		if r.Count != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package messages
+
+import (
+	"encoding/json"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	before := MetricList{
+		{Path: "/a/count", Kind: types.Uint64, Value: uint64(5)},
+		{Path: "/a/gone", Kind: types.Int64, Value: int64(1)},
+		{Path: "/a/name", Kind: types.String, Value: "alice",
+			Description: "name"},
+		{Path: "/a/elapsed", Kind: types.GoDuration, Unit: units.Millisecond,
+			Value: 2 * time.Second},
+		{Path: "/a/latency", Kind: types.Dist, Unit: units.Millisecond,
+			Value: &Distribution{
+				Count: 3,
+				Sum:   30.0,
+				Ranges: []*RangeWithCount{
+					{Upper: 10.0, Count: 1},
+					{Lower: 10.0, Count: 2},
+				},
+			},
+		},
+		{Path: "/a/same", Kind: types.Bool, Value: true},
+	}
+	// In JSON form as if read from a saved file.
+	var after MetricList
+	if err := json.Unmarshal([]byte(`[
+		{"path": "/a/count", "kind": "uint64", "value": 12},
+		{"path": "/a/name", "kind": "string", "value": "bob",
+			"description": "user name"},
+		{"path": "/a/elapsed", "kind": "duration", "unit": "Milliseconds",
+			"value": "2500.000000"},
+		{"path": "/a/latency", "kind": "distribution", "unit": "Milliseconds",
+			"value": {"count": 5, "sum": 60.0, "ranges": [
+				{"upper": 10.0, "count": 2},
+				{"lower": 10.0, "count": 3}]}},
+		{"path": "/a/same", "kind": "bool", "value": true},
+		{"path": "/a/new", "kind": "int64", "value": 3}
+	]`), &after); err != nil {
+		t.Fatal(err)
+	}
+	d := Diff(before, after)
+	assertDeepEquals(t, []string{"/a/new"}, d.Added)
+	assertDeepEquals(t, []string{"/a/gone"}, d.Removed)
+	seven, fiveHundred := 7.0, 500.0
+	expected := []*MetricDiff{
+		{Path: "/a/count", Delta: &seven},
+		{Path: "/a/elapsed", Delta: &fiveHundred},
+		{Path: "/a/latency", Distribution: &DistributionDelta{
+			Count: 2,
+			Sum:   30.0,
+			Ranges: []*RangeDelta{
+				{Upper: 10.0, Count: 1},
+				{Lower: 10.0, Count: 1},
+			},
+		}},
+		{Path: "/a/name",
+			OldDescription: "name", NewDescription: "user name",
+			ValueChanged: true},
+	}
+	assertDeepEquals(t, expected, d.Changed)
+}
+
+func TestDiffDifferentRanges(t *testing.T) {
+	before := MetricList{
+		{Path: "/latency", Kind: types.Dist, Unit: units.None,
+			Value: &Distribution{
+				Count: 1, Sum: 1.0,
+				Ranges: []*RangeWithCount{{Upper: 10.0, Count: 1}, {Lower: 10.0}},
+			}},
+	}
+	after := MetricList{
+		{Path: "/latency", Kind: types.Dist, Unit: units.Second,
+			Value: &Distribution{
+				Count: 1, Sum: 1.0,
+				Ranges: []*RangeWithCount{
+					{Upper: 5.0, Count: 1}, {Lower: 5.0}},
+			}},
+	}
+	// Only the unit changed. Ranges differ so they aren't compared.
+	expected := []*MetricDiff{
+		{Path: "/latency", OldUnit: units.None, NewUnit: units.Second},
+	}
+	assertDeepEquals(t, expected, Diff(before, after).Changed)
+}
@@ -141,8 +141,25 @@ func asJson(value interface{}, kind, subType types.Type, unit units.Unit) (
 	return
 }
 
+// valueForConversion returns the value to convert for this metric.
+// When a failed callback left this metric without a value, it returns
+// the zero value of its kind so that the kind still gets converted.
+func (m *Metric) valueForConversion() interface{} {
+	if m.Value == nil && m.Err != "" {
+		if zero, err := m.Kind.SafeZeroValue(); err == nil {
+			return zero
+		}
+	}
+	return m.Value
+}
+
 func (m *Metric) convertToJson() {
-	m.Value, m.Kind, m.SubType = asJson(m.Value, m.Kind, m.SubType, m.Unit)
+	var jsonValue interface{}
+	jsonValue, m.Kind, m.SubType = asJson(
+		m.valueForConversion(), m.Kind, m.SubType, m.Unit)
+	if m.Err == "" {
+		m.Value = jsonValue
+	}
 	switch i := m.TimeStamp.(type) {
 	case nil:
 		m.TimeStamp = ""
@@ -155,11 +172,49 @@ func (m *Metric) convertToJson() {
 	}
 }
 
+func (m *Metadata) convertToJson() {
+	m.Kind = jsonKind(m.Kind)
+	m.SubType = jsonKind(m.SubType)
+}
+
+// jsonKind returns the JSON equivalent of a Go RPC only kind.
+func jsonKind(kind types.Type) types.Type {
+	switch kind {
+	case types.GoDuration:
+		return types.Duration
+	case types.GoTime:
+		return types.Time
+	default:
+		return kind
+	}
+}
+
+func (m *Metadata) convertToGoRPC() {
+	m.Kind = goRPCKind(m.Kind)
+	m.SubType = goRPCKind(m.SubType)
+}
+
+// goRPCKind returns the Go RPC equivalent of a JSON kind.
+func goRPCKind(kind types.Type) types.Type {
+	switch kind {
+	case types.Duration:
+		return types.GoDuration
+	case types.Time:
+		return types.GoTime
+	default:
+		return kind
+	}
+}
+
 func (m *Metric) convertToGoRPC() error {
-	v, k, s, err := asGoRPC(m.Value, m.Kind, m.SubType, m.Unit)
+	v, k, s, err := asGoRPC(
+		m.valueForConversion(), m.Kind, m.SubType, m.Unit)
 	if err != nil {
 		return err
 	}
+	if m.Err != "" {
+		v = m.Value
+	}
 	var newTimeStamp interface{}
 	switch i := m.TimeStamp.(type) {
 	case nil, time.Time:
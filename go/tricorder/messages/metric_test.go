@@ -27,7 +27,7 @@ func TestPlainNoTs(t *testing.T) {
 		Kind:      types.Int64,
 		TimeStamp: "",
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 }
 
 func TestPlainTs(t *testing.T) {
@@ -42,7 +42,7 @@ func TestPlainTs(t *testing.T) {
 		Kind:      types.Int64,
 		TimeStamp: kUsualTimeStr,
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 }
 
 func TestDurationMillis(t *testing.T) {
@@ -59,7 +59,7 @@ func TestDurationMillis(t *testing.T) {
 		Unit:      units.Millisecond,
 		TimeStamp: kUsualTimeStr,
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 }
 
 func TestDuration(t *testing.T) {
@@ -76,7 +76,7 @@ func TestDuration(t *testing.T) {
 		Unit:      units.Second,
 		TimeStamp: kUsualTimeStr,
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 }
 
 func TestTimeMillis(t *testing.T) {
@@ -94,7 +94,7 @@ func TestTimeMillis(t *testing.T) {
 		Unit:      units.Millisecond,
 		TimeStamp: kUsualTimeStr,
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 }
 
 func TestTimeSeconds(t *testing.T) {
@@ -112,7 +112,7 @@ func TestTimeSeconds(t *testing.T) {
 		Unit:      units.Second,
 		TimeStamp: kUsualTimeStr,
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 }
 
 func TestNilSlice(t *testing.T) {
@@ -186,12 +186,12 @@ func TestFromJSonPlainNoTs(t *testing.T) {
 		Value: int64(69),
 		Kind:  types.Int64,
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 	// Test idempotence
 	if err := metric.ConvertToGoRPC(); err != nil {
 		t.Fatal(err)
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 }
 
 func TestFromJSONPlainTs(t *testing.T) {
@@ -208,12 +208,12 @@ func TestFromJSONPlainTs(t *testing.T) {
 		Kind:      types.Int64,
 		TimeStamp: kUsualTimeLocal,
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 	// Test idempotence
 	if err := metric.ConvertToGoRPC(); err != nil {
 		t.Fatal(err)
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 }
 
 func TestFromJSONDurationMillis(t *testing.T) {
@@ -232,12 +232,12 @@ func TestFromJSONDurationMillis(t *testing.T) {
 		Unit:      units.Millisecond,
 		TimeStamp: kUsualTimeLocal,
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 	// Test idempotence
 	if err := metric.ConvertToGoRPC(); err != nil {
 		t.Fatal(err)
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 }
 
 func TestFromJSONDuration(t *testing.T) {
@@ -256,12 +256,12 @@ func TestFromJSONDuration(t *testing.T) {
 		Unit:      units.Second,
 		TimeStamp: kUsualTimeLocal,
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 	// Test idempotence
 	if err := metric.ConvertToGoRPC(); err != nil {
 		t.Fatal(err)
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 }
 
 func TestFromJSONTimeMillis(t *testing.T) {
@@ -281,12 +281,12 @@ func TestFromJSONTimeMillis(t *testing.T) {
 		Unit:      units.Millisecond,
 		TimeStamp: kUsualTimeLocal,
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 	// Test idempotence
 	if err := metric.ConvertToGoRPC(); err != nil {
 		t.Fatal(err)
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 }
 
 func TestFromJSONTimeSeconds(t *testing.T) {
@@ -306,12 +306,12 @@ func TestFromJSONTimeSeconds(t *testing.T) {
 		Unit:      units.Second,
 		TimeStamp: kUsualTimeLocal,
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 	// Test idempotence
 	if err := metric.ConvertToGoRPC(); err != nil {
 		t.Fatal(err)
 	}
-	assertValueEquals(t, expected, metric)
+	assertDeepEquals(t, expected, metric)
 }
 
 func TestFromJSONNilSlice(t *testing.T) {
@@ -0,0 +1,78 @@
+package tricorder
+
+import (
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+)
+
+// Metadata returns the metadata of this metric in Go RPC form. Metadata
+// never evaluates this metric. If namesOnly is true, the metadata has
+// only the path.
+func (m *metric) Metadata(namesOnly bool) *messages.Metadata {
+	result := &messages.Metadata{Path: m.AbsPath()}
+	if !namesOnly {
+		result.Description = m.Description
+		result.Unit = m.Unit()
+		result.Kind = m.Type()
+		result.SubType = m.SubType()
+		result.Bits = m.Bits()
+	}
+	return result
+}
+
+// collectMetadata appends the directories and metrics under this
+// directory to result in depth first order. depth is the depth of the
+// contents of this directory; maxDepth <= 0 means no limit.
+// collectMetadata never fetches from mounted remote processes.
+func (d *directory) collectMetadata(
+	depth, maxDepth int, namesOnly bool, result *messages.MetadataList) {
+	for _, entry := range sortListEntries(d.listCached()) {
+		if entry.Directory != nil {
+			*result = append(*result, &messages.Metadata{
+				Path:        entry.Directory.AbsPath(),
+				IsDirectory: true})
+			if maxDepth <= 0 || depth < maxDepth {
+				entry.Directory.collectMetadata(
+					depth+1, maxDepth, namesOnly, result)
+			}
+		} else {
+			*result = append(*result, entry.Metric.Metadata(namesOnly))
+		}
+	}
+}
+
+// listMetadata lists the directories and metrics under the path of
+// request without evaluating any metric, calling any update function, or
+// fetching from mounted remote processes.
+// If the path is a metric, listMetadata lists just that metric.
+func (r *registry) listMetadata(
+	request *messages.MetadataRequest) messages.MetadataList {
+	result := make(messages.MetadataList, 0)
+	d, m := r.root.getCachedDirectoryOrMetric(newPathSpec(request.Path))
+	if m != nil {
+		result = append(result, m.Metadata(request.NamesOnly))
+	} else if d != nil {
+		d.collectMetadata(1, request.MaxDepth, request.NamesOnly, &result)
+	}
+	return result
+}
+
+// getCachedDirectoryOrMetric works like getDirectoryOrMetric except
+// that it never fetches from mounted remote processes.
+func (d *directory) getCachedDirectoryOrMetric(path pathSpec) (
+	*directory, *metric) {
+	current := d
+	for i, part := range path {
+		n := current.getCachedListEntry(part)
+		if n == nil {
+			return nil, nil
+		}
+		if n.Directory == nil {
+			if i == len(path)-1 {
+				return nil, n.Metric
+			}
+			return nil, nil
+		}
+		current = n.Directory
+	}
+	return current, nil
+}
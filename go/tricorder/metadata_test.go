@@ -0,0 +1,125 @@
+package tricorder
+
+import (
+	"encoding/json"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+func metadataPaths(list messages.MetadataList) (result []string) {
+	for _, m := range list {
+		result = append(result, m.Path)
+	}
+	return
+}
+
+func TestListMetadata(t *testing.T) {
+	reg := NewRegistry()
+	updates := 0
+	group := NewGroup()
+	group.RegisterUpdateFunc(func() time.Time {
+		updates++
+		return kUsualTimeStamp
+	})
+	var elapsed time.Duration
+	reg.RegisterMetric("/a/b/c/value", new(int32), units.None, "value")
+	reg.RegisterMetricInGroup(
+		"/a/elapsed", &elapsed, group, units.Second, "elapsed")
+	reg.RegisterMetric("/top", func() int64 {
+		t.Error("Metadata should not evaluate metrics")
+		return 0
+	}, units.None, "top")
+
+	list := (*registry)(reg).listMetadata(
+		&messages.MetadataRequest{Path: "/"})
+	assertValueDeepEquals(
+		t,
+		[]string{"/a", "/a/b", "/a/b/c", "/a/b/c/value", "/a/elapsed", "/top"},
+		metadataPaths(list))
+	assertValueEquals(t, true, list[0].IsDirectory)
+	assertValueDeepEquals(
+		t,
+		&messages.Metadata{
+			Path:        "/a/b/c/value",
+			Description: "value",
+			Unit:        units.None,
+			Kind:        types.Int32,
+			Bits:        32},
+		list[3])
+	assertValueEquals(t, 0, updates)
+
+	list = (*registry)(reg).listMetadata(
+		&messages.MetadataRequest{Path: "/a", MaxDepth: 2, NamesOnly: true})
+	assertValueDeepEquals(
+		t, []string{"/a/b", "/a/b/c", "/a/elapsed"}, metadataPaths(list))
+	assertValueDeepEquals(t, &messages.Metadata{Path: "/a/elapsed"}, list[2])
+
+	list = (*registry)(reg).listMetadata(
+		&messages.MetadataRequest{Path: "/a/elapsed"})
+	if assertValueEquals(t, 1, len(list)) {
+		assertValueEquals(t, types.GoDuration, list[0].Kind)
+	}
+	assertValueEquals(t, 0, len((*registry)(reg).listMetadata(
+		&messages.MetadataRequest{Path: "/nothing"})))
+	assertValueEquals(t, 0, updates)
+}
+
+func TestListMetadataAPI(t *testing.T) {
+	reg := NewRegistry()
+	var elapsed time.Duration
+	reg.RegisterMetric("/a/elapsed", &elapsed, units.Second, "elapsed")
+	reg.RegisterMetric("/a/b/value", new(int32), units.None, "value")
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metricsapi/a?list=metadata&depth=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list messages.MetadataList
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValueDeepEquals(
+		t, []string{"/a/b", "/a/elapsed"}, metadataPaths(list))
+	if len(list) == 2 {
+		assertValueEquals(t, true, list[0].IsDirectory)
+		assertValueEquals(t, types.Duration, list[1].Kind)
+		assertValueEquals(t, units.Second, list[1].Unit)
+	}
+	for _, query := range []string{"list=values", "list=names&depth=x"} {
+		resp, err = http.Get(server.URL + "/metricsapi/a?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		assertValueEquals(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	rpcServer := rpc.NewServer()
+	reg.RegisterRpc(rpcServer)
+	serverConn, clientConn := net.Pipe()
+	go rpcServer.ServeConn(serverConn)
+	client := rpc.NewClient(clientConn)
+	defer client.Close()
+	var rpcList messages.MetadataList
+	if err := client.Call(
+		"MetricsServer.ListMetadata",
+		messages.MetadataRequest{Path: "/", NamesOnly: true},
+		&rpcList); err != nil {
+		t.Fatal(err)
+	}
+	assertValueDeepEquals(
+		t,
+		[]string{"/a", "/a/b", "/a/b/value", "/a/elapsed"},
+		metadataPaths(rpcList))
+	assertValueEquals(t, "", rpcList[2].Description)
+}
@@ -14,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 )
@@ -27,24 +28,28 @@ const (
 	panicNoAssignedUnit         = "Operation requires that distribution has assigned unit"
 	panicListSubTypeChanging    = "Sub-type in list cannot change"
 	panicBadValue               = "Value does not exist in distribution"
+	panicBadQuantile            = "Quantiles must be between 0 and 1."
+	panicTimeoutWithVariables   = "Group with plain variable metrics cannot have an update timeout."
+	panicBadHistoryResolution   = "Step must be positive and no longer than Length."
+	panicNonPositiveInterval    = "Interval must be positive."
+	panicBadRelativeAccuracy    = "Relative accuracy must be between 0 and 1 exclusive."
+	panicBadWindow              = "Window must be positive and at least as long as slices which must be at least 1."
 )
 
 var (
-	root          = newDirectory()
+	root          = defaultRegistry.root
 	intSizeInBits = int(unsafe.Sizeof(0)) * 8
-	idGenerator   = newIdSequence()
+	// The last id handed out. Accessed atomically.
+	lastId int64 = -1
+	// The number of times a callback returned a non-nil error.
+	callbackErrorCount Counter
+	// Number of times a group update function timed out or panicked
+	updateFailureCount Counter
 )
 
-func newIdSequence() chan int {
-	result := make(chan int)
-	go func() {
-		id := 0
-		for {
-			result <- id
-			id++
-		}
-	}()
-	return result
+// nextId returns the next unique id starting at 0.
+func nextId() int {
+	return int(atomic.AddInt64(&lastId, 1))
 }
 
 type rpcEncoding int
@@ -73,11 +78,28 @@ var (
 // Callers may pass nil for *session parameter in which case
 // it is up to the function to create its own session if necessary.
 type session struct {
-	visitedRegions map[*region]time.Time
+	visitedRegions map[*region]regionVisit
+	// Results of callbacks that can return an error so that each such
+	// callback gets called at most once per session.
+	callbackResults map[*value]callbackResult
+}
+
+// regionVisit is the result of a session visiting a region.
+type regionVisit struct {
+	TimeStamp time.Time
+	IsStale   bool
+}
+
+// callbackResult is the result of calling a callback returning (T, error)
+type callbackResult struct {
+	Value reflect.Value
+	Err   error
 }
 
 func newSession() *session {
-	return &session{visitedRegions: make(map[*region]time.Time)}
+	return &session{
+		visitedRegions:  make(map[*region]regionVisit),
+		callbackResults: make(map[*value]callbackResult)}
 }
 
 // Visit indicates that caller is about to fetch metrics from a
@@ -86,17 +108,39 @@ func newSession() *session {
 // Visit calls the region's update function.
 // Visit returns the timestamp of metrics in region r.
 func (s *session) Visit(r *region) time.Time {
+	return s.visit(r).TimeStamp
+}
+
+// IsStale visits region r and returns true if the update function of r
+// failed so that its metrics have the values and timestamp of the last
+// successful update.
+func (s *session) IsStale(r *region) bool {
+	return s.visit(r).IsStale
+}
+
+func (s *session) visit(r *region) regionVisit {
 	if s.visitedRegions == nil {
 		panic("Trying to visit with a closed session.")
 	}
 	result, ok := s.visitedRegions[r]
 	if !ok {
-		result = r.RLock()
+		result.TimeStamp, result.IsStale = r.rLock()
 		s.visitedRegions[r] = result
 	}
 	return result
 }
 
+// CallWithError calls the callback of v which returns (T, error) the first
+// time and returns the cached result on subsequent calls.
+func (s *session) CallWithError(v *value) (reflect.Value, error) {
+	result, ok := s.callbackResults[v]
+	if !ok {
+		result = v.callWithError()
+		s.callbackResults[v] = result
+	}
+	return result.Value, result.Err
+}
+
 // Close signals that the caller has retrieved all metrics for the
 // particular request. In particular Close indicates that this session
 // is finished visiting its regions.
@@ -106,6 +150,7 @@ func (s *session) Close() error {
 		visitedRegion.RUnlock()
 	}
 	s.visitedRegions = nil
+	s.callbackResults = nil
 	return nil
 }
 
@@ -119,30 +164,69 @@ func fixUpdateFunc(orig func()) func() time.Time {
 type region struct {
 	// The unique id. Immutable.
 	id int
-	// Channels to interact with event loop.
-	sendCh              chan int
-	receiveCh           chan time.Time
-	updateFuncSendCh    chan func() time.Time
-	updateFuncReceiveCh chan bool
-	// Everything below here can only be accessed via the handleRequests
-	// event loop.
-	lockCount  int
-	updateFunc func() time.Time
-	updateTime time.Time
+	// Returns the current time. Immutable.
+	now func() time.Time
+	// Held while calling the update function so that at most one call
+	// runs at a time. Never acquire lock while holding callLock.
+	callLock sync.Mutex
+	// Protects everything below it. The update function runs while
+	// holding lock so that concurrent readers wait for it to finish.
+	lock          sync.Mutex
+	lockCount     int
+	updateFunc    func() time.Time
+	updateTime    time.Time
+	isStale       bool
+	closed        bool
+	updateTimeout time.Duration
+	recoverPanics bool
+	// True if metrics of plain variables were ever registered in this
+	// region. Such regions cannot have an update timeout.
+	hasVariables bool
+	// If non-nil, an update function that timed out is still running and
+	// will send its result here.
+	pendingUpdate chan updateResult
+	// The minimum time between calls to the update function and when
+	// the update function was last called.
+	minUpdateInterval time.Duration
+	lastUpdateCall    time.Time
+	// If non-nil, the update function runs in the background until
+	// this channel is closed.
+	stopBackgroundCh chan struct{}
+}
+
+// updateResult is the result of calling an update function.
+type updateResult struct {
+	TimeStamp time.Time
+	// Non-nil if the update function panicked.
+	Panic interface{}
+}
+
+// callUpdateFunc calls updateFunc recovering any panic. callUpdateFunc
+// waits for any other call to the update function to finish first.
+func (r *region) callUpdateFunc(
+	updateFunc func() time.Time) (result updateResult) {
+	r.callLock.Lock()
+	defer r.callLock.Unlock()
+	defer func() {
+		if p := recover(); p != nil {
+			result.Panic = p
+		}
+	}()
+	result.TimeStamp = updateFunc()
+	return
+}
+
+// callUpdateFuncDirectly works like callUpdateFunc except that it lets
+// a panic in updateFunc go on with its original stack.
+func (r *region) callUpdateFuncDirectly(
+	updateFunc func() time.Time) updateResult {
+	r.callLock.Lock()
+	defer r.callLock.Unlock()
+	return updateResult{TimeStamp: updateFunc()}
 }
 
 func newRegion(updateFunc func() time.Time) *region {
-	result := &region{
-		id:                  <-idGenerator,
-		sendCh:              make(chan int),
-		receiveCh:           make(chan time.Time),
-		updateFuncSendCh:    make(chan func() time.Time),
-		updateFuncReceiveCh: make(chan bool),
-		updateFunc:          updateFunc}
-	go func() {
-		result.handleRequests()
-	}()
-	return result
+	return &region{id: nextId(), now: time.Now, updateFunc: updateFunc}
 }
 
 func voidFunc() time.Time {
@@ -158,47 +242,232 @@ func (r *region) Id() int {
 }
 
 func (r *region) registerUpdateFunc(updateFunc func() time.Time) {
-	r.updateFuncSendCh <- updateFunc
-	<-r.updateFuncReceiveCh
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.closed {
+		r.updateFunc = updateFunc
+	}
 }
 
-func (r *region) handleLockUnlock(lockIncrement int) time.Time {
-	prevLockCount := r.lockCount
-	r.lockCount += lockIncrement
-	if r.lockCount < 0 {
-		panic("Lock count fell below 0")
-	}
-	// Update region for first lock holders
-	if prevLockCount == 0 && r.lockCount > 0 {
-		r.updateTime = r.updateFunc()
+func (r *region) setUpdateTimeout(timeout time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if timeout > 0 && r.hasVariables {
+		panic(panicTimeoutWithVariables)
 	}
-	return r.updateTime
+	r.updateTimeout = timeout
 }
 
-func (r *region) handleUpdateFunc(newFunc func() time.Time) bool {
-	r.updateFunc = newFunc
+// addVariable records that a metric of a plain variable is being
+// registered in this region. addVariable returns false if this region
+// has an update timeout as a timed out update function could change the
+// variable while requests read it.
+func (r *region) addVariable() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.updateTimeout > 0 {
+		return false
+	}
+	r.hasVariables = true
 	return true
 }
 
-func (r *region) handleRequests() {
+func (r *region) setRecoverPanics(recoverPanics bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.recoverPanics = recoverPanics
+}
+
+func (r *region) setMinUpdateInterval(interval time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.minUpdateInterval = interval
+}
+
+// updateInBackground calls the update function of this region once and
+// then every interval in a separate goroutine. Readers of this region
+// never call the update function. A zero or negative interval stops any
+// background updates so that readers call the update function again.
+func (r *region) updateInBackground(interval time.Duration) {
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		return
+	}
+	r.stopBackground()
+	if interval <= 0 {
+		r.lock.Unlock()
+		return
+	}
+	stopCh := make(chan struct{})
+	r.stopBackgroundCh = stopCh
+	r.lock.Unlock()
+	r.backgroundUpdate()
+	go r.backgroundLoop(interval, stopCh)
+}
+
+// stopBackground stops any background updates. Caller must hold the lock.
+func (r *region) stopBackground() {
+	if r.stopBackgroundCh != nil {
+		close(r.stopBackgroundCh)
+		r.stopBackgroundCh = nil
+	}
+}
+
+func (r *region) backgroundLoop(
+	interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
 		select {
-		case in := <-r.sendCh:
-			r.receiveCh <- r.handleLockUnlock(in)
-		case in := <-r.updateFuncSendCh:
-			r.updateFuncReceiveCh <- r.handleUpdateFunc(in)
+		case <-ticker.C:
+			r.backgroundUpdate()
+		case <-stopCh:
+			return
 		}
 	}
 }
 
+// backgroundUpdate calls the update function without holding the lock
+// so that readers never wait for it. Since nothing else would recover a
+// panic in a background goroutine, backgroundUpdate always recovers
+// panics in the update function.
+func (r *region) backgroundUpdate() {
+	r.lock.Lock()
+	updateFunc := r.updateFunc
+	r.lock.Unlock()
+	if updateFunc == nil {
+		return
+	}
+	result := r.callUpdateFunc(updateFunc)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return
+	}
+	if result.Panic != nil {
+		r.markStale()
+		errLog.Printf(
+			"Update function of group %d panicked: %v\n", r.id, result.Panic)
+		return
+	}
+	r.updateTime = result.TimeStamp
+	r.isStale = false
+}
+
+// RLock marks the start of reading the values in this region and
+// returns their timestamp. If no one else is reading the values, RLock
+// calls the update function first. Overlapping readers share the same
+// update.
 func (r *region) RLock() time.Time {
-	r.sendCh <- 1
-	return <-r.receiveCh
+	result, _ := r.rLock()
+	return result
+}
+
+// rLock works like RLock but also returns true if the values in this
+// region are stale because the update function failed.
+func (r *region) rLock() (time.Time, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	// Update region for first lock holders
+	if r.lockCount == 0 && r.needsUpdate() {
+		r.lastUpdateCall = r.now()
+		r.update()
+	}
+	r.lockCount++
+	return r.updateTime, r.isStale
+}
+
+// needsUpdate returns true if readers must call the update function.
+// Caller must hold the lock.
+func (r *region) needsUpdate() bool {
+	if r.closed || r.stopBackgroundCh != nil {
+		return false
+	}
+	if r.minUpdateInterval > 0 && !r.lastUpdateCall.IsZero() {
+		return r.now().Sub(r.lastUpdateCall) >= r.minUpdateInterval
+	}
+	return true
+}
+
+// update calls the update function of this region. If the update
+// function times out or panics and this region is configured to handle
+// that, update leaves the timestamp alone and marks this region stale.
+// Caller must hold the lock.
+func (r *region) update() {
+	if r.updateTimeout <= 0 && !r.recoverPanics {
+		r.updateTime = r.callUpdateFuncDirectly(r.updateFunc).TimeStamp
+		r.isStale = false
+		return
+	}
+	if r.pendingUpdate != nil {
+		select {
+		case <-r.pendingUpdate:
+			r.pendingUpdate = nil
+		default:
+			// Don't pile up calls to an update function that is hung.
+			// We already counted this failure when it timed out.
+			r.isStale = true
+			return
+		}
+	}
+	var result updateResult
+	if r.updateTimeout <= 0 {
+		result = r.callUpdateFunc(r.updateFunc)
+	} else {
+		resultCh := make(chan updateResult, 1)
+		updateFunc := r.updateFunc
+		callUpdateFunc := r.callUpdateFuncDirectly
+		if r.recoverPanics {
+			callUpdateFunc = r.callUpdateFunc
+		}
+		go func() {
+			resultCh <- callUpdateFunc(updateFunc)
+		}()
+		timer := time.NewTimer(r.updateTimeout)
+		select {
+		case result = <-resultCh:
+			timer.Stop()
+		case <-timer.C:
+			r.pendingUpdate = resultCh
+			r.markStale()
+			return
+		}
+	}
+	// Only set if this region recovers panics.
+	if result.Panic != nil {
+		r.markStale()
+		return
+	}
+	r.updateTime = result.TimeStamp
+	r.isStale = false
+}
+
+// markStale marks this region stale after its update function failed.
+// Caller must hold the lock.
+func (r *region) markStale() {
+	r.isStale = true
+	updateFailureCount.Inc()
 }
 
 func (r *region) RUnlock() {
-	r.sendCh <- -1
-	<-r.receiveCh
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.lockCount--
+	if r.lockCount < 0 {
+		panic("Lock count fell below 0")
+	}
+}
+
+// Close stops this region from calling its update function, stops any
+// background updates, and releases the update function. Values in a
+// closed region keep the timestamp of the last update.
+func (r *region) Close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closed = true
+	r.stopBackground()
+	r.updateFunc = nil
 }
 
 // bucketPiece represents a single range in a distribution
@@ -308,6 +577,19 @@ type breakdownPiece struct {
 // breakdown represents a distribution breakdown.
 type breakdown []breakdownPiece
 
+// quantileValue represents the estimated value at a particular quantile
+type quantileValue struct {
+	Quantile float64
+	Value    float64
+}
+
+// Name returns the name of the quantile e.g "p50", "p99", or "p99.9".
+func (q quantileValue) Name() string {
+	// Round to avoid names like p28.999999999999996 for 0.29
+	percent := math.Round(q.Quantile*1e6) / 1e4
+	return "p" + strconv.FormatFloat(percent, 'f', -1, 64)
+}
+
 // snapshot represents a snapshot of a distribution
 type snapshot struct {
 	Min             float64
@@ -320,6 +602,25 @@ type snapshot struct {
 	IsNotCumulative bool
 	TimeStamp       time.Time
 	Breakdown       breakdown
+	// The quantiles to export in ascending order.
+	Quantiles []quantileValue
+	// If non-zero, the snapshot includes only values added within this
+	// much time.
+	Window time.Duration
+	// If non-nil, the sparse buckets of a sketch distribution. In that
+	// case Breakdown includes only non-empty buckets.
+	Sketch *messages.Sketch
+}
+
+// distributionValue is what a value representing a distribution uses
+// such as a *distribution or *windowedDistribution.
+type distributionValue interface {
+	// SetUnit sets the unit if not already set. It returns false if
+	// the unit is already set to something different.
+	SetUnit(unit units.Unit) bool
+	GroupId() int
+	IsNotCumulative() bool
+	Snapshot() *snapshot
 }
 
 // distribution represents a distribution of values same as Distribution
@@ -338,6 +639,8 @@ type distribution struct {
 	count      uint64
 	generation uint64
 	timeStamp  time.Time
+	// The quantiles to export in ascending order
+	quantiles []float64
 }
 
 func newDistribution(bucketer *Bucketer, isNotCumulative bool) *distribution {
@@ -355,7 +658,7 @@ func newDistributionWithTimeStamp(
 		counts:          make([]uint64, len(bucketer.pieces)),
 		isNotCumulative: isNotCumulative,
 		timeStamp:       ts,
-		groupId:         <-idGenerator,
+		groupId:         nextId(),
 	}
 }
 
@@ -381,6 +684,10 @@ func (d *distribution) GroupId() int {
 	return d.groupId
 }
 
+func (d *distribution) IsNotCumulative() bool {
+	return d.isNotCumulative
+}
+
 func (d *distribution) Sum() float64 {
 	d.lock.Lock()
 	defer d.lock.Unlock()
@@ -494,9 +801,15 @@ func (d *distribution) valueToFloat(value interface{}) float64 {
 	if !d.unitSet {
 		panic(panicNoAssignedUnit)
 	}
+	return valueToFloatInUnit(value, d.unit)
+}
+
+// valueToFloatInUnit converts a float32, float64, or time.Duration to a
+// float64 expressed in unit.
+func valueToFloatInUnit(value interface{}, unit units.Unit) float64 {
 	switch v := value.(type) {
 	case time.Duration:
-		return d.goDurationToFloat(v)
+		return goDurationToFloat(v, unit)
 	case float32:
 		return float64(v)
 	case float64:
@@ -506,8 +819,8 @@ func (d *distribution) valueToFloat(value interface{}) float64 {
 	}
 }
 
-func (d *distribution) goDurationToFloat(dur time.Duration) float64 {
-	switch d.unit {
+func goDurationToFloat(dur time.Duration, unit units.Unit) float64 {
+	switch unit {
 	case units.Second:
 		return float64(dur) / float64(time.Second)
 	case units.Millisecond:
@@ -549,6 +862,60 @@ func interpolate(min float64, max float64, frac float64) float64 {
 	return (1.0-frac)*min + frac*max
 }
 
+// quantile estimates the value at quantile q. Caller must hold the lock.
+// quantile returns 0 if this distribution is empty.
+func (d *distribution) quantile(q float64) float64 {
+	if d.count == 0 {
+		return 0.0
+	}
+	// min and max are known exactly
+	if q == 0.0 {
+		return d.min
+	}
+	if q == 1.0 {
+		return d.max
+	}
+	rank := q * float64(d.count-1)
+	lowerIdx := uint64(rank)
+	lower := d.indexToValue(lowerIdx)
+	if lowerIdx == d.count-1 {
+		return lower
+	}
+	return interpolate(
+		lower, d.indexToValue(lowerIdx+1), rank-float64(lowerIdx))
+}
+
+func checkQuantile(q float64) {
+	if !(q >= 0.0 && q <= 1.0) {
+		panic(panicBadQuantile)
+	}
+}
+
+func (d *distribution) Quantile(q float64) float64 {
+	checkQuantile(q)
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.quantile(q)
+}
+
+// sortedQuantiles returns a sorted copy of quantiles. sortedQuantiles
+// panics if any quantile is not between 0 and 1.
+func sortedQuantiles(quantiles []float64) []float64 {
+	for _, q := range quantiles {
+		checkQuantile(q)
+	}
+	result := append([]float64(nil), quantiles...)
+	sort.Float64s(result)
+	return result
+}
+
+func (d *distribution) SetQuantiles(quantiles []float64) {
+	sorted := sortedQuantiles(quantiles)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.quantiles = sorted
+}
+
 func (d *distribution) calculateMedian() float64 {
 	if d.count <= 2 {
 		return d.total / float64(d.count)
@@ -577,6 +944,13 @@ func (d *distribution) Snapshot() *snapshot {
 			TimeStamp: d.timeStamp,
 		}
 	}
+	var quantiles []quantileValue
+	if len(d.quantiles) > 0 {
+		quantiles = make([]quantileValue, len(d.quantiles))
+		for i, q := range d.quantiles {
+			quantiles[i] = quantileValue{Quantile: q, Value: d.quantile(q)}
+		}
+	}
 	return &snapshot{
 		Min:             d.min,
 		Max:             d.max,
@@ -588,6 +962,7 @@ func (d *distribution) Snapshot() *snapshot {
 		IsNotCumulative: d.isNotCumulative,
 		TimeStamp:       d.timeStamp,
 		Breakdown:       bdn,
+		Quantiles:       quantiles,
 	}
 
 }
@@ -671,7 +1046,7 @@ func newListWithTimeStamp(
 	ts time.Time) *listType {
 	value, subType := asSliceValue(aSlice, sliceIsMutable)
 	return &listType{
-		groupId:   <-idGenerator,
+		groupId:   nextId(),
 		subType:   subType,
 		aSlice:    value,
 		timeStamp: ts}
@@ -735,18 +1110,23 @@ func (l *listType) SubType() types.Type {
 type value struct {
 	val           reflect.Value
 	region        *region
-	dist          *distribution
+	dist          distributionValue
 	alist         *listType
 	valType       types.Type
 	isValAPointer bool
 	isfunc        bool
-	unit          units.Unit
+	// true if val is a function returning (T, error)
+	returnsError bool
+	// true if value never decreases such as with a Counter
+	isMonotonic bool
+	unit        units.Unit
 }
 
 var (
 	timePtrType  = reflect.TypeOf((*time.Time)(nil))
 	timeType     = timePtrType.Elem()
 	durationType = reflect.TypeOf(time.Duration(0))
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
 )
 
 // Given a type t from the reflect package, return the corresponding
@@ -822,7 +1202,7 @@ func mustGetPrimitiveType(t reflect.Type) (
 	return
 }
 
-func newValueForDist(dist *distribution, unit units.Unit) (
+func newValueForDist(dist distributionValue, unit units.Unit) (
 	*value, error) {
 	if !dist.SetUnit(unit) {
 		return nil, ErrWrongUnit
@@ -831,6 +1211,24 @@ func newValueForDist(dist *distribution, unit units.Unit) (
 
 }
 
+// newValueForGetter returns a value for getter which is the method
+// that reads a Counter, Gauge, or IntGauge.
+func newValueForGetter(
+	getter interface{},
+	region *region,
+	unit units.Unit,
+	isMonotonic bool) *value {
+	v := reflect.ValueOf(getter)
+	valType, _, _ := getPrimitiveType(v.Type().Out(0))
+	return &value{
+		val:         v,
+		unit:        unit,
+		region:      region,
+		valType:     valType,
+		isfunc:      true,
+		isMonotonic: isMonotonic}
+}
+
 // unit parameter only used if spec is a *Distribution
 // In that case, it sets the unit of the *Distribution in place.
 func newValue(spec interface{}, region *region, unit units.Unit) (
@@ -846,6 +1244,14 @@ func newValue(spec interface{}, region *region, unit units.Unit) (
 	if dist, ok := spec.(*distribution); ok {
 		return newValueForDist(dist, unit)
 	}
+	if someDist, ok := spec.(*WindowedDistribution); ok {
+		dist := (*windowedDistribution)(someDist)
+		return newValueForDist(dist, unit)
+	}
+	if someDist, ok := spec.(*SketchDistribution); ok {
+		dist := (*sketchDistribution)(someDist)
+		return newValueForDist(dist, unit)
+	}
 	if someList, ok := spec.(*List); ok {
 		alist := (*listType)(someList)
 		return &value{alist: alist, unit: unit, valType: types.List}, nil
@@ -853,6 +1259,17 @@ func newValue(spec interface{}, region *region, unit units.Unit) (
 	if alist, ok := spec.(*listType); ok {
 		return &value{alist: alist, unit: unit, valType: types.List}, nil
 	}
+	switch atomicSpec := spec.(type) {
+	case *Counter:
+		return newValueForGetter(
+			(*counter)(atomicSpec).Value, region, unit, true), nil
+	case *Gauge:
+		return newValueForGetter(
+			(*gauge)(atomicSpec).Value, region, unit, false), nil
+	case *IntGauge:
+		return newValueForGetter(
+			(*intGauge)(atomicSpec).Value, region, unit, false), nil
+	}
 	flagValue, ok := spec.(flag.Value)
 	if ok {
 		flagGetter := toFlagGetter(flagValue)
@@ -879,8 +1296,8 @@ func newValue(spec interface{}, region *region, unit units.Unit) (
 	if t.Kind() == reflect.Func {
 		funcArgCount := t.NumOut()
 
-		// Our functions have to return exactly one thing
-		if funcArgCount != 1 {
+		// Our functions have to return either T or (T, error)
+		if funcArgCount != 1 && (funcArgCount != 2 || t.Out(1) != errorType) {
 			panic(panicBadFunctionReturnTypes)
 		}
 		valType, isValAPointer, ok := getPrimitiveType(t.Out(0))
@@ -893,6 +1310,7 @@ func newValue(spec interface{}, region *region, unit units.Unit) (
 			region:        region,
 			valType:       valType,
 			isfunc:        true,
+			returnsError:  funcArgCount == 2,
 			isValAPointer: isValAPointer}, nil
 	}
 	v = v.Elem()
@@ -900,6 +1318,9 @@ func newValue(spec interface{}, region *region, unit units.Unit) (
 	if !ok {
 		return nil, ErrWrongType
 	}
+	if !region.addVariable() {
+		return nil, ErrVariableWithTimeout
+	}
 	return &value{
 		val:           v,
 		unit:          unit,
@@ -941,7 +1362,26 @@ func (v *value) canEvaluate() bool {
 	return v.val.IsValid()
 }
 
+// evaluate returns the value. If this value is a callback that returned
+// an error, evaluate returns the zero value of its type.
 func (v *value) evaluate(s *session) reflect.Value {
+	result, _ := v.evaluateWithError(s)
+	return result
+}
+
+// Err returns the error the callback of this value returned or nil if
+// the callback succeeded or this value is not a callback that can
+// return an error.
+// If caller passes a nil session, Err creates its own internally.
+func (v *value) Err(s *session) error {
+	if !v.returnsError {
+		return nil
+	}
+	_, err := v.evaluateWithError(s)
+	return err
+}
+
+func (v *value) evaluateWithError(s *session) (reflect.Value, error) {
 	if v.region != nil {
 		if s == nil {
 			s = newSession()
@@ -950,14 +1390,33 @@ func (v *value) evaluate(s *session) reflect.Value {
 		s.Visit(v.region)
 	}
 	if !v.isfunc {
-		return v.val
+		return v.val, nil
+	}
+	if v.returnsError {
+		if s == nil {
+			result := v.callWithError()
+			return result.Value, result.Err
+		}
+		return s.CallWithError(v)
 	}
 	result := v.val.Call(nil)[0]
 	// Needed as the Get() method on flag values returns an interface{}.
 	if result.Type().Kind() == reflect.Interface {
-		return result.Elem()
+		return result.Elem(), nil
 	}
-	return result
+	return result, nil
+}
+
+// callWithError calls the callback of this value which returns (T, error).
+func (v *value) callWithError() callbackResult {
+	results := v.val.Call(nil)
+	if errValue := results[1]; !errValue.IsNil() {
+		callbackErrorCount.Inc()
+		return callbackResult{
+			Value: reflect.Zero(results[0].Type()),
+			Err:   errValue.Interface().(error)}
+	}
+	return callbackResult{Value: results[0]}
 }
 
 // AsXXX methods return this value as a type XX.
@@ -1092,6 +1551,11 @@ func (v *value) AsInterface(s *session) (result interface{}) {
 	return valueToInterface(v.evaluate(s), v.valType, v.isValAPointer)
 }
 
+// IsMonotonic returns true if this value never decreases.
+func (v *value) IsMonotonic() bool {
+	return v.isMonotonic
+}
+
 // RegionId returns the region id for the timestamps of this value.
 // RegionId panics if called on an aggregate value such as a distrubtion or
 // list since they are updated continually.
@@ -1115,6 +1579,17 @@ func (v *value) TimeStamp(s *session) time.Time {
 	return s.Visit(v.region)
 }
 
+// IsStale returns true if the value fetched with the given session is
+// left over from an earlier update because the update function of its
+// group failed. s must be non-nil. IsStale returns false for aggregate
+// values such as distributions and lists.
+func (v *value) IsStale(s *session) bool {
+	if v.region == nil {
+		return false
+	}
+	return s.IsStale(v.region)
+}
+
 func asRanges(ranges breakdown) []*messages.RangeWithCount {
 	result := make([]*messages.RangeWithCount, len(ranges))
 	for i := range ranges {
@@ -1126,6 +1601,19 @@ func asRanges(ranges breakdown) []*messages.RangeWithCount {
 	return result
 }
 
+func asQuantiles(quantiles []quantileValue) []*messages.QuantileValue {
+	if len(quantiles) == 0 {
+		return nil
+	}
+	result := make([]*messages.QuantileValue, len(quantiles))
+	for i := range quantiles {
+		result[i] = &messages.QuantileValue{
+			Quantile: quantiles[i].Quantile,
+			Value:    quantiles[i].Value}
+	}
+	return result
+}
+
 func (v *value) updateJsonOrRpcMetric(
 	s *session, metric *messages.Metric, encoding rpcEncoding) {
 	t := v.Type()
@@ -1133,6 +1621,7 @@ func (v *value) updateJsonOrRpcMetric(
 	metric.SubType = v.SubType()
 	metric.Bits = v.Bits()
 	metric.Unit = v.Unit()
+	metric.IsMonotonic = v.IsMonotonic()
 	switch t {
 	case types.Dist:
 		dist := v.AsDistribution()
@@ -1146,7 +1635,15 @@ func (v *value) updateJsonOrRpcMetric(
 			Count:           snapshot.Count,
 			Generation:      snapshot.Generation,
 			IsNotCumulative: snapshot.IsNotCumulative,
-			Ranges:          asRanges(snapshot.Breakdown)}
+			Ranges:          asRanges(snapshot.Breakdown),
+			Quantiles:       asQuantiles(snapshot.Quantiles),
+			Window:          snapshot.Window.Seconds()}
+		if snapshot.Sketch != nil {
+			// The sketch replaces the ranges
+			distMessage := metric.Value.(*messages.Distribution)
+			distMessage.Ranges = nil
+			distMessage.Sketch = snapshot.Sketch
+		}
 		metric.GroupId = dist.GroupId()
 		metric.TimeStamp = snapshot.TimeStamp
 	case types.List:
@@ -1158,9 +1655,14 @@ func (v *value) updateJsonOrRpcMetric(
 			s = newSession()
 			defer s.Close()
 		}
-		metric.Value = v.AsInterface(s)
+		if err := v.Err(s); err != nil {
+			metric.Err = err.Error()
+		} else {
+			metric.Value = v.AsInterface(s)
+		}
 		metric.GroupId = v.RegionId()
 		metric.TimeStamp = v.TimeStamp(s)
+		metric.IsStale = v.IsStale(s)
 	}
 	if encoding == jsonEncoding {
 		metric.ConvertToJson()
@@ -1355,7 +1857,7 @@ func (v *value) AsHtmlString(s *session) string {
 
 // AsDistribution returns this value as a Distribution.
 // AsDistribution panics if this value does not represent a distribution
-func (v *value) AsDistribution() *distribution {
+func (v *value) AsDistribution() distributionValue {
 	if v.valType != types.Dist {
 		panic(panicIncompatibleTypes)
 	}
@@ -1376,6 +1878,32 @@ type metric struct {
 	// The value of the metric
 	*value
 	enclosingListEntry *listEntry
+	// If this metric belongs to a family, vec is the family and
+	// labelValues are the values of its labels.
+	vec         *metricVec
+	labelValues []string
+	// Tracks changes for queries with a since token
+	changes changeTracker
+	// 1 if the value of this metric includes a value that an earlier
+	// process saved. Accessed atomically.
+	isRestored uint32
+	// The epoch of the process that saved the restored value. Set
+	// before isRestored and never changed afterwards.
+	restoredEpoch string
+}
+
+// IsRestored returns true if the value of this metric includes a value
+// that an earlier process saved.
+func (m *metric) IsRestored() bool {
+	return atomic.LoadUint32(&m.isRestored) != 0
+}
+
+// initRestored sets the fields of metric that mark restored metrics.
+func (m *metric) initRestored(metric *messages.Metric) {
+	if m.IsRestored() {
+		metric.IsRestored = true
+		metric.Epoch = m.restoredEpoch
+	}
 }
 
 // AbsPath returns the absolute path of this metric
@@ -1383,10 +1911,22 @@ func (m *metric) AbsPath() string {
 	return m.enclosingListEntry.absPath()
 }
 
+// Labels returns the labels of this metric or nil if this metric does
+// not belong to a family.
+func (m *metric) Labels() map[string]string {
+	if m.vec == nil {
+		return nil
+	}
+	return m.vec.labels(m.labelValues)
+}
+
 // InitJsonMetric initializes 'metric' for JSON with this instance
 func (m *metric) InitJsonMetric(s *session, metric *messages.Metric) {
 	*metric = messages.Metric{
-		Path: m.AbsPath(), Description: m.Description}
+		Path:        m.AbsPath(),
+		Description: m.Description,
+		Labels:      m.Labels()}
+	m.initRestored(metric)
 	m.value.UpdateJsonMetric(s, metric)
 }
 
@@ -1400,7 +1940,10 @@ func (m *metric) UpdateJsonMetric(s *session, metric *messages.Metric) {
 // InitJsonMetric initializes 'metric' for GoRPC with this instance
 func (m *metric) InitRpcMetric(s *session, metric *messages.Metric) {
 	*metric = messages.Metric{
-		Path: m.AbsPath(), Description: m.Description}
+		Path:        m.AbsPath(),
+		Description: m.Description,
+		Labels:      m.Labels()}
+	m.initRestored(metric)
 	m.value.UpdateRpcMetric(s, metric)
 }
 
@@ -1420,19 +1963,19 @@ type listEntry struct {
 	// If this list entry represents a directory, Directory is non-nil
 	Directory *directory
 	parent    *listEntry
+	// The directory containing this list entry
+	container *directory
 }
 
 func (n *listEntry) parentDir() *directory {
-	if n.parent == nil {
-		return root
-	}
-	return n.parent.Directory
+	return n.container
 }
 
-func (n *listEntry) pathFrom(fromDir *directory) pathSpec {
+// pathFrom returns the path of this list entry relative to the directory
+// that from encloses or nil if this list entry is not under that directory.
+func (n *listEntry) pathFrom(from *listEntry) pathSpec {
 	var names pathSpec
 	current := n
-	from := fromDir.enclosingListEntry
 	for ; current != nil && current != from; current = current.parent {
 		names = append(names, current.Name)
 	}
@@ -1447,7 +1990,8 @@ func (n *listEntry) pathFrom(fromDir *directory) pathSpec {
 }
 
 func (n *listEntry) absPath() string {
-	return "/" + n.pathFrom(root).String()
+	// Every top level directory has a nil enclosingListEntry
+	return "/" + n.pathFrom(nil).String()
 }
 
 // metricsCollector represents any data structure used to collect metrics.
@@ -1457,9 +2001,26 @@ type metricsCollector interface {
 	Collect(m *metric, s *session) error
 }
 
+// metricsErrorCollector represents a metricsCollector that also collects
+// metrics whose callbacks returned an error. Collectors that are not
+// metricsErrorCollectors skip such metrics.
+type metricsErrorCollector interface {
+	metricsCollector
+	// CollectError collects a single metric whose callback returned
+	// err. Implementations may assume that s is non nil.
+	CollectError(m *metric, s *session, err error) error
+}
+
 // directory represents a directory same as DirectorySpec
 type directory struct {
 	enclosingListEntry *listEntry
+	// Restores metrics saved by an earlier process as they get
+	// registered. Set only on the root directory of a registry.
+	// Immutable.
+	restorer *restorer
+	// If non-nil, the contents of this directory mirror a remote process
+	// and get refreshed before each lookup. Immutable.
+	remote *remoteMount
 	// lock locks only the contents map itself.
 	lock     sync.RWMutex
 	contents map[string]*listEntry
@@ -1507,6 +2068,12 @@ func (d *directory) GetMetric(relativePath string) *metric {
 
 // s is always non-nil
 func collect(m *metric, s *session, coll metricsCollector) error {
+	if err := m.Err(s); err != nil {
+		if errColl, ok := coll.(metricsErrorCollector); ok {
+			return errColl.CollectError(m, s, err)
+		}
+		return nil
+	}
 	if m.IsInfNaN(s) {
 		return nil
 	}
@@ -1541,6 +2108,56 @@ func (d *directory) GetAllMetricsByPath(
 	return nil
 }
 
+// GetAllMetricsBySelector does a depth first traversal from where sel
+// starts to find all the metrics sel selects and store them within
+// collector. It treats collector and s the same way GetAllMetricsByPath
+// does. It skips directories where sel can select nothing and evaluates
+// only the metrics sel selects.
+func (d *directory) GetAllMetricsBySelector(
+	sel *selector, collector metricsCollector, s *session) error {
+	if sel.IsPath() {
+		return d.GetAllMetricsByPath(sel.base.String(), collector, s)
+	}
+	if s == nil {
+		s = newSession()
+		defer s.Close()
+	}
+	dir, m := d.getDirectoryOrMetric(sel.base)
+	if m != nil {
+		if !sel.Matches(m) {
+			return nil
+		}
+		return collect(m, s, collector)
+	} else if dir != nil {
+		return dir.getSelectedMetrics(sel, nil, collector, s)
+	}
+	return nil
+}
+
+// getSelectedMetrics does a depth first traversal of this directory
+// which is at path relative to where sel starts. s is non-nil.
+func (d *directory) getSelectedMetrics(
+	sel *selector,
+	path pathSpec,
+	collector metricsCollector,
+	s *session) (err error) {
+	for _, entry := range d.List() {
+		if entry.Directory != nil {
+			entryPath := append(path[:len(path):len(path)], entry.Name)
+			if sel.MayMatchUnder(entryPath) {
+				err = entry.Directory.getSelectedMetrics(
+					sel, entryPath, collector, s)
+			}
+		} else if sel.Matches(entry.Metric) {
+			err = collect(entry.Metric, s, collector)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
 // GetDirectoryOrMetric returns either the directory or metric
 // at the given path while traversing the directory tree just one time.
 // If path not found: returns nil, nil; if path is a directory:
@@ -1579,7 +2196,23 @@ func (d *directory) GetAllMetrics(
 	return
 }
 
+// refreshRemote refreshes the contents of this directory if it mirrors a
+// remote process.
+func (d *directory) refreshRemote() {
+	if d.remote != nil {
+		d.remote.Refresh(d)
+	}
+}
+
 func (d *directory) listUnsorted() []*listEntry {
+	d.refreshRemote()
+	return d.listCached()
+}
+
+// listCached works like listUnsorted except that it never refreshes a
+// directory that mirrors a remote process. The contents are those of
+// the last fetch.
+func (d *directory) listCached() []*listEntry {
 	d.lock.RLock()
 	defer d.lock.RUnlock()
 	result := make([]*listEntry, len(d.contents))
@@ -1592,11 +2225,24 @@ func (d *directory) listUnsorted() []*listEntry {
 }
 
 func (d *directory) getListEntry(name string) *listEntry {
+	d.refreshRemote()
+	return d.getCachedListEntry(name)
+}
+
+// getCachedListEntry works like getListEntry except that it never
+// refreshes a directory that mirrors a remote process.
+func (d *directory) getCachedListEntry(name string) *listEntry {
 	d.lock.RLock()
 	defer d.lock.RUnlock()
 	return d.contents[name]
 }
 
+func (d *directory) isEmpty() bool {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return len(d.contents) == 0
+}
+
 func (d *directory) removeListEntry(name string) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
@@ -1657,6 +2303,17 @@ func (d *directory) getDirectoryOrMetric(path pathSpec) (
 }
 
 func (d *directory) createDirIfNeeded(name string) (*directory, error) {
+	return d.createDir(name, false)
+}
+
+// createNewDir works like createDirIfNeeded except that it returns
+// ErrPathInUse if name already exists.
+func (d *directory) createNewDir(name string) (*directory, error) {
+	return d.createDir(name, true)
+}
+
+func (d *directory) createDir(name string, mustBeNew bool) (
+	*directory, error) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 	n := d.contents[name]
@@ -1665,21 +2322,68 @@ func (d *directory) createDirIfNeeded(name string) (*directory, error) {
 	if n == nil {
 		newDir := newDirectory()
 		newListEntry := &listEntry{
-			Name: name, Directory: newDir, parent: d.enclosingListEntry}
+			Name:      name,
+			Directory: newDir,
+			parent:    d.enclosingListEntry,
+			container: d}
 		newDir.enclosingListEntry = newListEntry
 		d.contents[name] = newListEntry
 		return newDir, nil
 	}
 
 	// The directory already exists
-	if n.Directory != nil {
+	if n.Directory != nil && !mustBeNew {
 		return n.Directory, nil
 	}
 
-	// name already associated with a metric, return error
+	// name already in use, return error
 	return nil, ErrPathInUse
 }
 
+// mountRemote creates a directory at path that mirrors the metrics of
+// the process at address.
+func (d *directory) mountRemote(
+	path pathSpec, address string, config *RemoteConfig) error {
+	if path.Empty() {
+		return ErrPathInUse
+	}
+	parent, err := d.registerDirectory(path.Dir())
+	if err != nil {
+		return err
+	}
+	remote, err := newRemoteMount(address, config)
+	if err != nil {
+		return err
+	}
+	mount := newDirectory()
+	mount.remote = remote
+	status, err := newValue(remote.Status, newDefaultRegion(), units.None)
+	if err != nil {
+		return err
+	}
+	parent.lock.Lock()
+	defer parent.lock.Unlock()
+	if parent.contents[path.Base()] != nil {
+		return ErrPathInUse
+	}
+	entry := &listEntry{
+		Name:      path.Base(),
+		Directory: mount,
+		parent:    parent.enclosingListEntry,
+		container: parent}
+	mount.enclosingListEntry = entry
+	if err := mount.storeMetric(kRemoteStatusName, &metric{
+		Description: "Status of fetching metrics from " + address,
+		value:       status}); err != nil {
+		return err
+	}
+	parent.contents[path.Base()] = entry
+	// Start the first fetch now so that the first query likely finds
+	// it finished. Don't wait for it while holding the lock.
+	remote.StartFetch(mount)
+	return nil
+}
+
 func (d *directory) storeMetric(name string, m *metric) error {
 	d.lock.Lock()
 	defer d.lock.Unlock()
@@ -1688,7 +2392,11 @@ func (d *directory) storeMetric(name string, m *metric) error {
 	if n != nil {
 		return ErrPathInUse
 	}
-	newListEntry := &listEntry{Name: name, Metric: m, parent: d.enclosingListEntry}
+	newListEntry := &listEntry{
+		Name:      name,
+		Metric:    m,
+		parent:    d.enclosingListEntry,
+		container: d}
 	m.enclosingListEntry = newListEntry
 	d.contents[name] = newListEntry
 	return nil
@@ -1706,6 +2414,20 @@ func (d *directory) registerDirectory(path pathSpec) (
 	return
 }
 
+// registerNewDirectory works like registerDirectory except that it
+// returns ErrPathInUse if path already exists.
+func (d *directory) registerNewDirectory(path pathSpec) (
+	*directory, error) {
+	if path.Empty() {
+		return nil, ErrPathInUse
+	}
+	parent, err := d.registerDirectory(path.Dir())
+	if err != nil {
+		return nil, err
+	}
+	return parent.createNewDir(path.Base())
+}
+
 func (d *directory) registerMetric(
 	path pathSpec,
 	value interface{},
@@ -1723,10 +2445,33 @@ func (d *directory) registerMetric(
 	if err != nil {
 		return
 	}
-	metric := &metric{
-		Description: description,
-		value:       avalue}
-	return current.storeMetric(path.Base(), metric)
+	err = current.storeNewMetric(
+		path.Base(),
+		&metric{
+			Description: description,
+			value:       avalue},
+		value)
+	return
+}
+
+// storeNewMetric stores a newly registered metric under name and then
+// restores its saved value, if any. spec is what the caller passed to
+// register m.
+func (d *directory) storeNewMetric(
+	name string, m *metric, spec interface{}) error {
+	if err := d.storeMetric(name, m); err != nil {
+		return err
+	}
+	d.rootDir().restorer.Restore(m, spec)
+	return nil
+}
+
+// rootDir returns the root directory of the tree containing d.
+func (d *directory) rootDir() *directory {
+	for !d.IsRoot() {
+		d = d.Parent()
+	}
+	return d
 }
 
 func (d *directory) unregisterDirectory() {
@@ -1863,11 +2608,3 @@ func sortListEntries(listEntries []*listEntry) []*listEntry {
 	sort.Sort(byName(listEntries))
 	return listEntries
 }
-
-func readMyMetrics(path string) (result messages.MetricList) {
-	// Always returns nil error since rpcMetricsCollector.Collect
-	// always returns nil
-	root.GetAllMetricsByPath(
-		path, (*rpcMetricsCollector)(&result), nil)
-	return
-}
@@ -1,6 +1,7 @@
 package tricorder
 
 import (
+	"bytes"
 	"errors"
 	"flag"
 	"github.com/Symantec/tricorder/go/tricorder/messages"
@@ -685,7 +686,8 @@ func TestAPI(t *testing.T) {
 		"some-time-ptr",
 		"start-time",
 		"temperature",
-		"test-start-time")
+		"test-start-time",
+		"tricorder")
 	verifyGetAllMetricsByPath(
 		t,
 		"/nan",
@@ -1342,7 +1344,8 @@ func TestAPI(t *testing.T) {
 		"some-time-ptr",
 		"start-time",
 		"temperature",
-		"test-start-time")
+		"test-start-time",
+		"tricorder")
 
 	if err := RegisterMetric(
 		"/proc/foo/bar/baz",
@@ -1601,6 +1604,54 @@ func TestMedianDataSkewedHigh(t *testing.T) {
 	}
 }
 
+func TestQuantile(t *testing.T) {
+	dist := NewLinearBucketer(101, 0.0, 10.0).NewCumulativeDistribution()
+	(*distribution)(dist).SetUnit(units.None)
+	assertValueEquals(t, 0.0, dist.Quantile(0.99))
+	for i := 0; i < 1000; i++ {
+		dist.Add(float64(i))
+	}
+	assertValueEquals(t, 0.0, dist.Quantile(0.0))
+	assertValueEquals(t, 999.0, dist.Quantile(1.0))
+	assertValueEquals(
+		t, (*distribution)(dist).Snapshot().Median, dist.Quantile(0.5))
+	for _, q := range []float64{0.1, 0.9, 0.99, 0.999} {
+		expected := q * 999.0
+		if actual := dist.Quantile(q); math.Abs(actual-expected) > 1.0 {
+			t.Errorf("Quantile %f: expected %f, got %f", q, expected, actual)
+		}
+	}
+	assertPanics(t, func() { dist.Quantile(1.5) })
+	assertPanics(t, func() { dist.SetQuantiles(0.5, -0.1) })
+}
+
+func TestExportedQuantiles(t *testing.T) {
+	dist := newDistribution(NewArbitraryBucketer(100.0), false)
+	dist.SetUnit(units.None)
+	dist.SetQuantiles([]float64{0.99, 0.5})
+	dist.Add(10.0)
+	dist.Add(20.0)
+	dist.Add(30.0)
+	snapshot := dist.Snapshot()
+	if assertValueEquals(t, 2, len(snapshot.Quantiles)) {
+		assertValueEquals(t, "p50", snapshot.Quantiles[0].Name())
+		assertValueEquals(t, 20.0, snapshot.Quantiles[0].Value)
+		assertValueEquals(t, "p99", snapshot.Quantiles[1].Name())
+	}
+	assertValueEquals(
+		t, "p99.9", quantileValue{Quantile: 0.999}.Name())
+	assertValueEquals(
+		t, "p29", quantileValue{Quantile: 0.29}.Name())
+	assertValueEquals(
+		t, "p99.99", quantileValue{Quantile: 0.9999}.Name())
+	var buffer bytes.Buffer
+	textEmitDistribution(snapshot, &buffer)
+	assertValueEquals(
+		t,
+		"{min:10;max:30;avg:20;median:20;sum:60;count:3;p50:20;p99:24.9;[-inf,100):3}\n",
+		buffer.String())
+}
+
 func TestCompactDecimalSigned(t *testing.T) {
 	suffixes := []string{" thou", " mil", " bil"}
 	assertValueEquals(t, "0", iCompactForm(0, 900, suffixes))
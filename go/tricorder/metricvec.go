@@ -0,0 +1,253 @@
+package tricorder
+
+import (
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"strings"
+	"sync"
+)
+
+const (
+	// The label value that a metric family uses for all labels once it
+	// reaches its maximum cardinality.
+	overflowLabelValue = "other"
+)
+
+const (
+	panicNoLabelNames        = "Metric families need at least one label name."
+	panicBadLabelName        = "Label names must be non-empty and may not contain '/' or '='."
+	panicWrongLabelCount     = "Wrong number of label values passed to metric family."
+	panicNegativeCardinality = "Maximum cardinality may not be negative."
+)
+
+var (
+	labelValueEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+)
+
+// metricVec represents a family of metrics that share a path but differ
+// in their label values. Same as CounterVec and DistributionVec.
+//
+// The child metric for label values v1, v2, ... vn lives at
+// path/name1=v1/name2=v2/.../namen=vn where name1 ... namen are the
+// label names.
+type metricVec struct {
+	// The directory of the family. Immutable.
+	dir *directory
+	// Immutable
+	labelNames  []string
+	unit        units.Unit
+	description string
+	// newChild returns what to register as the metric of a new child
+	// along with what With returns for that child. Immutable.
+	newChild func() (spec interface{}, child interface{})
+
+	lock           sync.Mutex
+	children       map[string]interface{}
+	maxCardinality int
+}
+
+func newMetricVec(
+	dir *directory,
+	unit units.Unit,
+	description string,
+	labelNames []string,
+	newChild func() (interface{}, interface{})) *metricVec {
+	return &metricVec{
+		dir:         dir,
+		labelNames:  append([]string(nil), labelNames...),
+		unit:        unit,
+		description: description,
+		newChild:    newChild,
+		children:    make(map[string]interface{}),
+	}
+}
+
+// checkLabelNames panics if labelNames are not valid label names for a
+// family. Callers check before creating the directory of the family.
+func checkLabelNames(labelNames []string) {
+	if len(labelNames) == 0 {
+		panic(panicNoLabelNames)
+	}
+	for _, name := range labelNames {
+		if strings.TrimSpace(name) == "" || strings.ContainsAny(name, "/=") {
+			panic(panicBadLabelName)
+		}
+	}
+}
+
+// newCounterVecInDir returns a family of counters in dir.
+func newCounterVecInDir(
+	dir *directory,
+	unit units.Unit,
+	description string,
+	labelNames []string) *metricVec {
+	return newMetricVec(
+		dir,
+		unit,
+		description,
+		labelNames,
+		func() (interface{}, interface{}) {
+			c := new(Counter)
+			return c, c
+		})
+}
+
+// newGaugeVecInDir returns a family of gauges in dir.
+func newGaugeVecInDir(
+	dir *directory,
+	unit units.Unit,
+	description string,
+	labelNames []string) *metricVec {
+	return newMetricVec(
+		dir,
+		unit,
+		description,
+		labelNames,
+		func() (interface{}, interface{}) {
+			g := new(Gauge)
+			return g, g
+		})
+}
+
+// newDistributionVecInDir returns a family of cumulative distributions
+// in dir that all use bucketer.
+func newDistributionVecInDir(
+	dir *directory,
+	bucketer *Bucketer,
+	unit units.Unit,
+	description string,
+	labelNames []string) *metricVec {
+	return newMetricVec(
+		dir,
+		unit,
+		description,
+		labelNames,
+		func() (interface{}, interface{}) {
+			dist := bucketer.NewCumulativeDistribution()
+			return dist, dist
+		})
+}
+
+// childPath returns the path of the child with given label values
+// relative to the family directory.
+func (v *metricVec) childPath(labelValues []string) pathSpec {
+	result := make(pathSpec, len(labelValues))
+	for i := range labelValues {
+		result[i] = v.labelNames[i] + "=" +
+			labelValueEscaper.Replace(labelValues[i])
+	}
+	return result
+}
+
+func (v *metricVec) overflowValues() []string {
+	result := make([]string, len(v.labelNames))
+	for i := range result {
+		result[i] = overflowLabelValue
+	}
+	return result
+}
+
+func (v *metricVec) SetMaxCardinality(max int) {
+	if max < 0 {
+		panic(panicNegativeCardinality)
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.maxCardinality = max
+}
+
+// With returns the child for the given label values creating it if
+// needed.
+func (v *metricVec) With(labelValues []string) interface{} {
+	if len(labelValues) != len(v.labelNames) {
+		panic(panicWrongLabelCount)
+	}
+	path := v.childPath(labelValues)
+	key := path.String()
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if child, ok := v.children[key]; ok {
+		return child
+	}
+	if v.maxCardinality > 0 && v.cardinality() >= v.maxCardinality {
+		labelValues = v.overflowValues()
+		path = v.childPath(labelValues)
+		key = path.String()
+		if child, ok := v.children[key]; ok {
+			return child
+		}
+	}
+	spec, child := v.newChild()
+	// If registration fails, the child still works; it just isn't
+	// reported.
+	if err := v.register(
+		path, spec, append([]string(nil), labelValues...)); err != nil {
+		errLog.Printf(
+			"Cannot register %s/%s: %v\n", v.AbsPath(), key, err)
+	}
+	v.children[key] = child
+	return child
+}
+
+// Delete removes the child with the given label values.
+func (v *metricVec) Delete(labelValues []string) {
+	if len(labelValues) != len(v.labelNames) {
+		panic(panicWrongLabelCount)
+	}
+	path := v.childPath(labelValues)
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	delete(v.children, path.String())
+	v.dir.unregisterPath(path)
+	// Remove directories that deleting the child left empty.
+	for path = path.Dir(); !path.Empty(); path = path.Dir() {
+		d := v.dir.getDirectory(path)
+		if d == nil || len(d.listUnsorted()) > 0 {
+			break
+		}
+		v.dir.unregisterPath(path)
+	}
+}
+
+// cardinality returns the number of children excluding the overflow
+// child. Caller must hold the lock.
+func (v *metricVec) cardinality() int {
+	result := len(v.children)
+	if _, ok := v.children[v.childPath(v.overflowValues()).String()]; ok {
+		result--
+	}
+	return result
+}
+
+func (v *metricVec) register(
+	path pathSpec, spec interface{}, labelValues []string) error {
+	current, err := v.dir.registerDirectory(path.Dir())
+	if err != nil {
+		return err
+	}
+	avalue, err := newValue(spec, (*region)(DefaultGroup), v.unit)
+	if err != nil {
+		return err
+	}
+	return current.storeNewMetric(
+		path.Base(),
+		&metric{
+			Description: v.description,
+			value:       avalue,
+			vec:         v,
+			labelValues: labelValues},
+		spec)
+}
+
+// AbsPath returns the absolute path of the family.
+func (v *metricVec) AbsPath() string {
+	return v.dir.AbsPath()
+}
+
+// labels returns the labels of the child with given label values.
+func (v *metricVec) labels(labelValues []string) map[string]string {
+	result := make(map[string]string, len(v.labelNames))
+	for i, name := range v.labelNames {
+		result[name] = labelValues[i]
+	}
+	return result
+}
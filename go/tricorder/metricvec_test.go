@@ -0,0 +1,132 @@
+package tricorder
+
+import (
+	"bytes"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"testing"
+)
+
+func TestCounterVec(t *testing.T) {
+	reg := NewRegistry()
+	requests, err := reg.NewCounterVec(
+		"/rpc/requests", units.None, "Request count", "method", "code")
+	if err != nil {
+		t.Fatalf("Got error %v creating counter vec", err)
+	}
+	requests.With("Get", "200").Add(3)
+	requests.With("Get", "404").Add(1)
+	requests.With("a/b%c", "200").Add(2)
+	if requests.With("Get", "200") != requests.With("Get", "200") {
+		t.Error("Expected With to return the same counter")
+	}
+	list := reg.ReadMyMetrics("/rpc/requests")
+	if assertValueEquals(t, 3, len(list)) {
+		assertValueEquals(t, "/rpc/requests/method=Get/code=200", list[0].Path)
+		assertValueEquals(t, uint64(3), list[0].Value)
+		assertValueDeepEquals(
+			t,
+			map[string]string{"method": "Get", "code": "200"},
+			list[0].Labels)
+		assertValueEquals(t, "/rpc/requests/method=Get/code=404", list[1].Path)
+		assertValueEquals(
+			t, "/rpc/requests/method=a%2Fb%25c/code=200", list[2].Path)
+		assertValueDeepEquals(
+			t,
+			map[string]string{"method": "a/b%c", "code": "200"},
+			list[2].Labels)
+	}
+	requests.Delete("Get", "404")
+	requests.Delete("a/b%c", "200")
+	dir, _ := reg.GetDirectory("/rpc/requests")
+	verifyChildren(t, (*directory)(dir).List(), "method=Get")
+	assertValueEquals(t, 1, len(reg.ReadMyMetrics("/rpc/requests")))
+
+	if _, err := reg.NewCounterVec(
+		"/rpc/requests/method=Get/code=200", units.None, "", "x"); err != ErrPathInUse {
+		t.Errorf("Expected ErrPathInUse, got %v", err)
+	}
+	// Families need a directory of their own.
+	if _, err := reg.NewCounterVec(
+		"/rpc/requests", units.None, "", "x"); err != ErrPathInUse {
+		t.Errorf("Expected ErrPathInUse, got %v", err)
+	}
+	if _, err := reg.NewGaugeVec(
+		"/rpc", units.None, "", "x"); err != ErrPathInUse {
+		t.Errorf("Expected ErrPathInUse, got %v", err)
+	}
+}
+
+func TestMetricVecMaxCardinality(t *testing.T) {
+	reg := NewRegistry()
+	requests, _ := reg.NewCounterVec(
+		"/requests", units.None, "Request count", "user")
+	requests.SetMaxCardinality(2)
+	requests.With("alice").Add(1)
+	requests.With("bob").Add(1)
+	requests.With("carol").Add(1)
+	requests.With("dave").Add(1)
+	requests.With("alice").Add(1)
+	if requests.With("carol") != requests.With("other") {
+		t.Error("Expected overflow to go to other")
+	}
+	list := reg.ReadMyMetrics("/requests")
+	if assertValueEquals(t, 3, len(list)) {
+		assertValueEquals(t, "/requests/user=alice", list[0].Path)
+		assertValueEquals(t, uint64(2), list[0].Value)
+		assertValueEquals(t, "/requests/user=bob", list[1].Path)
+		assertValueEquals(t, uint64(1), list[1].Value)
+		assertValueEquals(t, "/requests/user=other", list[2].Path)
+		assertValueEquals(t, uint64(2), list[2].Value)
+	}
+}
+
+func TestMetricVecPanics(t *testing.T) {
+	reg := NewRegistry()
+	requests, _ := reg.NewCounterVec("/requests", units.None, "", "a", "b")
+	assertPanics(t, func() { requests.With("x") })
+	assertPanics(t, func() { requests.Delete("x", "y", "z") })
+	assertPanics(t, func() { reg.NewCounterVec("/bad", units.None, "") })
+	assertPanics(t, func() { reg.NewCounterVec("/bad", units.None, "", "a=b") })
+	assertPanics(t, func() { requests.SetMaxCardinality(-1) })
+}
+
+func TestDistributionVecPrometheus(t *testing.T) {
+	reg := NewRegistry()
+	latency, err := reg.NewDistributionVec(
+		"/rpc/latency",
+		NewArbitraryBucketer(10),
+		units.Millisecond,
+		"RPC latency",
+		"method")
+	if err != nil {
+		t.Fatalf("Got error %v creating distribution vec", err)
+	}
+	latency.With("Get").Add(5.0)
+	latency.With(`Put"`).Add(50.0)
+	var buffer bytes.Buffer
+	if err := reg.root.GetAllMetrics(
+		newPrometheusCollector(&buffer), nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP rpc_latency_milliseconds RPC latency
+# TYPE rpc_latency_milliseconds histogram
+rpc_latency_milliseconds_bucket{method="Get",le="10"} 1
+rpc_latency_milliseconds_bucket{method="Get",le="+Inf"} 1
+rpc_latency_milliseconds_sum{method="Get"} 5
+rpc_latency_milliseconds_count{method="Get"} 1
+rpc_latency_milliseconds_bucket{method="Put\"",le="10"} 0
+rpc_latency_milliseconds_bucket{method="Put\"",le="+Inf"} 1
+rpc_latency_milliseconds_sum{method="Put\""} 50
+rpc_latency_milliseconds_count{method="Put\""} 1
+`
+	assertValueEquals(t, expected, buffer.String())
+}
+
+func assertPanics(t *testing.T, f func()) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic")
+		}
+	}()
+	f()
+}
@@ -0,0 +1,270 @@
+package tricorder
+
+import (
+	"encoding/gob"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// persistedMetrics is what a persister writes to its file.
+type persistedMetrics struct {
+	// The epoch of the process that wrote the file
+	Epoch   string
+	Metrics messages.MetricList
+}
+
+// savedMetric is a metric that an earlier process saved.
+type savedMetric struct {
+	Metric *messages.Metric
+	// The epoch of the process that saved Metric
+	Epoch string
+}
+
+// restorer holds the metrics that an earlier process saved until
+// metrics with the same paths get registered.
+type restorer struct {
+	lock  sync.Mutex
+	saved map[string]savedMetric
+}
+
+func newRestorer() *restorer {
+	return &restorer{saved: make(map[string]savedMetric)}
+}
+
+// Load adds the metrics in list that the process with given epoch saved
+// to the metrics waiting to be restored.
+func (r *restorer) Load(list messages.MetricList, epoch string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, m := range list {
+		r.saved[m.Path] = savedMetric{Metric: m, Epoch: epoch}
+	}
+}
+
+// Pending returns the saved metrics that have not been restored yet.
+func (r *restorer) Pending() messages.MetricList {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	result := make(messages.MetricList, 0, len(r.saved))
+	for _, m := range r.saved {
+		result = append(result, m.Metric)
+	}
+	return result
+}
+
+// Restore adds the saved value of m, if any, to the value of m and
+// records the epoch of the process that saved it.
+// spec is what the caller passed to register m. r may be nil.
+func (r *restorer) Restore(m *metric, spec interface{}) {
+	if r == nil {
+		return
+	}
+	path := m.AbsPath()
+	r.lock.Lock()
+	entry, ok := r.saved[path]
+	delete(r.saved, path)
+	r.lock.Unlock()
+	saved := entry.Metric
+	if !ok || saved.Err != "" ||
+		saved.Kind != m.Type() || saved.Unit != m.Unit() {
+		return
+	}
+	if restoreValue(spec, saved.Value) {
+		m.restoredEpoch = entry.Epoch
+		atomic.StoreUint32(&m.isRestored, 1)
+	}
+}
+
+// restoreValue adds savedValue, in Go RPC form, to spec which is
+// a *Counter or a *CumulativeDistribution. restoreValue returns false
+// if it can't restore spec. In particular, restoreValue never restores
+// gauges as adding the saved value would double count.
+func restoreValue(spec interface{}, savedValue interface{}) bool {
+	switch s := spec.(type) {
+	case *Counter:
+		if saved, ok := savedValue.(uint64); ok {
+			s.Add(saved)
+			return true
+		}
+		return false
+	case *CumulativeDistribution:
+		return restoreDistribution((*distribution)(s), savedValue)
+	case *distribution:
+		if s.isNotCumulative {
+			return false
+		}
+		return restoreDistribution(s, savedValue)
+	}
+	return false
+}
+
+func restoreDistribution(d *distribution, savedValue interface{}) bool {
+	saved, ok := savedValue.(*messages.Distribution)
+	if !ok || saved == nil {
+		return false
+	}
+	return d.restore(saved)
+}
+
+// restore adds the values in saved, a distribution that an earlier
+// process saved, to this distribution. restore returns false if saved
+// has different buckets.
+func (d *distribution) restore(saved *messages.Distribution) bool {
+	if len(saved.Ranges) != len(d.pieces) {
+		return false
+	}
+	for i, r := range saved.Ranges {
+		if r.Lower != d.pieces[i].Start || r.Upper != d.pieces[i].End {
+			return false
+		}
+	}
+	if saved.Count == 0 {
+		return true
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for i, r := range saved.Ranges {
+		d.counts[i] += r.Count
+	}
+	if d.count == 0 {
+		d.min = saved.Min
+		d.max = saved.Max
+	} else {
+		if saved.Min < d.min {
+			d.min = saved.Min
+		}
+		if saved.Max > d.max {
+			d.max = saved.Max
+		}
+	}
+	d.total += saved.Sum
+	d.count += saved.Count
+	d.generation++
+	return true
+}
+
+// isPersistable returns true if m, in Go RPC form, is a metric that
+// tricorder can restore.
+func isPersistable(m *messages.Metric) bool {
+	if m.Err != "" {
+		return false
+	}
+	if m.Kind == types.Dist {
+		dist, ok := m.Value.(*messages.Distribution)
+		return ok && dist != nil && !dist.IsNotCumulative &&
+			dist.Sketch == nil
+	}
+	// Only Counter instances restore plain values. Other numeric values
+	// are gauges which make no sense to add to.
+	return m.Kind == types.Uint64 && m.IsMonotonic
+}
+
+// persister periodically saves metrics to a file. Same as Persister.
+type persister struct {
+	registry *registry
+	filename string
+	paths    []string
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+	stopOnce sync.Once
+	// Serializes saves
+	lock sync.Mutex
+}
+
+func (r *registry) persist(
+	filename string, interval time.Duration, paths ...string) (
+	*persister, error) {
+	if interval <= 0 {
+		panic(panicNonPositiveInterval)
+	}
+	if err := r.loadPersisted(filename); err != nil {
+		return nil, err
+	}
+	result := &persister{
+		registry: r,
+		filename: filename,
+		paths:    paths,
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+	go result.loop(interval)
+	return result, nil
+}
+
+// loadPersisted loads the metrics in filename so that they get restored
+// as they are registered. A missing file is not an error.
+func (r *registry) loadPersisted(filename string) error {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var persisted persistedMetrics
+	if err := gob.NewDecoder(file).Decode(&persisted); err != nil {
+		return err
+	}
+	r.root.restorer.Load(persisted.Metrics, persisted.Epoch)
+	return nil
+}
+
+func (p *persister) loop(interval time.Duration) {
+	defer close(p.doneCh)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.Save()
+		case <-p.stopCh:
+			return
+		}
+	}
+}
+
+func (p *persister) Save() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	persisted := persistedMetrics{Epoch: changeEpoch}
+	for _, path := range p.paths {
+		for _, m := range p.registry.readMyMetrics(path) {
+			if isPersistable(m) {
+				persisted.Metrics = append(persisted.Metrics, m)
+			}
+		}
+	}
+	// Carry forward saved metrics not registered yet so that they are
+	// not lost if this process exits before registering them.
+	persisted.Metrics = append(
+		persisted.Metrics, p.registry.root.restorer.Pending()...)
+	// Write to a temporary file first so that a crash never leaves a
+	// partially written file behind.
+	tempFile, err := ioutil.TempFile(
+		filepath.Dir(p.filename), filepath.Base(p.filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	if err := gob.NewEncoder(tempFile).Encode(&persisted); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), p.filename)
+}
+
+func (p *persister) Stop() error {
+	p.stopOnce.Do(func() { close(p.stopCh) })
+	<-p.doneCh
+	return p.Save()
+}
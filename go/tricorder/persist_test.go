@@ -0,0 +1,250 @@
+package tricorder
+
+import (
+	"encoding/gob"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func metricsByPath(list messages.MetricList) map[string]*messages.Metric {
+	result := make(map[string]*messages.Metric, len(list))
+	for _, m := range list {
+		result[m.Path] = m
+	}
+	return result
+}
+
+func TestPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "metrics")
+
+	// The first process
+	first := NewRegistry()
+	persister, err := first.Persist(filename, time.Hour, "/saved")
+	if err != nil {
+		t.Fatalf("Got error %v persisting", err)
+	}
+	var requests Counter
+	var bytes uint64 = 300
+	var name = "first"
+	var temperature int64 = 20
+	var notSaved int64 = 7
+	latency := PowersOfTen.NewCumulativeDistribution()
+	other := PowersOfTen.NewCumulativeDistribution()
+	first.RegisterMetric("/saved/requests", &requests, units.None, "")
+	first.RegisterMetric("/saved/bytes", &bytes, units.Byte, "")
+	first.RegisterMetric("/saved/name", &name, units.None, "")
+	first.RegisterMetric(
+		"/saved/temperature", &temperature, units.Celsius, "")
+	first.RegisterMetric(
+		"/saved/latency", latency, units.Millisecond, "")
+	first.RegisterMetric("/saved/other", other, units.Millisecond, "")
+	first.RegisterMetric("/notsaved", &notSaved, units.None, "")
+	requests.Add(5)
+	latency.Add(20 * time.Millisecond)
+	latency.Add(500 * time.Millisecond)
+	other.Add(time.Millisecond)
+	if err := persister.Stop(); err != nil {
+		t.Fatalf("Got error %v stopping", err)
+	}
+
+	// The second process
+	second := NewRegistry()
+	persister, err = second.Persist(filename, time.Hour, "/saved")
+	if err != nil {
+		t.Fatalf("Got error %v persisting", err)
+	}
+	var newRequests Counter
+	newRequests.Inc()
+	var newBytes uint64
+	var newName string
+	var newTemperature int64 = 20
+	var newNotSaved int64
+	newLatency := PowersOfTen.NewCumulativeDistribution()
+	second.RegisterMetric("/saved/requests", &newRequests, units.None, "")
+	second.RegisterMetric("/saved/bytes", &newBytes, units.Byte, "")
+	second.RegisterMetric("/saved/name", &newName, units.None, "")
+	// Gauges are not restored even with the same unit
+	second.RegisterMetric(
+		"/saved/temperature", &newTemperature, units.Celsius, "")
+	second.RegisterMetric(
+		"/saved/latency", newLatency, units.Millisecond, "")
+	second.RegisterMetric("/notsaved", &newNotSaved, units.None, "")
+	metrics := metricsByPath(second.ReadMyMetrics("/"))
+
+	m := metrics["/saved/requests"]
+	assertValueEquals(t, uint64(6), m.Value)
+	assertValueEquals(t, true, m.IsRestored)
+	// Both processes are this test so they share the same epoch.
+	assertValueEquals(t, changeEpoch, m.Epoch)
+	m = metrics["/saved/latency"]
+	assertValueEquals(t, true, m.IsRestored)
+	dist := m.Value.(*messages.Distribution)
+	assertValueEquals(t, uint64(2), dist.Count)
+	assertValueEquals(t, 20.0, dist.Min)
+	assertValueEquals(t, 500.0, dist.Max)
+	assertValueEquals(t, 520.0, dist.Sum)
+	newLatency.Add(time.Second)
+	assertValueEquals(
+		t, uint64(3), (*distribution)(newLatency).Snapshot().Count)
+	m = metrics["/saved/bytes"]
+	assertValueEquals(t, uint64(0), m.Value)
+	assertValueEquals(t, false, m.IsRestored)
+	assertValueEquals(t, "", m.Epoch)
+	m = metrics["/saved/name"]
+	assertValueEquals(t, "", m.Value)
+	assertValueEquals(t, false, m.IsRestored)
+	m = metrics["/saved/temperature"]
+	assertValueEquals(t, int64(20), m.Value)
+	assertValueEquals(t, false, m.IsRestored)
+	m = metrics["/notsaved"]
+	assertValueEquals(t, int64(0), m.Value)
+	assertValueEquals(t, false, m.IsRestored)
+
+	// /saved/other was never registered in the second process, but it
+	// gets saved again.
+	if err := persister.Stop(); err != nil {
+		t.Fatalf("Got error %v stopping", err)
+	}
+	third := NewRegistry()
+	persister, err = third.Persist(filename, time.Hour)
+	if err != nil {
+		t.Fatalf("Got error %v persisting", err)
+	}
+	defer persister.Stop()
+	newOther := PowersOfTen.NewCumulativeDistribution()
+	third.RegisterMetric("/saved/other", newOther, units.Millisecond, "")
+	assertValueEquals(t, uint64(1), (*distribution)(newOther).Snapshot().Count)
+	var thirdRequests Counter
+	third.RegisterMetric("/saved/requests", &thirdRequests, units.None, "")
+	assertValueEquals(t, uint64(6), thirdRequests.Value())
+}
+
+func TestPersistEpoch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "metrics")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gob.NewEncoder(file).Encode(&persistedMetrics{
+		Epoch: "earlier",
+		Metrics: messages.MetricList{
+			{
+				Path:        "/requests",
+				Kind:        types.Uint64,
+				Unit:        units.None,
+				Value:       uint64(3),
+				IsMonotonic: true,
+			},
+		},
+	})
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := NewRegistry()
+	persister, err := reg.Persist(filename, time.Hour, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer persister.Stop()
+	var requests Counter
+	reg.RegisterMetric("/requests", &requests, units.None, "")
+	m := reg.ReadMyMetrics("/requests")[0]
+	assertValueEquals(t, uint64(3), m.Value)
+	assertValueEquals(t, true, m.IsRestored)
+	// The epoch is that of the process that saved the value.
+	assertValueEquals(t, "earlier", m.Epoch)
+}
+
+func TestPersistCounterVec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "metrics")
+	first := NewRegistry()
+	persister, err := first.Persist(filename, time.Hour, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests, err := first.NewCounterVec(
+		"/requests", units.None, "", "method")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests.With("Get").Add(4)
+	persister.Stop()
+
+	second := NewRegistry()
+	if _, err := second.Persist(filename, time.Hour, "/"); err != nil {
+		t.Fatal(err)
+	}
+	newRequests, err := second.NewCounterVec(
+		"/requests", units.None, "", "method")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newRequests.With("Get").Inc()
+	assertValueEquals(t, uint64(5), newRequests.With("Get").Value())
+	m := second.ReadMyMetrics("/requests/method=Get")[0]
+	assertValueEquals(t, true, m.IsRestored)
+}
+
+func TestPersistDifferentBuckets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "metrics")
+	first := NewRegistry()
+	persister, err := first.Persist(filename, time.Hour, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dist := PowersOfTen.NewCumulativeDistribution()
+	first.RegisterMetric("/dist", dist, units.None, "")
+	dist.Add(3.0)
+	persister.Stop()
+
+	second := NewRegistry()
+	if _, err := second.Persist(filename, time.Hour, "/"); err != nil {
+		t.Fatal(err)
+	}
+	newDist := NewGeometricBucketer(1, 1000).NewCumulativeDistribution()
+	second.RegisterMetric("/dist", newDist, units.None, "")
+	assertValueEquals(t, uint64(0), (*distribution)(newDist).Snapshot().Count)
+	assertValueEquals(t, false, second.ReadMyMetrics("/dist")[0].IsRestored)
+}
+
+func TestPersistBadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "metrics")
+	if err := ioutil.WriteFile(filename, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRegistry().Persist(filename, time.Hour, "/"); err == nil {
+		t.Error("Expected error reading bad file")
+	}
+}
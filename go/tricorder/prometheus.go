@@ -0,0 +1,286 @@
+package tricorder
+
+import (
+	"fmt"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var (
+	prometheusUnitSuffixes = map[units.Unit]string{
+		units.Millisecond:   "_milliseconds",
+		units.Second:        "_seconds",
+		units.Celsius:       "_celsius",
+		units.Byte:          "_bytes",
+		units.BytePerSecond: "_bytes_per_second",
+	}
+	prometheusHelpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	prometheusLabelValueEscaper = strings.NewReplacer(
+		`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// prometheusSanitize replaces each character in s that is not allowed in
+// a prometheus metric or label name with an underscore.
+func prometheusSanitize(s string) string {
+	name := []byte(s)
+	for i, c := range name {
+		isLetter := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		isDigit := c >= '0' && c <= '9'
+		if !isLetter && !isDigit && c != '_' {
+			name[i] = '_'
+		}
+	}
+	result := string(name)
+	if result == "" || (result[0] >= '0' && result[0] <= '9') {
+		result = "_" + result
+	}
+	return result
+}
+
+// prometheusName converts an absolute metric path such as
+// "/proc/cpu/user" to a valid prometheus metric name such as
+// "proc_cpu_user" and appends a suffix for the unit.
+func prometheusName(path string, t types.Type, unit units.Unit) string {
+	result := prometheusSanitize(newPathSpec(path).String())
+	suffix, ok := prometheusUnitSuffixes[unit]
+	if !ok && (t == types.GoTime || t == types.GoDuration) {
+		// time values are always reported in seconds.
+		suffix = "_seconds"
+	}
+	if !strings.HasSuffix(result, suffix) {
+		result += suffix
+	}
+	return result
+}
+
+func prometheusFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// prometheusCollector emits metrics in the prometheus text exposition
+// format. Metrics that prometheus cannot represent such as strings and
+// lists are skipped.
+type prometheusCollector struct {
+	W io.Writer
+	// Metric names already emitted. Prometheus rejects duplicate
+	// metric families, so a path that sanitizes to an existing name
+	// is skipped.
+	seen map[string]bool
+	// The family of the last metric emitted or nil if that metric
+	// belongs to no family. Since metrics are collected in order by
+	// path, the metrics of a family are collected consecutively.
+	lastVec *metricVec
+}
+
+func newPrometheusCollector(w io.Writer) *prometheusCollector {
+	return &prometheusCollector{W: w, seen: make(map[string]bool)}
+}
+
+func (c *prometheusCollector) Collect(m *metric, s *session) error {
+	t := m.Type()
+	if t == types.String || t == types.List {
+		return nil
+	}
+	var name string
+	header := true
+	if m.vec != nil {
+		name = prometheusName(m.vec.AbsPath(), t, m.Unit())
+		// Emit the header only for the first metric of a family.
+		header = c.lastVec != m.vec
+	} else {
+		name = prometheusName(m.AbsPath(), t, m.Unit())
+	}
+	if header {
+		if c.seen[name] {
+			return nil
+		}
+		c.seen[name] = true
+	}
+	c.lastVec = m.vec
+	if m.IsMonotonic() && !strings.HasSuffix(name, "_total") {
+		name += "_total"
+	}
+	labels := prometheusLabels(m)
+	if t == types.Dist {
+		return c.emitDistribution(name, labels, header, m)
+	}
+	return c.emitScalar(name, labels, header, m, s)
+}
+
+// prometheusLabels returns the labels of m as name="value" pairs
+// separated by commas or the empty string if m has no labels.
+func prometheusLabels(m *metric) string {
+	if m.vec == nil {
+		return ""
+	}
+	pairs := make([]string, len(m.labelValues))
+	for i, value := range m.labelValues {
+		pairs[i] = fmt.Sprintf(
+			"%s=\"%s\"",
+			prometheusSanitize(m.vec.labelNames[i]),
+			prometheusLabelValueEscaper.Replace(value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// withLabels returns labels enclosed in braces or the empty string if
+// labels is empty.
+func withLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func (c *prometheusCollector) emitHeader(
+	name, description, metricType string) error {
+	if description != "" {
+		_, err := fmt.Fprintf(
+			c.W,
+			"# HELP %s %s\n",
+			name,
+			prometheusHelpEscaper.Replace(description))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(c.W, "# TYPE %s %s\n", name, metricType)
+	return err
+}
+
+func (c *prometheusCollector) emitScalar(
+	name, labels string, header bool, m *metric, s *session) error {
+	var valueStr string
+	switch t := m.Type(); {
+	case t == types.Bool:
+		if m.AsBool(s) {
+			valueStr = "1"
+		} else {
+			valueStr = "0"
+		}
+	case t.IsInt():
+		valueStr = strconv.FormatInt(m.AsInt(s), 10)
+	case t.IsUint():
+		valueStr = strconv.FormatUint(m.AsUint(s), 10)
+	case t.IsFloat():
+		valueStr = prometheusFloat(m.AsFloat(s))
+	case t == types.GoTime:
+		valueStr = m.AsDuration(s).String()
+	case t == types.GoDuration:
+		valueStr = m.AsDuration(s).StringUsingUnits(m.Unit())
+	default:
+		return nil
+	}
+	metricType := "gauge"
+	if m.IsMonotonic() {
+		metricType = "counter"
+	}
+	if header {
+		if err := c.emitHeader(name, m.Description, metricType); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(c.W, "%s%s %s\n", name, withLabels(labels), valueStr)
+	return err
+}
+
+// emitDistribution emits a cumulative distribution as a prometheus
+// histogram. Since the values in a non cumulative distribution can
+// decrease, emitDistribution emits those as a gauge histogram using the
+// _bucket, _gsum, and _gcount series. Because version 0.0.4 of the text
+// format has no gauge histogram type, each of these series is typed as
+// a gauge.
+// Tricorder buckets exclude their upper bound while prometheus buckets
+// include it, so a value exactly on a bucket boundary is counted in the
+// next higher bucket.
+func (c *prometheusCollector) emitDistribution(
+	name, labels string, header bool, m *metric) error {
+	dist := m.AsDistribution()
+	snapshot := dist.Snapshot()
+	bucketName := name + "_bucket"
+	sumName := name + "_sum"
+	countName := name + "_count"
+	if dist.IsNotCumulative() {
+		sumName = name + "_gsum"
+		countName = name + "_gcount"
+	}
+	if header && dist.IsNotCumulative() {
+		for _, n := range []string{bucketName, sumName, countName} {
+			if err := c.emitHeader(n, m.Description, "gauge"); err != nil {
+				return err
+			}
+		}
+	} else if header {
+		if err := c.emitHeader(name, m.Description, "histogram"); err != nil {
+			return err
+		}
+	}
+	leLabels := labels
+	if leLabels != "" {
+		leLabels += ","
+	}
+	var cumulativeCount uint64
+	for _, piece := range snapshot.Breakdown {
+		cumulativeCount += piece.Count
+		if piece.Last {
+			break
+		}
+		_, err := fmt.Fprintf(
+			c.W,
+			"%s{%sle=\"%s\"} %d\n",
+			bucketName,
+			leLabels,
+			prometheusFloat(piece.End),
+			cumulativeCount)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(
+		c.W,
+		"%s{%sle=\"+Inf\"} %d\n",
+		bucketName,
+		leLabels,
+		snapshot.Count); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(
+		c.W,
+		"%s%s %s\n",
+		sumName,
+		withLabels(labels),
+		prometheusFloat(snapshot.Sum)); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(
+		c.W, "%s%s %d\n", countName, withLabels(labels), snapshot.Count)
+	return err
+}
+
+func (reg *registry) prometheusEmitDirectoryOrMetric(
+	sel *selector, w http.ResponseWriter) error {
+	d, m := reg.root.getDirectoryOrMetric(sel.base)
+	if d == nil && m == nil {
+		httpError(w, http.StatusNotFound)
+		return nil
+	}
+	w.Header().Set("Content-Type", prometheusContentType)
+	collector := newPrometheusCollector(w)
+	if !sel.IsPath() {
+		return reg.root.GetAllMetricsBySelector(sel, collector, nil)
+	}
+	if m == nil {
+		return d.GetAllMetrics(collector, nil)
+	}
+	s := newSession()
+	defer s.Close()
+	return collect(m, s, collector)
+}
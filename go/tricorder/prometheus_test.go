@@ -0,0 +1,106 @@
+package tricorder
+
+import (
+	"bytes"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"testing"
+	"time"
+)
+
+func TestPrometheusName(t *testing.T) {
+	assertValueEquals(
+		t,
+		"proc_cpu_user_seconds",
+		prometheusName("/proc/cpu/user", types.GoDuration, units.Second))
+	assertValueEquals(
+		t,
+		"proc_rpc_latency_milliseconds",
+		prometheusName(
+			"/proc/rpc-latency", types.Dist, units.Millisecond))
+	assertValueEquals(
+		t,
+		"proc_memory_total_bytes",
+		prometheusName("/proc/memory/total", types.Uint64, units.Byte))
+	assertValueEquals(
+		t,
+		"a_b_seconds",
+		prometheusName("/a/b.seconds", types.Float64, units.Second))
+	assertValueEquals(
+		t,
+		"_9lives",
+		prometheusName("/9lives", types.Int64, units.None))
+	assertValueEquals(
+		t,
+		"proc_start_time_seconds",
+		prometheusName("/proc/start-time", types.GoTime, units.None))
+}
+
+func TestPrometheusEmit(t *testing.T) {
+	dir := newDirectory()
+	someGroup := registerGroup(func() time.Time {
+		return kUsualTimeStamp
+	})
+	count := uint64(37)
+	temperature := 22.5
+	name := "not emitted"
+	enabled := true
+	latency := NewArbitraryBucketer(10, 100).NewCumulativeDistribution()
+	inFlight := NewArbitraryBucketer(10).NewNonCumulativeDistribution()
+	dir.registerMetric(
+		newPathSpec("/rpc/count"), &count, (*region)(someGroup),
+		units.None, "RPC count")
+	dir.registerMetric(
+		newPathSpec("/temperature"), &temperature, (*region)(someGroup),
+		units.Celsius, "Temperature\nin celsius")
+	dir.registerMetric(
+		newPathSpec("/name"), &name, (*region)(someGroup),
+		units.None, "Name")
+	dir.registerMetric(
+		newPathSpec("/enabled"), &enabled, (*region)(someGroup),
+		units.None, "")
+	dir.registerMetric(
+		newPathSpec("/rpc/latency"), latency, (*region)(someGroup),
+		units.Millisecond, "RPC latency")
+	dir.registerMetric(
+		newPathSpec("/rpc/in-flight"), inFlight, (*region)(someGroup),
+		units.None, "In flight")
+	latency.Add(5.0)
+	latency.Add(50.0)
+	latency.Add(75.0)
+	latency.Add(500.0)
+	inFlight.Add(3.0)
+	inFlight.Add(30.0)
+
+	var buffer bytes.Buffer
+	if err := dir.GetAllMetrics(newPrometheusCollector(&buffer), nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# TYPE enabled gauge
+enabled 1
+# HELP rpc_count RPC count
+# TYPE rpc_count gauge
+rpc_count 37
+# HELP rpc_in_flight_bucket In flight
+# TYPE rpc_in_flight_bucket gauge
+# HELP rpc_in_flight_gsum In flight
+# TYPE rpc_in_flight_gsum gauge
+# HELP rpc_in_flight_gcount In flight
+# TYPE rpc_in_flight_gcount gauge
+rpc_in_flight_bucket{le="10"} 1
+rpc_in_flight_bucket{le="+Inf"} 2
+rpc_in_flight_gsum 33
+rpc_in_flight_gcount 2
+# HELP rpc_latency_milliseconds RPC latency
+# TYPE rpc_latency_milliseconds histogram
+rpc_latency_milliseconds_bucket{le="10"} 1
+rpc_latency_milliseconds_bucket{le="100"} 3
+rpc_latency_milliseconds_bucket{le="+Inf"} 4
+rpc_latency_milliseconds_sum 630
+rpc_latency_milliseconds_count 4
+# HELP temperature_celsius Temperature\nin celsius
+# TYPE temperature_celsius gauge
+temperature_celsius 22.5
+`
+	assertValueEquals(t, expected, buffer.String())
+}
@@ -0,0 +1,453 @@
+package tricorder
+
+import (
+	"fmt"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// channelRegion is the event loop implementation that region replaced.
+// It stays here so that the benchmarks can compare the two.
+type channelRegion struct {
+	sendCh     chan int
+	receiveCh  chan time.Time
+	lockCount  int
+	updateFunc func() time.Time
+	updateTime time.Time
+}
+
+func newChannelRegion(updateFunc func() time.Time) *channelRegion {
+	result := &channelRegion{
+		sendCh:     make(chan int),
+		receiveCh:  make(chan time.Time),
+		updateFunc: updateFunc}
+	go result.handleRequests()
+	return result
+}
+
+func (r *channelRegion) handleRequests() {
+	for in := range r.sendCh {
+		prevLockCount := r.lockCount
+		r.lockCount += in
+		if prevLockCount == 0 && r.lockCount > 0 {
+			r.updateTime = r.updateFunc()
+		}
+		r.receiveCh <- r.updateTime
+	}
+}
+
+func (r *channelRegion) RLock() time.Time {
+	r.sendCh <- 1
+	return <-r.receiveCh
+}
+
+func (r *channelRegion) RUnlock() {
+	r.sendCh <- -1
+	<-r.receiveCh
+}
+
+func (r *channelRegion) Close() {
+	close(r.sendCh)
+}
+
+type regionLocker interface {
+	RLock() time.Time
+	RUnlock()
+}
+
+func TestRegionUpdatesOncePerOverlappingRequest(t *testing.T) {
+	updates := 0
+	r := newRegion(func() time.Time {
+		updates++
+		return kUsualTimeStamp
+	})
+	assertValueEquals(t, kUsualTimeStamp, r.RLock())
+	assertValueEquals(t, kUsualTimeStamp, r.RLock())
+	assertValueEquals(t, 1, updates)
+	r.RUnlock()
+	r.RUnlock()
+	r.RLock()
+	r.RUnlock()
+	assertValueEquals(t, 2, updates)
+	assertPanics(t, func() { r.RUnlock() })
+}
+
+func TestRegionConcurrentReaders(t *testing.T) {
+	var lock sync.Mutex
+	updates := 0
+	r := newRegion(func() time.Time {
+		lock.Lock()
+		defer lock.Unlock()
+		updates++
+		return kUsualTimeStamp
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.RLock()
+				r.RUnlock()
+			}
+		}()
+	}
+	wg.Wait()
+	lock.Lock()
+	defer lock.Unlock()
+	if updates < 1 || updates > 2000 {
+		t.Errorf("Expected between 1 and 2000 updates, got %d", updates)
+	}
+}
+
+func TestGroupClose(t *testing.T) {
+	updates := 0
+	var value int64
+	group := NewGroup()
+	group.RegisterUpdateFunc(func() time.Time {
+		updates++
+		value++
+		return kUsualTimeStamp
+	})
+	reg := NewRegistry()
+	if err := reg.RegisterMetricInGroup(
+		"/value", &value, group, units.None, "value"); err != nil {
+		t.Fatalf("Got error %v registering metric", err)
+	}
+	list := reg.ReadMyMetrics("/value")
+	assertValueEquals(t, int64(1), list[0].Value)
+	group.Close()
+	// Close is idempotent and new update functions have no effect.
+	group.Close()
+	group.RegisterUpdateFunc(func() time.Time {
+		panic("Closed group should not call update function")
+	})
+	list = reg.ReadMyMetrics("/value")
+	assertValueEquals(t, int64(1), list[0].Value)
+	assertValueEquals(t, kUsualTimeStamp, list[0].TimeStamp)
+	assertValueEquals(t, 1, updates)
+}
+
+func TestGroupUpdateTimeout(t *testing.T) {
+	var value Counter
+	release := make(chan struct{})
+	var hang int32
+	group := NewGroup()
+	group.SetUpdateTimeout(10 * time.Millisecond)
+	group.RegisterUpdateFunc(func() time.Time {
+		if atomic.LoadInt32(&hang) != 0 {
+			<-release
+			return time.Now()
+		}
+		value.Inc()
+		return kUsualTimeStamp
+	})
+	reg := NewRegistry()
+	if err := reg.RegisterMetricInGroup(
+		"/value", &value, group, units.None, "value"); err != nil {
+		t.Fatalf("Got error %v registering metric", err)
+	}
+	var variable int64
+	err := reg.RegisterMetricInGroup(
+		"/variable", &variable, group, units.None, "variable")
+	if err != ErrVariableWithTimeout {
+		t.Errorf("Expected ErrVariableWithTimeout, got %v", err)
+	}
+	list := reg.ReadMyMetrics("/value")
+	assertValueEquals(t, uint64(1), list[0].Value)
+	assertValueEquals(t, false, list[0].IsStale)
+	failures := updateFailureCount.Value()
+	atomic.StoreInt32(&hang, 1)
+	list = reg.ReadMyMetrics("/value")
+	assertValueEquals(t, uint64(1), list[0].Value)
+	assertValueEquals(t, kUsualTimeStamp, list[0].TimeStamp)
+	assertValueEquals(t, true, list[0].IsStale)
+	// The hung update function does not get called again, and it counts
+	// as only one failure.
+	list = reg.ReadMyMetrics("/value")
+	assertValueEquals(t, true, list[0].IsStale)
+	assertValueEquals(t, failures+1, updateFailureCount.Value())
+	atomic.StoreInt32(&hang, 0)
+	close(release)
+	// Wait for the hung update function to finish.
+	for i := 0; i < 100; i++ {
+		list = reg.ReadMyMetrics("/value")
+		if !list[0].IsStale {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertValueEquals(t, false, list[0].IsStale)
+	assertValueEquals(t, uint64(2), list[0].Value)
+}
+
+func TestGroupUpdateTimeoutWithVariables(t *testing.T) {
+	var value int64
+	group := NewGroup()
+	reg := NewRegistry()
+	if err := reg.RegisterMetricInGroup(
+		"/value", &value, group, units.None, "value"); err != nil {
+		t.Fatalf("Got error %v registering metric", err)
+	}
+	assertPanics(t, func() { group.SetUpdateTimeout(time.Second) })
+	// Turning the timeout off is fine.
+	group.SetUpdateTimeout(0)
+}
+
+func TestGroupRecoverPanics(t *testing.T) {
+	var value int64
+	shouldPanic := false
+	group := NewGroup()
+	group.RegisterUpdateFunc(func() time.Time {
+		if shouldPanic {
+			panic("update failed")
+		}
+		value++
+		return kUsualTimeStamp
+	})
+	reg := NewRegistry()
+	if err := reg.RegisterMetricInGroup(
+		"/value", &value, group, units.None, "value"); err != nil {
+		t.Fatalf("Got error %v registering metric", err)
+	}
+	reg.ReadMyMetrics("/value")
+	shouldPanic = true
+	assertPanics(t, func() { reg.ReadMyMetrics("/value") })
+	group.SetRecoverPanics(true)
+	failures := updateFailureCount.Value()
+	list := reg.ReadMyMetrics("/value")
+	assertValueEquals(t, int64(1), list[0].Value)
+	assertValueEquals(t, kUsualTimeStamp, list[0].TimeStamp)
+	assertValueEquals(t, true, list[0].IsStale)
+	assertValueEquals(t, failures+1, updateFailureCount.Value())
+	shouldPanic = false
+	list = reg.ReadMyMetrics("/value")
+	assertValueEquals(t, int64(2), list[0].Value)
+	assertValueEquals(t, false, list[0].IsStale)
+}
+
+func TestGroupMinUpdateInterval(t *testing.T) {
+	clock := &fakeClock{now: kUsualTimeStamp}
+	updates := 0
+	r := newRegion(func() time.Time {
+		updates++
+		return clock.Now().Add(-time.Second)
+	})
+	r.now = clock.Now
+	(*Group)(r).SetMinUpdateInterval(time.Minute)
+	assertValueEquals(t, kUsualTimeStamp.Add(-time.Second), r.RLock())
+	r.RUnlock()
+	clock.Advance(59 * time.Second)
+	// The timestamp is still when the update function produced the data.
+	assertValueEquals(t, kUsualTimeStamp.Add(-time.Second), r.RLock())
+	r.RUnlock()
+	assertValueEquals(t, 1, updates)
+	clock.Advance(time.Second)
+	assertValueEquals(t, kUsualTimeStamp.Add(59*time.Second), r.RLock())
+	r.RUnlock()
+	assertValueEquals(t, 2, updates)
+}
+
+func TestGroupUpdateInBackground(t *testing.T) {
+	var updates int64
+	group := NewGroup()
+	group.RegisterUpdateFunc(func() time.Time {
+		atomic.AddInt64(&updates, 1)
+		return kUsualTimeStamp
+	})
+	r := (*region)(group)
+	group.UpdateInBackground(time.Hour)
+	// The first update happens right away.
+	assertValueEquals(t, int64(1), atomic.LoadInt64(&updates))
+	assertValueEquals(t, kUsualTimeStamp, r.RLock())
+	r.RUnlock()
+	assertValueEquals(t, int64(1), atomic.LoadInt64(&updates))
+	group.UpdateInBackground(time.Millisecond)
+	for i := 0; i < 100 && atomic.LoadInt64(&updates) < 4; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt64(&updates) < 4 {
+		t.Error("Expected update function to run in background")
+	}
+	// Going back to updating on request
+	group.UpdateInBackground(0)
+	before := atomic.LoadInt64(&updates)
+	r.RLock()
+	r.RUnlock()
+	after := atomic.LoadInt64(&updates)
+	if after <= before {
+		t.Error("Expected reader to call update function")
+	}
+	group.UpdateInBackground(time.Millisecond)
+	group.Close()
+	before = atomic.LoadInt64(&updates)
+	time.Sleep(20 * time.Millisecond)
+	// At most one update in flight when Close was called may finish.
+	if after = atomic.LoadInt64(&updates); after > before+1 {
+		t.Errorf("Expected no background updates after Close, got %d",
+			after-before)
+	}
+}
+
+func TestGroupUpdateInBackgroundPanics(t *testing.T) {
+	group := NewGroup()
+	group.RegisterUpdateFunc(func() time.Time {
+		panic("update failed")
+	})
+	failures := updateFailureCount.Value()
+	// Recovers even though SetRecoverPanics was never called
+	group.UpdateInBackground(time.Hour)
+	defer group.Close()
+	_, isStale := (*region)(group).rLock()
+	(*region)(group).RUnlock()
+	assertValueEquals(t, true, isStale)
+	assertValueEquals(t, failures+1, updateFailureCount.Value())
+}
+
+func TestGroupUpdateNeverOverlaps(t *testing.T) {
+	var running, maxRunning int32
+	release := make(chan struct{})
+	group := NewGroup()
+	group.RegisterUpdateFunc(func() time.Time {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		if n > atomic.LoadInt32(&maxRunning) {
+			atomic.StoreInt32(&maxRunning, n)
+		}
+		<-release
+		return kUsualTimeStamp
+	})
+	r := (*region)(group)
+	go r.backgroundUpdate()
+	for atomic.LoadInt32(&running) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// A reader calls the update function while the background update
+	// is still running.
+	done := make(chan struct{})
+	go func() {
+		r.RLock()
+		r.RUnlock()
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	<-done
+	assertValueEquals(t, int32(1), atomic.LoadInt32(&maxRunning))
+}
+
+func TestIdsUnique(t *testing.T) {
+	var lock sync.Mutex
+	ids := make(map[int]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				id := nextId()
+				lock.Lock()
+				if ids[id] {
+					t.Errorf("Duplicate id %d", id)
+				}
+				ids[id] = true
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func updateNow() time.Time {
+	return time.Now()
+}
+
+func benchmarkRegionLock(b *testing.B, r regionLocker) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.RLock()
+			r.RUnlock()
+		}
+	})
+}
+
+func BenchmarkRegionLock(b *testing.B) {
+	benchmarkRegionLock(b, newRegion(updateNow))
+}
+
+func BenchmarkChannelRegionLock(b *testing.B) {
+	r := newChannelRegion(updateNow)
+	defer r.Close()
+	benchmarkRegionLock(b, r)
+}
+
+func BenchmarkNewRegion(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		newRegion(updateNow)
+	}
+}
+
+func BenchmarkNewChannelRegion(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		newChannelRegion(updateNow).Close()
+	}
+}
+
+// benchmarkCollection simulates collecting the metrics of many groups
+// from several concurrent clients the way session does.
+func benchmarkCollection(b *testing.B, regions []regionLocker) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for _, r := range regions {
+				r.RLock()
+			}
+			for _, r := range regions {
+				r.RUnlock()
+			}
+		}
+	})
+}
+
+func BenchmarkCollection(b *testing.B) {
+	regions := make([]regionLocker, 100)
+	for i := range regions {
+		regions[i] = newRegion(updateNow)
+	}
+	benchmarkCollection(b, regions)
+}
+
+func BenchmarkChannelCollection(b *testing.B) {
+	regions := make([]regionLocker, 100)
+	for i := range regions {
+		r := newChannelRegion(updateNow)
+		defer r.Close()
+		regions[i] = r
+	}
+	benchmarkCollection(b, regions)
+}
+
+func BenchmarkReadMyMetrics(b *testing.B) {
+	reg := NewRegistry()
+	for i := 0; i < 100; i++ {
+		group := NewGroup()
+		group.RegisterUpdateFunc(updateNow)
+		value := int64(i)
+		if err := reg.RegisterMetricInGroup(
+			fmt.Sprintf("/group%d/value", i),
+			&value,
+			group,
+			units.None,
+			"value"); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			reg.ReadMyMetrics("/")
+		}
+	})
+}
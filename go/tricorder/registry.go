@@ -0,0 +1,88 @@
+package tricorder
+
+import (
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"net/http"
+	"net/rpc"
+	"sync"
+)
+
+var (
+	defaultRegistry = newRegistry()
+)
+
+// registry represents a tree of metrics along with the http handlers
+// and go rpc receiver that serve it. Same as Registry.
+type registry struct {
+	// The top level directory. Immutable.
+	root *directory
+	// Serves the web UI and REST API for root. Immutable.
+	mux *http.ServeMux
+	// Serializes queries for metrics changed since a token
+	changeLock sync.Mutex
+	// Protects history
+	historyLock sync.Mutex
+	// The history of the metrics or nil if none is recorded
+	history *history
+}
+
+func newRegistry() *registry {
+	result := &registry{root: newDirectory(), mux: http.NewServeMux()}
+	result.root.restorer = newRestorer()
+	result.registerHtmlHandlers(result.mux)
+	result.registerJsonHandlers(result.mux)
+	return result
+}
+
+func (r *registry) registerMetric(
+	path string,
+	metric interface{},
+	g *Group,
+	unit units.Unit,
+	description string) error {
+	return r.root.registerMetric(
+		newPathSpec(path), metric, (*region)(g), unit, description)
+}
+
+func (r *registry) registerDirectory(path string) (*directory, error) {
+	return r.root.registerDirectory(newPathSpec(path))
+}
+
+func (r *registry) getDirectory(path string) (*directory, error) {
+	return r.root.getDirectoryAndError(newPathSpec(path))
+}
+
+func (r *registry) unregisterPath(path string) {
+	r.root.unregisterPath(newPathSpec(path))
+}
+
+func (r *registry) readMyMetrics(path string) (result messages.MetricList) {
+	// Always returns nil error since rpcMetricsCollector.Collect
+	// always returns nil
+	r.root.GetAllMetricsByPath(
+		path, (*rpcMetricsCollector)(&result), nil)
+	return
+}
+
+func (r *registry) selectMyMetrics(sel *selector) (
+	result messages.MetricList) {
+	// Always returns nil error since rpcMetricsCollector.Collect
+	// always returns nil
+	r.root.GetAllMetricsBySelector(
+		sel, (*rpcMetricsCollector)(&result), nil)
+	return
+}
+
+func (r *registry) selectMyMetricsByString(selector string) (
+	messages.MetricList, error) {
+	sel, err := newSelector("/", selector)
+	if err != nil {
+		return nil, err
+	}
+	return r.selectMyMetrics(sel), nil
+}
+
+func (r *registry) registerRpc(server *rpc.Server) error {
+	return server.RegisterName("MetricsServer", (*rpcType)(r))
+}
@@ -0,0 +1,172 @@
+package tricorder
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"testing"
+)
+
+func TestRegistriesIsolated(t *testing.T) {
+	first := NewRegistry()
+	second := NewRegistry()
+	var firstValue, secondValue int64 = 3, 5
+	if err := first.RegisterMetric(
+		"/a/value", &firstValue, units.None, "first"); err != nil {
+		t.Fatalf("Got error %v registering metric", err)
+	}
+	if err := second.RegisterMetric(
+		"/a/value", &secondValue, units.None, "second"); err != nil {
+		t.Fatalf("Got error %v registering metric", err)
+	}
+	if err := first.RegisterMetric(
+		"/a/value", &secondValue, units.None, "again"); err != ErrPathInUse {
+		t.Errorf("Expected ErrPathInUse, got %v", err)
+	}
+	if root.GetMetric("/a/value") != nil {
+		t.Error("Metric should not be in default registry")
+	}
+	firstList := first.ReadMyMetrics("/a")
+	if assertValueEquals(t, 1, len(firstList)) {
+		assertValueEquals(t, "/a/value", firstList[0].Path)
+		assertValueEquals(t, int64(3), firstList[0].Value)
+	}
+	dir, err := second.GetDirectory("/a")
+	if err != nil {
+		t.Fatalf("Got error %v getting directory", err)
+	}
+	assertValueEquals(t, "/a", dir.AbsPath())
+	dir.UnregisterDirectory()
+	assertValueEquals(t, 0, len(second.ReadMyMetrics("/")))
+	assertValueEquals(t, 1, len(first.ReadMyMetrics("/")))
+	if _, err := second.GetDirectory("/a"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRegistryServeHTTP(t *testing.T) {
+	reg := NewRegistry()
+	value := 7.5
+	reg.RegisterMetric("/some/value", &value, units.None, "A value")
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metricsapi/some")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var list messages.MetricList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if assertValueEquals(t, 1, len(list)) {
+		assertValueEquals(t, "/some/value", list[0].Path)
+		assertValueEquals(t, 7.5, list[0].Value)
+	}
+	notFound, err := http.Get(server.URL + "/debug/rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notFound.Body.Close()
+	assertValueEquals(t, http.StatusNotFound, notFound.StatusCode)
+}
+
+func TestRegistryRegisterRpc(t *testing.T) {
+	reg := NewRegistry()
+	value := uint32(11)
+	reg.RegisterMetric("/some/value", &value, units.None, "A value")
+	server := rpc.NewServer()
+	if err := reg.RegisterRpc(server); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewRegistry().RegisterRpc(server); err == nil {
+		t.Error("Expected error registering second MetricsServer")
+	}
+	serverConn, clientConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	client := rpc.NewClient(clientConn)
+	defer client.Close()
+	var single messages.Metric
+	if err := client.Call(
+		"MetricsServer.GetMetric", "/some/value", &single); err != nil {
+		t.Fatal(err)
+	}
+	assertValueEquals(t, uint32(11), single.Value)
+	err := client.Call("MetricsServer.GetMetric", "/proc/args", &single)
+	if err == nil || err.Error() != messages.ErrMetricNotFound.Error() {
+		t.Errorf("Expected ErrMetricNotFound, got %v", err)
+	}
+}
+
+func TestCallbackReturningError(t *testing.T) {
+	reg := NewRegistry()
+	var failure error
+	callback := func() (int64, error) {
+		return 42, failure
+	}
+	if err := reg.RegisterMetric(
+		"/callback", callback, units.None, "A callback"); err != nil {
+		t.Fatalf("Got error %v registering metric", err)
+	}
+	list := reg.ReadMyMetrics("/callback")
+	if assertValueEquals(t, 1, len(list)) {
+		assertValueEquals(t, int64(42), list[0].Value)
+		assertValueEquals(t, "", list[0].Err)
+	}
+	before := callbackErrorCount.Value()
+	failure = errors.New("sensor offline")
+	list = reg.ReadMyMetrics("/callback")
+	if assertValueEquals(t, 1, len(list)) {
+		assertValueEquals(t, nil, list[0].Value)
+		assertValueEquals(t, "sensor offline", list[0].Err)
+		assertValueEquals(t, "int64", string(list[0].Kind))
+	}
+	assertValueEquals(
+		t, before+1, callbackErrorCount.Value())
+
+	// JSON conversion keeps the error and leaves value empty
+	list[0].ConvertToJson()
+	assertValueEquals(t, nil, list[0].Value)
+	assertValueEquals(t, "sensor offline", list[0].Err)
+
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metrics/callback?format=text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValueEquals(t, "*Error: sensor offline*\n", string(body))
+}
+
+func TestRegisterBadCallback(t *testing.T) {
+	reg := NewRegistry()
+	badCallbacks := []interface{}{
+		func() (int64, string) { return 0, "" },
+		func() (int64, int64, error) { return 0, 0, nil },
+	}
+	for _, callback := range badCallbacks {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected panic registering %T", callback)
+				}
+			}()
+			reg.RegisterMetric("/bad", callback, units.None, "bad")
+		}()
+	}
+	if err := reg.RegisterMetric(
+		"/bad", func() error { return nil }, units.None, "bad"); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+}
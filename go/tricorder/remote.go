@@ -0,0 +1,437 @@
+package tricorder
+
+import (
+	"context"
+	"errors"
+	"github.com/Symantec/tricorder/go/tricorder/client"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// The name of the metric in a mounted directory that reports
+	// whether fetching the remote metrics worked.
+	kRemoteStatusName = "_status"
+	// The defaults for RemoteConfig
+	kRemoteRefreshInterval = time.Second
+	kRemoteFetchTimeout    = 2 * time.Second
+	kRemoteMaxWait         = 200 * time.Millisecond
+	kRemoteStatusOk        = "ok"
+)
+
+// remoteMount mirrors the metrics of a remote process in the directory
+// it is mounted on.
+type remoteMount struct {
+	address string
+	client  *client.Client
+	// Queries within this much time of the last fetch don't start a new
+	// fetch.
+	refreshInterval time.Duration
+	// How long a fetch may take. Immutable.
+	timeout time.Duration
+	// How long after a fetch starts queries stop waiting for it.
+	// Immutable.
+	maxWait time.Duration
+	// "ok" or why the last fetch failed. Stores a string.
+	status atomic.Value
+	// Tracks the fetch in progress if any.
+	fetches sync.WaitGroup
+	// Protects everything below it. Never held while fetching so that
+	// queries don't wait on the remote process longer than maxWait.
+	lock      sync.Mutex
+	lastFetch time.Time
+	// Closed when the fetch in progress finishes. nil if not fetching.
+	fetchDone chan struct{}
+	// The mirrored metrics by their remote path
+	metrics map[string]*remoteMetric
+}
+
+// newRemoteMount returns a remoteMount for the process at address.
+// address is host:port to use go rpc or http://host:port to use the
+// REST API. A nil config means the default configuration.
+func newRemoteMount(address string, config *RemoteConfig) (
+	*remoteMount, error) {
+	if config == nil {
+		config = &RemoteConfig{}
+	}
+	result := &remoteMount{
+		address:         address,
+		refreshInterval: config.RefreshInterval,
+		timeout:         config.Timeout,
+		maxWait:         config.MaxWait,
+		metrics:         make(map[string]*remoteMetric),
+	}
+	if result.refreshInterval == 0 {
+		result.refreshInterval = kRemoteRefreshInterval
+	}
+	if result.timeout == 0 {
+		result.timeout = kRemoteFetchTimeout
+	}
+	if result.maxWait == 0 {
+		result.maxWait = kRemoteMaxWait
+	}
+	clientConfig := &client.Config{Timeout: result.timeout}
+	hostPort := address
+	if strings.HasPrefix(address, "http://") {
+		clientConfig.Transport = client.REST
+		hostPort = strings.TrimSuffix(
+			strings.TrimPrefix(address, "http://"), "/")
+	}
+	c, err := client.New(hostPort, clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	result.client = c
+	result.status.Store("not fetched yet")
+	return result, nil
+}
+
+// Status returns "ok" or why the last fetch failed.
+func (r *remoteMount) Status() string {
+	return r.status.Load().(string)
+}
+
+// Refresh starts fetching the remote metrics in the background unless r
+// fetched them recently or is already fetching them. If a fetch is in
+// progress, Refresh waits for it until maxWait after it started; if the
+// fetch takes longer, d keeps the metrics of the last fetch until it
+// finishes. d is the directory r is mounted on. Refresh must not look
+// up anything in d since looking up in d calls Refresh.
+func (r *remoteMount) Refresh(d *directory) {
+	done, deadline := r.StartFetch(d)
+	if done == nil {
+		return
+	}
+	wait := deadline.Sub(time.Now())
+	if wait <= 0 {
+		return
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+}
+
+// StartFetch works like Refresh except that it never waits. StartFetch
+// returns a channel that is closed when the fetch in progress finishes
+// along with when queries stop waiting for it. If no fetch is in
+// progress, StartFetch returns a nil channel.
+func (r *remoteMount) StartFetch(d *directory) (
+	done <-chan struct{}, deadline time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := time.Now()
+	if r.fetchDone == nil &&
+		(r.lastFetch.IsZero() || now.Sub(r.lastFetch) >= r.refreshInterval) {
+		r.lastFetch = now
+		r.fetchDone = make(chan struct{})
+		r.fetches.Add(1)
+		go r.fetch(d, r.fetchDone)
+	}
+	if r.fetchDone == nil {
+		return nil, time.Time{}
+	}
+	return r.fetchDone, r.lastFetch.Add(r.maxWait)
+}
+
+// fetch fetches the remote metrics and then makes the contents of d
+// match them. fetch closes done when it finishes.
+func (r *remoteMount) fetch(d *directory, done chan struct{}) {
+	defer r.fetches.Done()
+	defer close(done)
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	list, err := r.client.List(ctx, "/")
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.fetchDone = nil
+	if err != nil {
+		// Keep the metrics of the last fetch. Their timestamps show
+		// how old they are.
+		r.status.Store(err.Error())
+		return
+	}
+	r.status.Store(kRemoteStatusOk)
+	now := time.Now()
+	seen := make(map[string]bool, len(list))
+	for _, m := range list {
+		path := newPathSpec(m.Path)
+		if path.Empty() {
+			continue
+		}
+		seen[m.Path] = true
+		if rm := r.metrics[m.Path]; rm != nil && rm.Matches(m) {
+			rm.Set(m, now)
+			continue
+		}
+		r.remove(d, m.Path)
+		rm, err := newRemoteMetric(m, now)
+		if err != nil {
+			continue
+		}
+		dir, err := d.registerDirectory(path.Dir())
+		if err != nil {
+			continue
+		}
+		if dir.storeMetric(path.Base(), rm.metric) != nil {
+			continue
+		}
+		r.metrics[m.Path] = rm
+	}
+	for path := range r.metrics {
+		if !seen[path] {
+			r.remove(d, path)
+		}
+	}
+}
+
+// remove removes the mirrored metric with the given remote path from d
+// along with any directories that it leaves empty.
+func (r *remoteMount) remove(d *directory, path string) {
+	rm := r.metrics[path]
+	if rm == nil {
+		return
+	}
+	delete(r.metrics, path)
+	entry := rm.metric.enclosingListEntry
+	dir := entry.parentDir()
+	dir.removeListEntry(entry.Name)
+	for dir != d && dir.isEmpty() {
+		parent := dir.Parent()
+		dir.unregisterDirectory()
+		dir = parent
+	}
+}
+
+// remoteKey is what must stay the same for a mirrored metric to reuse
+// the same local metric.
+type remoteKey struct {
+	Kind        types.Type
+	SubType     types.Type
+	Unit        units.Unit
+	Description string
+	IsMonotonic bool
+}
+
+func newRemoteKey(m *messages.Metric) remoteKey {
+	return remoteKey{
+		Kind:        m.Kind,
+		SubType:     m.SubType,
+		Unit:        m.Unit,
+		Description: m.Description,
+		IsMonotonic: m.IsMonotonic,
+	}
+}
+
+// remoteMetric is a local metric that mirrors a remote one. The local
+// metric persists across fetches so that queries with a since token see
+// only real changes.
+type remoteMetric struct {
+	metric *metric
+	key    remoteKey
+	// Exactly one of these is non-nil
+	scalar *remoteScalar
+	list   *listType
+	dist   *remoteDistribution
+}
+
+func newRemoteMetric(m *messages.Metric, fetchTime time.Time) (
+	*remoteMetric, error) {
+	result := &remoteMetric{key: newRemoteKey(m)}
+	var v *value
+	switch m.Kind {
+	case types.Dist:
+		result.dist = &remoteDistribution{groupId: nextId(), unit: m.Unit}
+		v = &value{dist: result.dist, unit: m.Unit, valType: types.Dist}
+	case types.List:
+		slice, err := m.SubType.SafeNilSlice()
+		if err != nil {
+			return nil, err
+		}
+		if reflect.TypeOf(m.Value) == reflect.TypeOf(slice) {
+			slice = m.Value
+		}
+		result.list = newListWithTimeStamp(
+			slice, ImmutableSlice, remoteTimeStamp(m, fetchTime))
+		v = &value{alist: result.list, unit: m.Unit, valType: types.List}
+	default:
+		zero, err := m.Kind.SafeZeroValue()
+		if err != nil {
+			return nil, err
+		}
+		result.scalar = &remoteScalar{zero: reflect.ValueOf(zero)}
+		v = result.scalar.Value(m.Unit, m.IsMonotonic)
+	}
+	result.metric = &metric{Description: m.Description, value: v}
+	result.Set(m, fetchTime)
+	return result, nil
+}
+
+// Matches returns true if this instance can mirror m.
+func (r *remoteMetric) Matches(m *messages.Metric) bool {
+	return r.key == newRemoteKey(m)
+}
+
+// Set sets the mirrored value to that of m, in go rpc form.
+func (r *remoteMetric) Set(m *messages.Metric, fetchTime time.Time) {
+	ts := remoteTimeStamp(m, fetchTime)
+	switch {
+	case r.dist != nil:
+		dist, _ := m.Value.(*messages.Distribution)
+		r.dist.Set(dist, ts)
+	case r.list != nil:
+		if slice, _ := r.list.AsSlice(); reflect.TypeOf(m.Value) ==
+			reflect.TypeOf(slice) {
+			r.list.ChangeWithTimeStamp(m.Value, ImmutableSlice, ts)
+		}
+	default:
+		r.scalar.Set(m, ts)
+	}
+}
+
+// remoteTimeStamp returns the timestamp of m or fetchTime if m has none.
+func remoteTimeStamp(m *messages.Metric, fetchTime time.Time) time.Time {
+	if ts, ok := m.TimeStamp.(time.Time); ok && !ts.IsZero() {
+		return ts
+	}
+	return fetchTime
+}
+
+// remoteScalar holds the value of a mirrored metric that is neither a
+// list nor a distribution.
+type remoteScalar struct {
+	// The zero value of the metric's type. Immutable.
+	zero      reflect.Value
+	lock      sync.Mutex
+	value     reflect.Value
+	err       error
+	timeStamp time.Time
+}
+
+// Set sets the value to that of m and the timestamp to ts.
+func (s *remoteScalar) Set(m *messages.Metric, ts time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.value, s.err = s.zero, nil
+	if m.Err != "" {
+		s.err = errors.New(m.Err)
+	} else if v := reflect.ValueOf(m.Value); v.IsValid() &&
+		v.Type().ConvertibleTo(s.zero.Type()) {
+		s.value = v.Convert(s.zero.Type())
+	}
+	s.timeStamp = ts
+}
+
+// Get returns the value and the error the remote callback returned as a
+// callback of type func() (T, error) does.
+func (s *remoteScalar) Get() []reflect.Value {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := reflect.New(errorType).Elem()
+	if s.err != nil {
+		err.Set(reflect.ValueOf(s.err))
+	}
+	return []reflect.Value{s.value, err}
+}
+
+func (s *remoteScalar) TimeStamp() time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.timeStamp
+}
+
+// Value returns a value that reads this instance like a callback so
+// that errors from the remote callback come through.
+func (s *remoteScalar) Value(unit units.Unit, isMonotonic bool) *value {
+	valType, _ := mustGetPrimitiveType(s.zero.Type())
+	funcType := reflect.FuncOf(
+		nil, []reflect.Type{s.zero.Type(), errorType}, false)
+	return &value{
+		val: reflect.MakeFunc(
+			funcType,
+			func([]reflect.Value) []reflect.Value { return s.Get() }),
+		region:       newRegion(s.TimeStamp),
+		unit:         unit,
+		valType:      valType,
+		isfunc:       true,
+		returnsError: true,
+		isMonotonic:  isMonotonic,
+	}
+}
+
+// remoteDistribution holds the value of a mirrored distribution.
+type remoteDistribution struct {
+	groupId   int
+	unit      units.Unit
+	lock      sync.Mutex
+	dist      *messages.Distribution
+	timeStamp time.Time
+}
+
+func (d *remoteDistribution) Set(dist *messages.Distribution, ts time.Time) {
+	if dist == nil {
+		dist = &messages.Distribution{}
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.dist = dist
+	d.timeStamp = ts
+}
+
+func (d *remoteDistribution) SetUnit(unit units.Unit) bool {
+	return unit == d.unit
+}
+
+func (d *remoteDistribution) GroupId() int {
+	return d.groupId
+}
+
+func (d *remoteDistribution) IsNotCumulative() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.dist.IsNotCumulative
+}
+
+func (d *remoteDistribution) Snapshot() *snapshot {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	dist := d.dist
+	result := &snapshot{
+		Min:             dist.Min,
+		Max:             dist.Max,
+		Average:         dist.Average,
+		Median:          dist.Median,
+		Sum:             dist.Sum,
+		Count:           dist.Count,
+		Generation:      dist.Generation,
+		IsNotCumulative: dist.IsNotCumulative,
+		TimeStamp:       d.timeStamp,
+		Window:          time.Duration(dist.Window * float64(time.Second)),
+		Sketch:          dist.Sketch,
+	}
+	for i, r := range dist.Ranges {
+		result.Breakdown = append(result.Breakdown, breakdownPiece{
+			bucketPiece: &bucketPiece{
+				Start: r.Lower,
+				End:   r.Upper,
+				First: i == 0,
+				Last:  i == len(dist.Ranges)-1,
+			},
+			Count: r.Count,
+		})
+	}
+	for _, q := range dist.Quantiles {
+		result.Quantiles = append(
+			result.Quantiles,
+			quantileValue{Quantile: q.Quantile, Value: q.Value})
+	}
+	return result
+}
@@ -0,0 +1,288 @@
+package tricorder
+
+import (
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newRemoteServer serves reg over both go rpc and the REST API.
+func newRemoteServer(t *testing.T, reg *Registry) *httptest.Server {
+	rpcServer := rpc.NewServer()
+	if err := reg.RegisterRpc(rpcServer); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, rpcServer)
+	mux.Handle("/", reg)
+	return httptest.NewServer(mux)
+}
+
+// mountRemote mounts address at path in reg and waits for the first
+// fetch. Afterwards, queries don't fetch; call fetchRemote instead.
+func mountRemote(t *testing.T, reg *Registry, path, address string) {
+	dir, err := reg.RegisterDirectory(pathDir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dir.MountRemote(
+		path[strings.LastIndex(path, "/")+1:], address, nil); err != nil {
+		t.Fatal(err)
+	}
+	remote := (*registry)(reg).root.GetDirectory(path).remote
+	remote.fetches.Wait()
+	remote.lock.Lock()
+	remote.refreshInterval = time.Hour
+	remote.lock.Unlock()
+}
+
+// fetchRemote fetches the metrics of the remote process mounted at path
+// in reg and waits for the fetch to finish.
+func fetchRemote(reg *Registry, path string) {
+	mount := (*registry)(reg).root.GetDirectory(path)
+	mount.remote.fetches.Wait()
+	mount.remote.lock.Lock()
+	mount.remote.lastFetch = time.Time{}
+	mount.remote.lock.Unlock()
+	mount.remote.Refresh(mount)
+	mount.remote.fetches.Wait()
+}
+
+func pathDir(path string) string {
+	return path[:strings.LastIndex(path, "/")]
+}
+
+func TestMountRemote(t *testing.T) {
+	sidecar := NewRegistry()
+	var count Counter
+	count.Add(3)
+	name := "sidecar"
+	latency := NewArbitraryBucketer(10.0).NewCumulativeDistribution()
+	sidecar.RegisterMetric("/a/count", &count, units.None, "A count")
+	sidecar.RegisterMetric("/a/name", &name, units.None, "A name")
+	sidecar.RegisterMetric("/b/latency", latency, units.Millisecond, "Latency")
+	latency.Add(5.0)
+	latency.Add(15.0)
+	sidecarServer := newRemoteServer(t, sidecar)
+	defer sidecarServer.Close()
+	address := sidecarServer.Listener.Addr().String()
+
+	reg := NewRegistry()
+	reg.RegisterMetric("/local", new(int64), units.None, "local")
+	mountRemote(t, reg, "/sidecars/rpc", address)
+	mountRemote(t, reg, "/sidecars/rest", "http://"+address)
+	server := newRemoteServer(t, reg)
+	defer server.Close()
+
+	metrics, err := reg.SelectMyMetrics("/**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPath := metricsByPath(metrics)
+	for _, transport := range []string{"rpc", "rest"} {
+		base := "/sidecars/" + transport
+		if m := byPath[base+"/a/count"]; assertValueEquals(t, true, m != nil) {
+			assertValueEquals(t, uint64(3), m.Value)
+			assertValueEquals(t, "A count", m.Description)
+			assertValueEquals(t, true, m.IsMonotonic)
+		}
+		if m := byPath[base+"/b/latency"]; assertValueEquals(t, true, m != nil) {
+			dist := m.Value.(*messages.Distribution)
+			assertValueEquals(t, uint64(2), dist.Count)
+			assertValueEquals(t, 2, len(dist.Ranges))
+			assertValueEquals(t, units.Millisecond, m.Unit)
+		}
+		if m := byPath[base+"/_status"]; assertValueEquals(t, true, m != nil) {
+			assertValueEquals(t, "ok", m.Value)
+		}
+	}
+
+	// Remote metrics show up in the HTML, JSON and RPC APIs.
+	body := getBody(t, server.URL+"/metrics/sidecars/rpc/a")
+	if !strings.Contains(body, "sidecar") {
+		t.Errorf("Expected remote value in %s", body)
+	}
+	body = getBody(
+		t, server.URL+"/metricsapi/sidecars/rest/a/name?singleton=true")
+	if !strings.Contains(body, `"path": "/sidecars/rest/a/name"`) {
+		t.Errorf("Expected rewritten path in %s", body)
+	}
+	rpcServer := rpc.NewServer()
+	reg.RegisterRpc(rpcServer)
+	serverConn, clientConn := net.Pipe()
+	go rpcServer.ServeConn(serverConn)
+	client := rpc.NewClient(clientConn)
+	defer client.Close()
+	var m messages.Metric
+	if err := client.Call(
+		"MetricsServer.GetMetric", "/sidecars/rpc/a/name", &m); err != nil {
+		t.Fatal(err)
+	}
+	assertValueEquals(t, "sidecar", m.Value)
+
+	// Metadata listings show what the last fetch found without fetching.
+	remote := (*registry)(reg).root.GetDirectory("/sidecars/rpc").remote
+	remote.lock.Lock()
+	remote.refreshInterval = 0
+	lastFetch := remote.lastFetch
+	remote.lock.Unlock()
+	metadata := (*registry)(reg).listMetadata(
+		&messages.MetadataRequest{Path: "/sidecars/rpc/a", NamesOnly: true})
+	assertValueEquals(t, 2, len(metadata))
+	remote.lock.Lock()
+	assertValueEquals(t, lastFetch, remote.lastFetch)
+	remote.refreshInterval = time.Hour
+	remote.lock.Unlock()
+
+	// Only real changes count as changes.
+	changed, err := (*registry)(reg).changedSince(
+		pathSelector("/sidecars"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	count.Inc()
+	fetchRemote(reg, "/sidecars/rpc")
+	fetchRemote(reg, "/sidecars/rest")
+	changed, err = (*registry)(reg).changedSince(
+		pathSelector("/sidecars"), changed.Token)
+	if assertValueEquals(t, nil, err) {
+		assertValueDeepEquals(
+			t,
+			[]string{"/sidecars/rest/a/count", "/sidecars/rpc/a/count"},
+			paths(changed.Metrics))
+	}
+
+	// Metrics that go away remotely go away locally.
+	sidecar.UnregisterPath("/a/name")
+	fetchRemote(reg, "/sidecars/rpc")
+	var names []string
+	(*registry)(reg).root.GetDirectory("/sidecars/rpc").GetAllMetrics(
+		collectorFunc(func(m *metric) {
+			names = append(names, m.AbsPath())
+		}), nil)
+	assertValueDeepEquals(
+		t,
+		[]string{
+			"/sidecars/rpc/_status",
+			"/sidecars/rpc/a/count",
+			"/sidecars/rpc/b/latency",
+		},
+		names)
+	sidecar.UnregisterPath("/b/latency")
+	fetchRemote(reg, "/sidecars/rpc")
+	// Directories left empty go away too.
+	assertValueEquals(
+		t,
+		(*directory)(nil),
+		(*registry)(reg).root.GetDirectory("/sidecars/rpc/b"))
+}
+
+// collectorFunc collects metrics by calling itself.
+type collectorFunc func(m *metric)
+
+func (f collectorFunc) Collect(m *metric, s *session) error {
+	f(m)
+	return nil
+}
+
+func TestMountRemoteFailure(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	address := dead.Listener.Addr().String()
+	dead.Close()
+	reg := NewRegistry()
+	reg.RegisterMetric("/local", new(int64), units.None, "local")
+	mountRemote(t, reg, "/sidecar", address)
+	metrics, err := reg.SelectMyMetrics("/**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The listing still works and reports why the fetch failed.
+	assertValueDeepEquals(
+		t, []string{"/local", "/sidecar/_status"}, paths(metrics))
+	status := metrics[1].Value.(string)
+	if status == kRemoteStatusOk || status == "" {
+		t.Errorf("Expected error status, got %q", status)
+	}
+	dir, _ := reg.RegisterDirectory("/")
+	assertValueEquals(
+		t, ErrPathInUse, dir.MountRemote("sidecar", address, nil))
+
+	// A failed fetch keeps the metrics of the last fetch that worked.
+	sidecar := NewRegistry()
+	sidecar.RegisterMetric("/count", new(int64), units.None, "A count")
+	sidecarServer := newRemoteServer(t, sidecar)
+	address = "http://" + sidecarServer.Listener.Addr().String()
+	mountRemote(t, reg, "/rest", address)
+	sidecarServer.Close()
+	fetchRemote(reg, "/rest")
+	metrics, err = reg.SelectMyMetrics("/rest/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValueDeepEquals(
+		t, []string{"/rest/_status", "/rest/count"}, paths(metrics))
+	status = metrics[0].Value.(string)
+	if status == kRemoteStatusOk || status == "" {
+		t.Errorf("Expected error status, got %q", status)
+	}
+}
+
+func TestMountRemoteFirstQuery(t *testing.T) {
+	sidecar := NewRegistry()
+	sidecar.RegisterMetric("/count", new(int64), units.None, "A count")
+	sidecarServer := newRemoteServer(t, sidecar)
+	defer sidecarServer.Close()
+	reg := NewRegistry()
+	dir, _ := reg.RegisterDirectory("/")
+	if err := dir.MountRemote(
+		"sidecar",
+		sidecarServer.Listener.Addr().String(),
+		&RemoteConfig{MaxWait: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	// The first query waits for the first fetch.
+	metrics, err := reg.SelectMyMetrics("/**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValueDeepEquals(
+		t,
+		[]string{"/sidecar/_status", "/sidecar/count"},
+		paths(metrics))
+}
+
+func TestMountRemoteSlow(t *testing.T) {
+	unblock := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			<-unblock
+		}))
+	defer slow.Close()
+	defer close(unblock)
+	reg := NewRegistry()
+	dir, _ := reg.RegisterDirectory("/")
+	if err := dir.MountRemote(
+		"sidecar",
+		"http://"+slow.Listener.Addr().String(),
+		&RemoteConfig{MaxWait: 10 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	// Queries wait for the fetch in progress only until MaxWait.
+	start := time.Now()
+	metrics, err := reg.SelectMyMetrics("/**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > kRemoteFetchTimeout/2 {
+		t.Errorf("Took too long: %v", elapsed)
+	}
+	if assertValueEquals(t, 1, len(metrics)) {
+		assertValueEquals(t, "not fetched yet", metrics[0].Value)
+	}
+}
@@ -18,15 +18,62 @@ func (c *rpcMetricsCollector) Collect(m *metric, s *session) (err error) {
 	return nil
 }
 
-type rpcType int
+// CollectError collects m like Collect. The collected metric reports
+// the callback error in its Err field.
+func (c *rpcMetricsCollector) CollectError(
+	m *metric, s *session, err error) error {
+	return c.Collect(m, s)
+}
+
+type rpcType registry
 
 func (t *rpcType) ListMetrics(path string, response *messages.MetricList) error {
-	return root.GetAllMetricsByPath(
+	return t.root.GetAllMetricsByPath(
 		path, (*rpcMetricsCollector)(response), nil)
 }
 
+func (t *rpcType) ListMetricsSince(
+	request messages.ChangedSinceRequest,
+	response *messages.ChangedMetrics) error {
+	result, err := (*registry)(t).changedSince(
+		pathSelector(request.Path), request.Since)
+	if err != nil {
+		return err
+	}
+	*response = *result
+	return nil
+}
+
+func (t *rpcType) GetHistory(
+	request messages.HistoryRequest,
+	response *messages.HistoryList) error {
+	h := (*registry)(t).getHistory()
+	if h == nil {
+		return messages.ErrNoHistory
+	}
+	*response = h.Get(request.Path, request.From, request.To, request.Step)
+	return nil
+}
+
+func (t *rpcType) SelectMetrics(
+	selector string, response *messages.MetricList) error {
+	result, err := (*registry)(t).selectMyMetricsByString(selector)
+	if err != nil {
+		return err
+	}
+	*response = result
+	return nil
+}
+
+func (t *rpcType) ListMetadata(
+	request messages.MetadataRequest,
+	response *messages.MetadataList) error {
+	*response = (*registry)(t).listMetadata(&request)
+	return nil
+}
+
 func (t *rpcType) GetMetric(path string, response *messages.Metric) error {
-	m := root.GetMetric(path)
+	m := t.root.GetMetric(path)
 	if m == nil {
 		return messages.ErrMetricNotFound
 	}
@@ -35,5 +82,5 @@ func (t *rpcType) GetMetric(path string, response *messages.Metric) error {
 }
 
 func initRpcHandlers() {
-	rpc.RegisterName("MetricsServer", new(rpcType))
+	rpc.RegisterName("MetricsServer", (*rpcType)(defaultRegistry))
 }
@@ -0,0 +1,163 @@
+package tricorder
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Selectors starting with this are regular expressions.
+	kRegexPrefix = "~"
+	// A glob segment matching zero or more path segments.
+	kAnySegments = "**"
+)
+
+// selector selects metrics by their absolute path. A selector is either
+// a glob such as /proc/*/latency or /**/errors where * matches anything
+// within a path segment and ** matches zero or more whole segments, or
+// a regular expression prefixed with ~ such as ~errors$. Like a plain
+// path, a glob that matches a directory selects every metric under it.
+type selector struct {
+	// Traversal starts here. Every selected metric is at or under base.
+	base pathSpec
+	// Matched against absolute paths. nil selects every metric at or
+	// under base.
+	pattern *regexp.Regexp
+	// For globs only, matches each segment after base. nil entries
+	// stand for **.
+	segments []*regexp.Regexp
+	// If non-empty, selects only metrics whose path or description
+	// contains this ignoring case. Always lower case.
+	search string
+	// If positive, selects only metrics whose absolute path has at most
+	// this many segments.
+	maxSegments int
+}
+
+// pathSelector returns a selector selecting every metric at or under
+// path just like GetAllMetricsByPath.
+func pathSelector(path string) *selector {
+	return &selector{base: newPathSpec(path)}
+}
+
+// newSelector returns the selector for expr relative to path. Regular
+// expressions match the absolute path of each metric under path.
+// newSelector returns an error if expr is a bad regular expression.
+func newSelector(path, expr string) (*selector, error) {
+	if strings.HasPrefix(expr, kRegexPrefix) {
+		pattern, err := regexp.Compile(expr[len(kRegexPrefix):])
+		if err != nil {
+			return nil, err
+		}
+		return &selector{base: newPathSpec(path), pattern: pattern}, nil
+	}
+	segments := newPathSpec(path + "/" + expr)
+	literal := 0
+	for literal < len(segments) && !strings.Contains(segments[literal], "*") {
+		literal++
+	}
+	result := &selector{base: segments[:literal]}
+	if literal == len(segments) {
+		return result, nil
+	}
+	var buffer []string
+	buffer = append(buffer, "^")
+	for _, segment := range segments[:literal] {
+		buffer = append(buffer, "/", regexp.QuoteMeta(segment))
+	}
+	for _, segment := range segments[literal:] {
+		if segment == kAnySegments {
+			buffer = append(buffer, "(/[^/]+)*")
+			result.segments = append(result.segments, nil)
+			continue
+		}
+		parts := strings.Split(segment, "*")
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		segmentExpr := strings.Join(parts, "[^/]*")
+		buffer = append(buffer, "/", segmentExpr)
+		result.segments = append(
+			result.segments, regexp.MustCompile("^"+segmentExpr+"$"))
+	}
+	// A selected directory selects everything under it.
+	buffer = append(buffer, "(/.*)?$")
+	result.pattern = regexp.MustCompile(strings.Join(buffer, ""))
+	return result, nil
+}
+
+// WithSearch returns a selector that selects only the metrics that sel
+// selects whose path or description contains query ignoring case.
+func (sel *selector) WithSearch(query string) *selector {
+	result := *sel
+	result.search = strings.ToLower(query)
+	return &result
+}
+
+// WithMaxDepth returns a selector that selects only the metrics that sel
+// selects that are at most depth levels under path. A depth of 1 means
+// only metrics directly under path. A zero or negative depth means no
+// limit.
+func (sel *selector) WithMaxDepth(path string, depth int) *selector {
+	result := *sel
+	result.maxSegments = 0
+	if depth > 0 {
+		result.maxSegments = len(newPathSpec(path)) + depth
+	}
+	return &result
+}
+
+// IsPath returns true if sel selects every metric at or under its base
+// just like GetAllMetricsByPath.
+func (sel *selector) IsPath() bool {
+	return sel.pattern == nil && sel.search == "" && sel.maxSegments <= 0
+}
+
+// Matches returns true if sel selects m. Matches never evaluates m.
+func (sel *selector) Matches(m *metric) bool {
+	if sel.pattern != nil && !sel.pattern.MatchString(m.AbsPath()) {
+		return false
+	}
+	if sel.maxSegments > 0 &&
+		strings.Count(m.AbsPath(), "/") > sel.maxSegments {
+		return false
+	}
+	return sel.search == "" ||
+		strings.Contains(strings.ToLower(m.AbsPath()), sel.search) ||
+		strings.Contains(strings.ToLower(m.Description), sel.search)
+}
+
+// MayMatchUnder returns false if sel selects nothing at or under the
+// directory at path, a path relative to base.
+func (sel *selector) MayMatchUnder(path pathSpec) bool {
+	if sel.maxSegments > 0 && len(sel.base)+len(path) >= sel.maxSegments {
+		return false
+	}
+	return globMayMatchUnder(sel.segments, path)
+}
+
+// globMayMatchUnder returns false if no path at or under path matches
+// the glob segments. Since a matched directory selects everything under
+// it, running out of segments is a match.
+func globMayMatchUnder(segments []*regexp.Regexp, path pathSpec) bool {
+	switch {
+	case len(path) == 0 || len(segments) == 0:
+		return true
+	case segments[0] == nil:
+		return globMayMatchUnder(segments[1:], path) ||
+			globMayMatchUnder(segments, path[1:])
+	case segments[0].MatchString(path[0]):
+		return globMayMatchUnder(segments[1:], path[1:])
+	default:
+		return false
+	}
+}
+
+// requestSelector returns the selector for the select parameter of r
+// relative to the path of r. Without a select parameter, the selector
+// selects every metric at or under the path of r. Caller must call
+// r.ParseForm() first.
+func requestSelector(r *http.Request) (*selector, error) {
+	return newSelector(r.URL.Path, r.Form.Get("select"))
+}
@@ -0,0 +1,164 @@
+package tricorder
+
+import (
+	"encoding/json"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"testing"
+)
+
+func newSelectorRegistry() *Registry {
+	reg := NewRegistry()
+	for _, path := range []string{
+		"/proc/a/latency",
+		"/proc/a/errors",
+		"/proc/b/latency",
+		"/proc/b/c/latency",
+		"/net/errors",
+		"/net/reads",
+		"/net/connect-errors",
+	} {
+		reg.RegisterMetric(path, new(int64), units.None, "")
+	}
+	return reg
+}
+
+func TestSelector(t *testing.T) {
+	reg := newSelectorRegistry()
+	testCases := []struct {
+		path     string
+		expr     string
+		expected []string
+	}{
+		{"/proc/b", "", []string{"/proc/b/c/latency", "/proc/b/latency"}},
+		{"/", "/proc/*/latency", []string{"/proc/a/latency", "/proc/b/latency"}},
+		{"/", "/proc/**/latency", []string{
+			"/proc/a/latency", "/proc/b/c/latency", "/proc/b/latency"}},
+		{"/", "/**/*errors", []string{
+			"/net/connect-errors", "/net/errors", "/proc/a/errors"}},
+		// A selected directory selects everything under it.
+		{"/", "/proc/b*", []string{"/proc/b/c/latency", "/proc/b/latency"}},
+		// Relative to path
+		{"/net", "*errors", []string{"/net/connect-errors", "/net/errors"}},
+		{"/", "~-errors$|reads", []string{"/net/connect-errors", "/net/reads"}},
+		// Regular expressions only match metrics under path
+		{"/proc", "~errors$", []string{"/proc/a/errors"}},
+		{"/", "/nothing/*", nil},
+	}
+	for _, tc := range testCases {
+		sel, err := newSelector(tc.path, tc.expr)
+		if err != nil {
+			t.Errorf("%s %s: got error %v", tc.path, tc.expr, err)
+			continue
+		}
+		var actual []string
+		for _, m := range (*registry)(reg).selectMyMetrics(sel) {
+			actual = append(actual, m.Path)
+		}
+		assertValueDeepEquals(t, tc.expected, actual)
+	}
+	if _, err := newSelector("/", "~(unclosed"); err == nil {
+		t.Error("Expected error for bad regular expression")
+	}
+	if _, err := reg.SelectMyMetrics("~[z-a]"); err == nil {
+		t.Error("Expected error for bad regular expression")
+	}
+}
+
+func TestSelectorPrunes(t *testing.T) {
+	reg := newSelectorRegistry()
+	var evaluated []string
+	for _, path := range []string{"/proc/a/cpu", "/net/cpu"} {
+		path := path
+		reg.RegisterMetric(
+			path,
+			func() int64 {
+				evaluated = append(evaluated, path)
+				return 0
+			},
+			units.None,
+			"")
+	}
+	sel, _ := newSelector("/", "/proc/*/latency")
+	assertValueEquals(t, 2, len((*registry)(reg).selectMyMetrics(sel)))
+	// Metrics that don't match never get evaluated
+	assertValueDeepEquals(t, []string(nil), evaluated)
+	// Paths are relative to /proc
+	assertValueEquals(t, true, sel.MayMatchUnder(pathSpec{"a"}))
+	assertValueEquals(t, false, sel.MayMatchUnder(pathSpec{"b", "c"}))
+	sel, _ = newSelector("/", "/*/b")
+	assertValueEquals(t, true, sel.MayMatchUnder(pathSpec{"proc"}))
+	assertValueEquals(t, false, sel.MayMatchUnder(pathSpec{"proc", "a"}))
+	assertValueEquals(t, true, sel.MayMatchUnder(pathSpec{"proc", "b", "c"}))
+	sel, _ = newSelector("/", "/**/cpu")
+	assertValueEquals(t, 2, len((*registry)(reg).selectMyMetrics(sel)))
+	assertValueDeepEquals(t, []string{"/net/cpu", "/proc/a/cpu"}, evaluated)
+	assertValueEquals(t, true, sel.MayMatchUnder(pathSpec{"proc", "b", "c"}))
+	sel = pathSelector("/proc").WithMaxDepth("/proc", 2)
+	assertValueEquals(t, 4, len((*registry)(reg).selectMyMetrics(sel)))
+	assertValueEquals(t, true, sel.MayMatchUnder(pathSpec{"b"}))
+	assertValueEquals(t, false, sel.MayMatchUnder(pathSpec{"b", "c"}))
+}
+
+func TestSelectorAPI(t *testing.T) {
+	reg := newSelectorRegistry()
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metricsapi/proc?select=*/latency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list messages.MetricList
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValueDeepEquals(
+		t, []string{"/proc/a/latency", "/proc/b/latency"}, paths(list))
+	assertValueEquals(
+		t,
+		"/net/connect-errors 0\n/net/errors 0\n/proc/a/errors 0\n",
+		getBody(t, server.URL+"/metrics/?format=text&select=/**/*errors"))
+	resp, err = http.Get(server.URL + "/metricsapi/proc/b?depth=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list = nil
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValueDeepEquals(t, []string{"/proc/b/latency"}, paths(list))
+	resp, err = http.Get(server.URL + "/metricsapi/?select=~(")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assertValueEquals(t, http.StatusBadRequest, resp.StatusCode)
+
+	rpcServer := rpc.NewServer()
+	reg.RegisterRpc(rpcServer)
+	serverConn, clientConn := net.Pipe()
+	go rpcServer.ServeConn(serverConn)
+	client := rpc.NewClient(clientConn)
+	defer client.Close()
+	var rpcList messages.MetricList
+	if err := client.Call(
+		"MetricsServer.SelectMetrics", "/net/*s", &rpcList); err != nil {
+		t.Fatal(err)
+	}
+	assertValueDeepEquals(
+		t,
+		[]string{"/net/connect-errors", "/net/errors", "/net/reads"},
+		paths(rpcList))
+	if err := client.Call(
+		"MetricsServer.SelectMetrics", "~(", &rpcList); err == nil {
+		t.Error("Expected error for bad regular expression")
+	}
+}
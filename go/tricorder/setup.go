@@ -165,6 +165,16 @@ func initDefaultMetrics() {
 		resourceUsageGroup,
 		units.None,
 		"Voluntary context switches")
+	RegisterMetric(
+		"/proc/tricorder/callback-errors",
+		&callbackErrorCount,
+		units.None,
+		"Number of times a metric callback returned an error")
+	RegisterMetric(
+		"/proc/tricorder/update-failures",
+		&updateFailureCount,
+		units.None,
+		"Number of times a group update function timed out or panicked")
 	RegisterMetric("/proc/name", &os.Args[0], units.None, "Program name")
 	RegisterMetric("/proc/args", &programArgs, units.None, "Program args")
 	RegisterMetric("/proc/start-time", &appStartTime, units.None, "Program start time")
@@ -0,0 +1,138 @@
+package tricorder
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errBadToken = errors.New("tricorder: Malformed since token")
+)
+
+var (
+	// The last change sequence number handed out. Accessed atomically.
+	changeSeq uint64
+	// Distinguishes tokens of this process from tokens of earlier
+	// processes. Immutable.
+	changeEpoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+)
+
+// changeToken returns the token representing the current point in the
+// change sequence.
+func changeToken() string {
+	return fmt.Sprintf("%s-%d", changeEpoch, atomic.LoadUint64(&changeSeq))
+}
+
+// parseChangeToken returns the sequence number that token represents.
+// The empty token and tokens from other processes represent 0 so that
+// queries with them return every metric.
+func parseChangeToken(token string) (uint64, error) {
+	if token == "" {
+		return 0, nil
+	}
+	dash := strings.LastIndex(token, "-")
+	if dash == -1 {
+		return 0, errBadToken
+	}
+	seq, err := strconv.ParseUint(token[dash+1:], 10, 64)
+	if err != nil {
+		return 0, errBadToken
+	}
+	if token[:dash] != changeEpoch {
+		return 0, nil
+	}
+	return seq, nil
+}
+
+// changeTracker assigns a change sequence number to a metric each time
+// it observes that the state of the metric changed. The changeLock of the
+// registry containing the metric protects changeTracker instances.
+type changeTracker struct {
+	state interface{}
+	seq   uint64
+}
+
+// Observe records state as the current state of the metric and returns
+// the sequence number of the last change.
+func (t *changeTracker) Observe(state interface{}) uint64 {
+	if t.seq == 0 || !reflect.DeepEqual(state, t.state) {
+		t.state = state
+		t.seq = atomic.AddUint64(&changeSeq, 1)
+	}
+	return t.seq
+}
+
+// changeState returns what determines whether m, in Go RPC form, changed.
+// For distributions, that is the generation; for lists, the timestamp;
+// and for everything else, the value. A metric whose group updated but
+// whose value stayed the same does not count as changed. Since callers
+// compare only with the state the last query observed, a value that
+// changes and then changes back between queries does not count as
+// changed either. Finding changes requires evaluating m; group update
+// times can't stand in for that since groups that update on every
+// request would always look changed.
+func changeState(m *messages.Metric) interface{} {
+	type state struct {
+		Key     interface{}
+		Err     string
+		IsStale bool
+	}
+	switch m.Kind {
+	case types.Dist:
+		if dist, ok := m.Value.(*messages.Distribution); ok {
+			return state{Key: dist.Generation}
+		}
+	case types.List:
+		return state{Key: m.TimeStamp}
+	}
+	return state{Key: m.Value, Err: m.Err, IsStale: m.IsStale}
+}
+
+// sinceCollector collects in Go RPC form the metrics that changed after
+// the Since sequence number.
+type sinceCollector struct {
+	Since   uint64
+	Metrics messages.MetricList
+}
+
+func (c *sinceCollector) Collect(m *metric, s *session) error {
+	result := rpcAsMetric(m, s)
+	if m.changes.Observe(changeState(result)) > c.Since {
+		c.Metrics = append(c.Metrics, result)
+	}
+	return nil
+}
+
+func (c *sinceCollector) CollectError(
+	m *metric, s *session, err error) error {
+	return c.Collect(m, s)
+}
+
+// changedSince returns the metrics sel selects that changed since token
+// in Go RPC form along with the token for the next query.
+func (r *registry) changedSince(sel *selector, token string) (
+	*messages.ChangedMetrics, error) {
+	since, err := parseChangeToken(token)
+	if err != nil {
+		return nil, err
+	}
+	// Holding the lock while reading the next token guarantees that no
+	// other query observes a change that this query missed but that is
+	// older than the next token.
+	r.changeLock.Lock()
+	defer r.changeLock.Unlock()
+	collector := &sinceCollector{
+		Since: since, Metrics: make(messages.MetricList, 0)}
+	if err := r.root.GetAllMetricsBySelector(sel, collector, nil); err != nil {
+		return nil, err
+	}
+	return &messages.ChangedMetrics{
+		Token: changeToken(), Metrics: collector.Metrics}, nil
+}
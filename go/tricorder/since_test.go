@@ -0,0 +1,110 @@
+package tricorder
+
+import (
+	"encoding/json"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"net/url"
+	"testing"
+)
+
+func TestChangedSince(t *testing.T) {
+	reg := NewRegistry()
+	var count Counter
+	name := "first"
+	dist := NewArbitraryBucketer(10.0).NewCumulativeDistribution()
+	aList := NewList([]string{"a"}, ImmutableSlice)
+	reg.RegisterMetric("/count", &count, units.None, "count")
+	reg.RegisterMetric("/dist", dist, units.None, "dist")
+	reg.RegisterMetric("/list", aList, units.None, "list")
+	reg.RegisterMetric("/name", &name, units.None, "name")
+	server := rpc.NewServer()
+	(*registry)(reg).registerRpc(server)
+	serverConn, clientConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	client := rpc.NewClient(clientConn)
+	defer client.Close()
+	listSince := func(token string) *messages.ChangedMetrics {
+		var result messages.ChangedMetrics
+		if err := client.Call(
+			"MetricsServer.ListMetricsSince",
+			messages.ChangedSinceRequest{Path: "/", Since: token},
+			&result); err != nil {
+			t.Fatal(err)
+		}
+		return &result
+	}
+
+	first := listSince("")
+	assertValueDeepEquals(
+		t,
+		[]string{"/count", "/dist", "/list", "/name"},
+		paths(first.Metrics))
+	second := listSince(first.Token)
+	assertValueEquals(t, 0, len(second.Metrics))
+
+	count.Inc()
+	dist.Add(3.0)
+	third := listSince(second.Token)
+	assertValueDeepEquals(
+		t, []string{"/count", "/dist"}, paths(third.Metrics))
+	assertValueEquals(t, uint64(1), third.Metrics[0].Value)
+
+	aList.Change([]string{"b"}, ImmutableSlice)
+	name = "second"
+	fourth := listSince(third.Token)
+	assertValueDeepEquals(
+		t, []string{"/list", "/name"}, paths(fourth.Metrics))
+
+	// A token from another process means start over
+	assertValueEquals(t, 4, len(listSince("abc-17").Metrics))
+	var unused messages.ChangedMetrics
+	if err := client.Call(
+		"MetricsServer.ListMetricsSince",
+		messages.ChangedSinceRequest{Path: "/", Since: "garbage"},
+		&unused); err == nil {
+		t.Error("Expected error for malformed token")
+	}
+}
+
+func TestChangedSinceREST(t *testing.T) {
+	reg := NewRegistry()
+	var count Counter
+	reg.RegisterMetric("/a/count", &count, units.None, "count")
+	reg.RegisterMetric("/a/other", new(Counter), units.None, "other")
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	getSince := func(token string) *messages.ChangedMetrics {
+		resp, err := http.Get(
+			server.URL + "/metricsapi/a?since=" + url.QueryEscape(token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var result messages.ChangedMetrics
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		return &result
+	}
+	first := getSince("")
+	assertValueEquals(t, 2, len(first.Metrics))
+	count.Add(5)
+	second := getSince(first.Token)
+	if assertValueEquals(t, 1, len(second.Metrics)) {
+		assertValueEquals(t, "/a/count", second.Metrics[0].Path)
+		assertValueEquals(t, 5.0, second.Metrics[0].Value)
+	}
+	assertValueEquals(t, 0, len(getSince(second.Token).Metrics))
+
+	resp, err := http.Get(server.URL + "/metricsapi/a?since=garbage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assertValueEquals(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,344 @@
+package tricorder
+
+import (
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Values with smaller magnitude than this go in the zero bucket.
+	sketchMinValue = 1e-9
+)
+
+// sketchBucketer maps values to logarithmic buckets such that every value
+// in a bucket is within a fixed relative error of the bucket's
+// representative value. Same as SketchBucketer.
+type sketchBucketer struct {
+	relativeAccuracy float64
+	gamma            float64
+	logGamma         float64
+}
+
+func newSketchBucketer(relativeAccuracy float64) *sketchBucketer {
+	if !(relativeAccuracy > 0.0 && relativeAccuracy < 1.0) {
+		panic(panicBadRelativeAccuracy)
+	}
+	gamma := (1.0 + relativeAccuracy) / (1.0 - relativeAccuracy)
+	return &sketchBucketer{
+		relativeAccuracy: relativeAccuracy,
+		gamma:            gamma,
+		logGamma:         math.Log(gamma),
+	}
+}
+
+// index returns the index of the bucket for x which must be positive.
+// Bucket i holds values in (gamma^(i-1), gamma^i].
+func (b *sketchBucketer) index(x float64) int32 {
+	return int32(math.Ceil(math.Log(x) / b.logGamma))
+}
+
+// upper returns the upper bound of bucket i.
+func (b *sketchBucketer) upper(i int32) float64 {
+	return math.Exp(float64(i) * b.logGamma)
+}
+
+// value returns the representative value of bucket i. Every value in the
+// bucket is within the relative accuracy of it.
+func (b *sketchBucketer) value(i int32) float64 {
+	return 2.0 * b.upper(i) / (b.gamma + 1.0)
+}
+
+// isCompatible returns true if sketches using b can merge with sketches
+// that have the given relative accuracy.
+func (b *sketchBucketer) isCompatible(relativeAccuracy float64) bool {
+	return math.Abs(b.relativeAccuracy-relativeAccuracy) <= 1e-12
+}
+
+// sketchDistribution represents a mergeable distribution with bounded
+// relative error. Same as SketchDistribution.
+type sketchDistribution struct {
+	bucketer *sketchBucketer
+	groupId  int
+	// Protects all fields below it
+	lock       sync.RWMutex
+	unit       units.Unit
+	unitSet    bool
+	positive   map[int32]uint64
+	negative   map[int32]uint64
+	zeroCount  uint64
+	total      float64
+	min        float64
+	max        float64
+	count      uint64
+	generation uint64
+	timeStamp  time.Time
+	quantiles  []float64
+}
+
+func newSketchDistribution(bucketer *sketchBucketer) *sketchDistribution {
+	return &sketchDistribution{
+		bucketer:  bucketer,
+		groupId:   nextId(),
+		positive:  make(map[int32]uint64),
+		negative:  make(map[int32]uint64),
+		timeStamp: time.Now(),
+	}
+}
+
+func (d *sketchDistribution) SetUnit(unit units.Unit) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !d.unitSet {
+		d.unitSet = true
+		d.unit = unit
+		return true
+	}
+	return unit == d.unit
+}
+
+func (d *sketchDistribution) GroupId() int {
+	return d.groupId
+}
+
+func (d *sketchDistribution) IsNotCumulative() bool {
+	return false
+}
+
+func (d *sketchDistribution) Add(value interface{}) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !d.unitSet {
+		panic(panicNoAssignedUnit)
+	}
+	x := valueToFloatInUnit(value, d.unit)
+	switch {
+	case x >= sketchMinValue:
+		d.positive[d.bucketer.index(x)]++
+	case x <= -sketchMinValue:
+		d.negative[d.bucketer.index(-x)]++
+	default:
+		d.zeroCount++
+	}
+	d.updateStats(x, x, x, 1)
+}
+
+// updateStats updates the summary statistics with count new values
+// totaling total. Caller must hold the lock.
+func (d *sketchDistribution) updateStats(
+	total, min, max float64, count uint64) {
+	if count == 0 {
+		return
+	}
+	if d.count == 0 {
+		d.min = min
+		d.max = max
+	} else {
+		d.min = math.Min(d.min, min)
+		d.max = math.Max(d.max, max)
+	}
+	d.total += total
+	d.count += count
+	d.generation++
+	d.timeStamp = time.Now()
+}
+
+func (d *sketchDistribution) Sum() float64 {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.total
+}
+
+func (d *sketchDistribution) Count() uint64 {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.count
+}
+
+func (d *sketchDistribution) SetQuantiles(quantiles []float64) {
+	sorted := sortedQuantiles(quantiles)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.quantiles = sorted
+}
+
+func (d *sketchDistribution) Quantile(q float64) float64 {
+	checkQuantile(q)
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.quantile(q)
+}
+
+// quantile estimates the value at quantile q. Caller must hold the lock.
+// quantile returns 0 if this distribution is empty.
+func (d *sketchDistribution) quantile(q float64) float64 {
+	if d.count == 0 {
+		return 0.0
+	}
+	if q == 0.0 {
+		return d.min
+	}
+	if q == 1.0 {
+		return d.max
+	}
+	rank := uint64(q * float64(d.count-1))
+	var seen uint64
+	var result float64
+	found := false
+	d.forEachBucket(func(value, start, end float64, count uint64) bool {
+		seen += count
+		if seen > rank {
+			result = value
+			found = true
+			return false
+		}
+		return true
+	})
+	if !found {
+		return d.max
+	}
+	// The representative value of the lowest or highest bucket can fall
+	// outside the actual range of values.
+	return math.Max(d.min, math.Min(d.max, result))
+}
+
+func sortedIndexes(m map[int32]uint64) []int32 {
+	result := make([]int32, 0, len(m))
+	for i := range m {
+		result = append(result, i)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// forEachBucket calls f on each non-empty bucket in ascending order of
+// values until f returns false. f receives the representative value of
+// the bucket, its bounds, and its count. Caller must hold the lock.
+func (d *sketchDistribution) forEachBucket(
+	f func(value, start, end float64, count uint64) bool) {
+	b := d.bucketer
+	negativeIndexes := sortedIndexes(d.negative)
+	for i := len(negativeIndexes) - 1; i >= 0; i-- {
+		idx := negativeIndexes[i]
+		if !f(-b.value(idx), -b.upper(idx), -b.upper(idx-1), d.negative[idx]) {
+			return
+		}
+	}
+	if d.zeroCount > 0 {
+		if !f(0.0, -sketchMinValue, sketchMinValue, d.zeroCount) {
+			return
+		}
+	}
+	for _, idx := range sortedIndexes(d.positive) {
+		if !f(b.value(idx), b.upper(idx-1), b.upper(idx), d.positive[idx]) {
+			return
+		}
+	}
+}
+
+// sketch returns the buckets of this distribution in sparse form.
+// Caller must hold the lock.
+func (d *sketchDistribution) sketch() *messages.Sketch {
+	result := &messages.Sketch{
+		RelativeAccuracy: d.bucketer.relativeAccuracy,
+		ZeroCount:        d.zeroCount,
+	}
+	result.Indexes, result.Counts = sparseBuckets(d.positive)
+	result.NegativeIndexes, result.NegativeCounts = sparseBuckets(d.negative)
+	return result
+}
+
+func sparseBuckets(m map[int32]uint64) (indexes []int32, counts []uint64) {
+	if len(m) == 0 {
+		return
+	}
+	indexes = sortedIndexes(m)
+	counts = make([]uint64, len(indexes))
+	for i, idx := range indexes {
+		counts[i] = m[idx]
+	}
+	return
+}
+
+// Merge adds all the values in other to this distribution.
+func (d *sketchDistribution) Merge(other *sketchDistribution) error {
+	if d == other {
+		return nil
+	}
+	other.lock.RLock()
+	sketch := other.sketch()
+	total, min, max, count := other.total, other.min, other.max, other.count
+	other.lock.RUnlock()
+	return d.merge(sketch, total, min, max, count)
+}
+
+// MergeMessage adds all the values in a distribution from another
+// process to this distribution.
+func (d *sketchDistribution) MergeMessage(dist *messages.Distribution) error {
+	if dist.Sketch == nil {
+		return ErrIncompatibleSketch
+	}
+	return d.merge(dist.Sketch, dist.Sum, dist.Min, dist.Max, dist.Count)
+}
+
+func (d *sketchDistribution) merge(
+	sketch *messages.Sketch, total, min, max float64, count uint64) error {
+	if !d.bucketer.isCompatible(sketch.RelativeAccuracy) ||
+		len(sketch.Indexes) != len(sketch.Counts) ||
+		len(sketch.NegativeIndexes) != len(sketch.NegativeCounts) {
+		return ErrIncompatibleSketch
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for i, idx := range sketch.Indexes {
+		d.positive[idx] += sketch.Counts[i]
+	}
+	for i, idx := range sketch.NegativeIndexes {
+		d.negative[idx] += sketch.NegativeCounts[i]
+	}
+	d.zeroCount += sketch.ZeroCount
+	d.updateStats(total, min, max, count)
+	return nil
+}
+
+// Snapshot fetches the snapshot of this distribution atomically.
+// The breakdown includes only non-empty buckets.
+func (d *sketchDistribution) Snapshot() *snapshot {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	var bdn breakdown
+	d.forEachBucket(func(value, start, end float64, count uint64) bool {
+		bdn = append(
+			bdn,
+			breakdownPiece{
+				bucketPiece: &bucketPiece{Start: start, End: end},
+				Count:       count})
+		return true
+	})
+	result := &snapshot{
+		Count:     d.count,
+		Breakdown: bdn,
+		TimeStamp: d.timeStamp,
+		Sketch:    d.sketch(),
+	}
+	if d.count == 0 {
+		return result
+	}
+	result.Min = d.min
+	result.Max = d.max
+	result.Average = d.total / float64(d.count)
+	result.Median = d.quantile(0.5)
+	result.Sum = d.total
+	result.Generation = d.generation
+	if len(d.quantiles) > 0 {
+		result.Quantiles = make([]quantileValue, len(d.quantiles))
+		for i, q := range d.quantiles {
+			result.Quantiles[i] = quantileValue{
+				Quantile: q, Value: d.quantile(q)}
+		}
+	}
+	return result
+}
@@ -0,0 +1,121 @@
+package tricorder
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"math"
+	"testing"
+)
+
+func assertWithinRelativeError(
+	t *testing.T, expected, actual, relativeError float64) {
+	if math.Abs(actual-expected) > relativeError*math.Abs(expected) {
+		t.Errorf("Expected %v within %v, got %v", expected, relativeError, actual)
+	}
+}
+
+func TestSketchDistributionQuantiles(t *testing.T) {
+	dist := NewSketchBucketer(0.01).NewSketchDistribution()
+	(*sketchDistribution)(dist).SetUnit(units.None)
+	for i := 1; i <= 100000; i++ {
+		dist.Add(float64(i))
+	}
+	assertValueEquals(t, uint64(100000), dist.Count())
+	assertValueEquals(t, 1.0, dist.Quantile(0.0))
+	assertValueEquals(t, 100000.0, dist.Quantile(1.0))
+	for _, q := range []float64{0.001, 0.1, 0.5, 0.9, 0.99, 0.999} {
+		assertWithinRelativeError(
+			t, 1.0+q*99999.0, dist.Quantile(q), 0.011)
+	}
+	snapshot := (*sketchDistribution)(dist).Snapshot()
+	assertWithinRelativeError(t, 50000.5, snapshot.Median, 0.011)
+	assertValueEquals(t, 1.0, snapshot.Min)
+	assertValueEquals(t, 100000.0, snapshot.Max)
+	// Buckets get allocated on demand
+	if len(snapshot.Sketch.Indexes) > 1200 {
+		t.Errorf("Too many buckets: %d", len(snapshot.Sketch.Indexes))
+	}
+}
+
+func TestSketchDistributionNegativeAndZero(t *testing.T) {
+	dist := NewSketchBucketer(0.02).NewSketchDistribution()
+	(*sketchDistribution)(dist).SetUnit(units.None)
+	dist.Add(-100.0)
+	dist.Add(-1.0)
+	dist.Add(0.0)
+	dist.Add(1.0)
+	dist.Add(100.0)
+	assertWithinRelativeError(t, -1.0, dist.Quantile(0.25), 0.021)
+	assertValueEquals(t, 0.0, dist.Quantile(0.5))
+	assertWithinRelativeError(t, 1.0, dist.Quantile(0.75), 0.021)
+	snapshot := (*sketchDistribution)(dist).Snapshot()
+	assertValueEquals(t, 5, len(snapshot.Breakdown))
+	assertValueEquals(t, uint64(1), snapshot.Sketch.ZeroCount)
+	assertValueEquals(t, 2, len(snapshot.Sketch.NegativeIndexes))
+	for i := 1; i < len(snapshot.Breakdown); i++ {
+		if snapshot.Breakdown[i-1].End > snapshot.Breakdown[i].Start {
+			t.Error("Expected breakdown in ascending order")
+		}
+	}
+}
+
+func TestSketchDistributionMerge(t *testing.T) {
+	bucketer := NewSketchBucketer(0.01)
+	first := bucketer.NewSketchDistribution()
+	second := bucketer.NewSketchDistribution()
+	reg := NewRegistry()
+	reg.RegisterMetric("/first", first, units.Millisecond, "first")
+	reg.RegisterMetric("/second", second, units.Millisecond, "second")
+	for i := 1; i <= 500; i++ {
+		first.Add(float64(i))
+		second.Add(float64(i + 500))
+	}
+	if err := first.Merge(second); err != nil {
+		t.Fatal(err)
+	}
+	assertValueEquals(t, uint64(1000), first.Count())
+	assertValueEquals(t, 1000.0, first.Quantile(1.0))
+	assertWithinRelativeError(t, 900.0, first.Quantile(0.9), 0.011)
+
+	// Merge a distribution read from another process over JSON and gob
+	list := reg.ReadMyMetrics("/second")
+	list[0].ConvertToJson()
+	jsonBytes, err := json.Marshal(list[0].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromJson messages.Distribution
+	if err := json.Unmarshal(jsonBytes, &fromJson); err != nil {
+		t.Fatal(err)
+	}
+	assertValueEquals(t, 0, len(fromJson.Ranges))
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(&fromJson); err != nil {
+		t.Fatal(err)
+	}
+	var fromGob messages.Distribution
+	if err := gob.NewDecoder(&buffer).Decode(&fromGob); err != nil {
+		t.Fatal(err)
+	}
+	merged := bucketer.NewSketchDistribution()
+	(*sketchDistribution)(merged).SetUnit(units.Millisecond)
+	if err := merged.MergeMessage(&fromGob); err != nil {
+		t.Fatal(err)
+	}
+	if err := merged.MergeMessage(&fromJson); err != nil {
+		t.Fatal(err)
+	}
+	assertValueEquals(t, uint64(1000), merged.Count())
+	assertValueEquals(t, 501.0, merged.Quantile(0.0))
+	assertWithinRelativeError(t, 750.0, merged.Quantile(0.5), 0.011)
+
+	other := NewSketchBucketer(0.05).NewSketchDistribution()
+	assertValueEquals(t, ErrIncompatibleSketch, other.Merge(first))
+	assertValueEquals(
+		t,
+		ErrIncompatibleSketch,
+		other.MergeMessage(&messages.Distribution{Count: 3}))
+}
@@ -0,0 +1,184 @@
+package tricorder
+
+import (
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+const (
+	structTagName = "tricorder"
+)
+
+// structFieldSpec is what the tricorder tag of a struct field specifies.
+type structFieldSpec struct {
+	Name        string
+	Unit        units.Unit
+	Description string
+	// True if the tag is "-" meaning skip the field.
+	Skip bool
+}
+
+// parseStructTag parses a tag such as "name,unit=Seconds,desc=..." for
+// the given field. Because descriptions may contain commas, desc must
+// come last.
+func parseStructTag(field reflect.StructField) (
+	result structFieldSpec, err error) {
+	tag := field.Tag.Get(structTagName)
+	if tag == "-" {
+		result.Skip = true
+		return
+	}
+	if descIdx := strings.Index(tag, "desc="); descIdx != -1 &&
+		(descIdx == 0 || tag[descIdx-1] == ',') {
+		result.Description = tag[descIdx+len("desc="):]
+		tag = strings.TrimSuffix(tag[:descIdx], ",")
+	}
+	parts := strings.Split(tag, ",")
+	result.Name = parts[0]
+	for _, part := range parts[1:] {
+		switch {
+		case strings.HasPrefix(part, "unit="):
+			if result.Unit, err = parseUnit(
+				strings.TrimPrefix(part, "unit=")); err != nil {
+				return
+			}
+		case part == "":
+		default:
+			return result, ErrBadTag
+		}
+	}
+	if result.Name == "" {
+		result.Name = kebabCase(field.Name)
+	}
+	return
+}
+
+func parseUnit(name string) (units.Unit, error) {
+	for _, unit := range units.All {
+		if string(unit) == name {
+			return unit, nil
+		}
+	}
+	return units.Unknown, ErrWrongUnit
+}
+
+// kebabCase converts a Go field name such as MaxRSSBytes to max-rss-bytes.
+func kebabCase(name string) string {
+	runes := []rune(name)
+	var result []rune
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// Start a new word at a lower to upper transition and
+			// before the last upper case letter of an acronym.
+			if i > 0 && (unicode.IsLower(runes[i-1]) ||
+				unicode.IsDigit(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]) &&
+					unicode.IsUpper(runes[i-1]))) {
+				result = append(result, '-')
+			}
+			r = unicode.ToLower(r)
+		}
+		result = append(result, r)
+	}
+	return string(result)
+}
+
+// isStructMetric returns true if t is a struct type that tricorder
+// registers as a single metric rather than as a directory.
+func isStructMetric(t reflect.Type) bool {
+	if _, _, ok := getPrimitiveType(t); ok {
+		return true
+	}
+	switch reflect.New(t).Interface().(type) {
+	case *Counter, *Gauge, *IntGauge:
+		return true
+	}
+	return false
+}
+
+// isPointerMetric returns true if t is a pointer type such as *List that
+// tricorder registers directly.
+func isPointerMetric(t reflect.Type) bool {
+	switch reflect.Zero(t).Interface().(type) {
+	case *List,
+		*CumulativeDistribution,
+		*NonCumulativeDistribution,
+		*WindowedDistribution,
+		*SketchDistribution:
+		return true
+	}
+	return false
+}
+
+// registerStruct registers the exported fields of the struct that s
+// points to under path. Same as DirectorySpec.RegisterStruct.
+func (d *directory) registerStruct(
+	path pathSpec, s interface{}, region *region) error {
+	v := reflect.ValueOf(s)
+	if v.Kind() != reflect.Ptr || v.IsNil() ||
+		v.Elem().Kind() != reflect.Struct {
+		return ErrWrongType
+	}
+	dir, err := d.registerDirectory(path)
+	if err != nil {
+		return err
+	}
+	return dir.registerStructFields(v.Elem(), region)
+}
+
+func (d *directory) registerStructFields(
+	v reflect.Value, region *region) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		// Skip unexported fields
+		if field.PkgPath != "" {
+			continue
+		}
+		spec, err := parseStructTag(field)
+		if err != nil {
+			return err
+		}
+		if spec.Skip {
+			continue
+		}
+		fieldValue := v.Field(i)
+		var metric interface{}
+		switch {
+		case isPointerMetric(field.Type):
+			if fieldValue.IsNil() {
+				return ErrWrongType
+			}
+			metric = fieldValue.Interface()
+		case isStructMetric(field.Type):
+			metric = fieldValue.Addr().Interface()
+		case field.Type.Kind() == reflect.Struct:
+			subDir, err := d.registerDirectory(newPathSpec(spec.Name))
+			if err != nil {
+				return err
+			}
+			if err := subDir.registerStructFields(
+				fieldValue, region); err != nil {
+				return err
+			}
+			continue
+		default:
+			return ErrWrongType
+		}
+		unit := spec.Unit
+		if unit == units.Unknown {
+			unit = defaultUnit(fieldValue.Interface())
+		}
+		if err := d.registerMetric(
+			newPathSpec(spec.Name),
+			metric,
+			region,
+			unit,
+			spec.Description); err != nil {
+			return err
+		}
+	}
+	return nil
+}
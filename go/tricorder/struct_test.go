@@ -0,0 +1,150 @@
+package tricorder
+
+import (
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"testing"
+	"time"
+)
+
+type structTestConnections struct {
+	Open   IntGauge
+	Closed Counter `tricorder:"closed-total,desc=Connections closed, ever"`
+}
+
+type structTestStats struct {
+	NumGoroutines int
+	UserTime      time.Duration `tricorder:"user,desc=User CPU time"`
+	Latency       float64       `tricorder:",unit=Milliseconds"`
+	MaxRSSBytes   int64         `tricorder:",unit=Bytes,desc=Max resident set size"`
+	Name          string
+	StartTime     time.Time
+	Ignored       int64 `tricorder:"-"`
+	Connections   structTestConnections
+	Names         *List
+	Rpc           *CumulativeDistribution `tricorder:",unit=Milliseconds"`
+	unexported    int64
+}
+
+func TestRegisterStruct(t *testing.T) {
+	reg := NewRegistry()
+	updates := 0
+	stats := &structTestStats{
+		Names: NewList([]string{"a", "b"}, ImmutableSlice),
+		Rpc:   PowersOfTen.NewCumulativeDistribution(),
+	}
+	group := NewGroup()
+	group.RegisterUpdateFunc(func() time.Time {
+		updates++
+		stats.NumGoroutines = 10 * updates
+		stats.MaxRSSBytes = 1000
+		return kUsualTimeStamp
+	})
+	stats.Connections.Closed.Add(3)
+	stats.Connections.Open.Set(2)
+	if err := reg.RegisterStruct("/stats", stats, group); err != nil {
+		t.Fatalf("Got error %v registering struct", err)
+	}
+	stats.Rpc.Add(50 * time.Millisecond)
+	dir, err := reg.GetDirectory("/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyChildren(
+		t,
+		(*directory)(dir).List(),
+		"connections",
+		"latency",
+		"max-rss-bytes",
+		"name",
+		"names",
+		"num-goroutines",
+		"rpc",
+		"start-time",
+		"user")
+	connections, err := reg.GetDirectory("/stats/connections")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyChildren(
+		t, (*directory)(connections).List(), "closed-total", "open")
+
+	list := reg.ReadMyMetrics("/stats")
+	byPath := make(map[string]int)
+	for i := range list {
+		byPath[list[i].Path] = i
+	}
+	m := list[byPath["/stats/num-goroutines"]]
+	assertValueEquals(t, int64(10), m.Value)
+	assertValueEquals(t, kUsualTimeStamp, m.TimeStamp)
+	m = list[byPath["/stats/max-rss-bytes"]]
+	// Read in the same update as num-goroutines
+	assertValueEquals(t, int64(1000), m.Value)
+	assertValueEquals(t, units.Byte, m.Unit)
+	assertValueEquals(t, "Max resident set size", m.Description)
+	assertValueEquals(t, 1, updates)
+	m = list[byPath["/stats/user"]]
+	assertValueEquals(t, units.Second, m.Unit)
+	assertValueEquals(t, "User CPU time", m.Description)
+	m = list[byPath["/stats/latency"]]
+	assertValueEquals(t, units.Millisecond, m.Unit)
+	m = list[byPath["/stats/connections/closed-total"]]
+	assertValueEquals(t, uint64(3), m.Value)
+	assertValueEquals(t, "Connections closed, ever", m.Description)
+	assertValueEquals(t, true, m.IsMonotonic)
+	m = list[byPath["/stats/connections/open"]]
+	assertValueEquals(t, int64(2), m.Value)
+	m = list[byPath["/stats/names"]]
+	assertValueEquals(t, types.List, m.Kind)
+	m = list[byPath["/stats/rpc"]]
+	assertValueEquals(t, types.Dist, m.Kind)
+	assertValueEquals(t, units.Millisecond, m.Unit)
+}
+
+func TestRegisterStructErrors(t *testing.T) {
+	reg := NewRegistry()
+	var notStruct int64
+	if err := reg.RegisterStruct(
+		"/a", &notStruct, DefaultGroup); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+	if err := reg.RegisterStruct(
+		"/a", structTestStats{}, DefaultGroup); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+	// Nil pointer
+	if err := reg.RegisterStruct(
+		"/b", &structTestStats{}, DefaultGroup); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+	var badUnit struct {
+		Value int64 `tricorder:",unit=Furlongs"`
+	}
+	if err := reg.RegisterStruct(
+		"/c", &badUnit, DefaultGroup); err != ErrWrongUnit {
+		t.Errorf("Expected ErrWrongUnit, got %v", err)
+	}
+	var badTag struct {
+		Value int64 `tricorder:"value,color=red"`
+	}
+	if err := reg.RegisterStruct(
+		"/d", &badTag, DefaultGroup); err != ErrBadTag {
+		t.Errorf("Expected ErrBadTag, got %v", err)
+	}
+	var badType struct {
+		Value map[string]int
+	}
+	if err := reg.RegisterStruct(
+		"/e", &badType, DefaultGroup); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+}
+
+func TestKebabCase(t *testing.T) {
+	assertValueEquals(t, "num-goroutines", kebabCase("NumGoroutines"))
+	assertValueEquals(t, "max-rss-bytes", kebabCase("MaxRSSBytes"))
+	assertValueEquals(t, "http-requests", kebabCase("HTTPRequests"))
+	assertValueEquals(t, "user-time", kebabCase("UserTime"))
+	assertValueEquals(t, "rpc", kebabCase("Rpc"))
+	assertValueEquals(t, "a", kebabCase("A"))
+}
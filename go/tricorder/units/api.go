@@ -14,6 +14,18 @@ const (
 	BytePerSecond Unit = "BytesPerSecond"
 )
 
+var (
+	// All lists every unit except Unknown. Callers must not modify it.
+	All = []Unit{
+		None,
+		Millisecond,
+		Second,
+		Celsius,
+		Byte,
+		BytePerSecond,
+	}
+)
+
 func (u Unit) String() string {
 	if u == Unknown {
 		return "Unknown"
@@ -0,0 +1,98 @@
+package tricorder
+
+import (
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// watcher reports changes to the metrics a selector selects.
+// Same as Watcher.
+type watcher struct {
+	// Receives the metrics that changed since the last poll
+	C        <-chan messages.MetricList
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func (r *registry) watch(sel *selector, interval time.Duration) *watcher {
+	if interval <= 0 {
+		panic(panicNonPositiveInterval)
+	}
+	ch := make(chan messages.MetricList)
+	result := &watcher{C: ch, stopCh: make(chan struct{})}
+	go result.loop(r, sel, interval, ch)
+	return result
+}
+
+func (w *watcher) Stop() {
+	w.stopOnce.Do(func() { close(w.stopCh) })
+}
+
+func (w *watcher) loop(
+	r *registry,
+	sel *selector,
+	interval time.Duration,
+	ch chan<- messages.MetricList) {
+	defer close(ch)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last map[string]*messages.Metric
+	for {
+		current := r.selectMyMetrics(sel)
+		next := make(map[string]*messages.Metric, len(current))
+		var changed messages.MetricList
+		for _, m := range current {
+			if metricChanged(last[m.Path], m) {
+				changed = append(changed, m)
+			}
+			// Keep our own copy as the receiver may convert the metrics
+			// it receives in place.
+			saved := *m
+			next[m.Path] = &saved
+		}
+		last = next
+		if len(changed) > 0 {
+			select {
+			case ch <- changed:
+			case <-w.stopCh:
+				return
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-w.stopCh:
+			return
+		}
+	}
+}
+
+// metricChanged returns true if current differs from previous, the same
+// metric at an earlier time. previous is nil if the metric is new.
+// Distributions change when their generation changes, lists change when
+// their timestamp changes, and everything else changes when its value
+// changes.
+func metricChanged(previous, current *messages.Metric) bool {
+	if previous == nil {
+		return true
+	}
+	if previous.Kind != current.Kind || previous.Err != current.Err ||
+		previous.IsStale != current.IsStale {
+		return true
+	}
+	switch current.Kind {
+	case types.Dist:
+		previousDist, ok1 := previous.Value.(*messages.Distribution)
+		currentDist, ok2 := current.Value.(*messages.Distribution)
+		if !ok1 || !ok2 {
+			return ok1 != ok2
+		}
+		return previousDist.Generation != currentDist.Generation
+	case types.List:
+		return !reflect.DeepEqual(previous.TimeStamp, current.TimeStamp)
+	default:
+		return !reflect.DeepEqual(previous.Value, current.Value)
+	}
+}
@@ -0,0 +1,119 @@
+package tricorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func receiveList(t *testing.T, w *Watcher) messages.MetricList {
+	select {
+	case result := <-w.C:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for watcher")
+		return nil
+	}
+}
+
+func paths(list messages.MetricList) []string {
+	result := make([]string, len(list))
+	for i := range list {
+		result[i] = list[i].Path
+	}
+	return result
+}
+
+func TestWatch(t *testing.T) {
+	reg := NewRegistry()
+	var count Counter
+	temperature := 20.5
+	dist := NewArbitraryBucketer(10.0).NewCumulativeDistribution()
+	aList := NewList([]int64{1, 2}, ImmutableSlice)
+	reg.RegisterMetric("/a/count", &count, units.None, "count")
+	reg.RegisterMetric("/a/dist", dist, units.None, "dist")
+	reg.RegisterMetric("/a/list", aList, units.None, "list")
+	reg.RegisterMetric("/b/temperature", &temperature, units.None, "temp")
+	watcher := reg.Watch("/a", time.Millisecond)
+	defer watcher.Stop()
+	assertValueDeepEquals(
+		t,
+		[]string{"/a/count", "/a/dist", "/a/list"},
+		paths(receiveList(t, watcher)))
+
+	count.Inc()
+	assertValueDeepEquals(
+		t, []string{"/a/count"}, paths(receiveList(t, watcher)))
+	dist.Add(3.0)
+	assertValueDeepEquals(
+		t, []string{"/a/dist"}, paths(receiveList(t, watcher)))
+	aList.Change([]int64{3}, ImmutableSlice)
+	assertValueDeepEquals(
+		t, []string{"/a/list"}, paths(receiveList(t, watcher)))
+	var newCount Counter
+	reg.RegisterMetric("/a/new", &newCount, units.None, "new")
+	assertValueDeepEquals(
+		t, []string{"/a/new"}, paths(receiveList(t, watcher)))
+
+	watcher.Stop()
+	watcher.Stop()
+	// Drain until closed
+	for range watcher.C {
+	}
+}
+
+func TestWatchServerSentEvents(t *testing.T) {
+	reg := NewRegistry()
+	var count Counter
+	reg.RegisterMetric("/a/count", &count, units.None, "count")
+	reg.RegisterMetric("/a/other", new(Counter), units.None, "other")
+	server := httptest.NewServer(reg)
+	defer server.Close()
+
+	badResp, err := http.Get(server.URL + "/metricsapi/a?watch=soon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	badResp.Body.Close()
+	assertValueEquals(t, http.StatusBadRequest, badResp.StatusCode)
+
+	resp, err := http.Get(server.URL + "/metricsapi/a?watch=1ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assertValueEquals(
+		t, "text/event-stream", resp.Header.Get("Content-Type"))
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() messages.MetricList {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(line, "data: ") {
+			t.Fatalf("Unexpected line %q", line)
+		}
+		var list messages.MetricList
+		if err := json.Unmarshal([]byte(line[6:]), &list); err != nil {
+			t.Fatal(err)
+		}
+		if blank, _ := reader.ReadString('\n'); blank != "\n" {
+			t.Fatalf("Expected blank line, got %q", blank)
+		}
+		return list
+	}
+	assertValueDeepEquals(
+		t, []string{"/a/count", "/a/other"}, paths(readEvent()))
+	count.Add(2)
+	changed := readEvent()
+	if assertValueEquals(t, 1, len(changed)) {
+		assertValueEquals(t, "/a/count", changed[0].Path)
+		assertValueEquals(t, 2.0, changed[0].Value)
+	}
+}
@@ -0,0 +1,172 @@
+package tricorder
+
+import (
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"math"
+	"sync"
+	"time"
+)
+
+// windowedDistribution represents a distribution of only the values
+// added within a sliding window of time. Same as WindowedDistribution.
+//
+// windowedDistribution divides its window into equal slices and keeps a
+// ring of sub-distributions, one for each slice. As time passes, the ring
+// rotates and the oldest slice gets cleared.
+type windowedDistribution struct {
+	// Immutable
+	pieces        []*bucketPiece
+	window        time.Duration
+	sliceDuration time.Duration
+	groupId       int
+	now           func() time.Time
+	// Protects all fields below it
+	lock    sync.Mutex
+	unit    units.Unit
+	unitSet bool
+	// The ring of slices. The slices themselves are accessed only while
+	// holding lock, so their own locks go unused.
+	ring []*distribution
+	// Index of the slice receiving new values
+	current int
+	// Start time of the current slice
+	currentStart time.Time
+	generation   uint64
+	quantiles    []float64
+}
+
+func newWindowedDistribution(
+	bucketer *Bucketer,
+	window time.Duration,
+	slices int,
+	now func() time.Time) *windowedDistribution {
+	if window <= 0 || slices < 1 || window < time.Duration(slices) {
+		panic(panicBadWindow)
+	}
+	result := &windowedDistribution{
+		pieces:        bucketer.pieces,
+		window:        window,
+		sliceDuration: window / time.Duration(slices),
+		groupId:       nextId(),
+		now:           now,
+		ring:          make([]*distribution, slices),
+		currentStart:  now(),
+	}
+	for i := range result.ring {
+		result.ring[i] = result.newSlice()
+	}
+	return result
+}
+
+// newSlice returns a new, empty slice. Caller must hold the lock
+// unless caller is the constructor.
+func (w *windowedDistribution) newSlice() *distribution {
+	return &distribution{
+		pieces:  w.pieces,
+		counts:  make([]uint64, len(w.pieces)),
+		unit:    w.unit,
+		unitSet: w.unitSet,
+	}
+}
+
+func (w *windowedDistribution) SetUnit(unit units.Unit) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.unitSet {
+		w.unitSet = true
+		w.unit = unit
+		for _, slice := range w.ring {
+			slice.unit = unit
+			slice.unitSet = true
+		}
+		return true
+	}
+	return unit == w.unit
+}
+
+func (w *windowedDistribution) GroupId() int {
+	return w.groupId
+}
+
+// IsNotCumulative always returns true as values leave the window
+// over time.
+func (w *windowedDistribution) IsNotCumulative() bool {
+	return true
+}
+
+func (w *windowedDistribution) Add(value interface{}) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	now := w.now()
+	w.rotate(now)
+	slice := w.ring[w.current]
+	slice.add(slice.valueToFloat(value), now)
+	w.generation++
+}
+
+func (w *windowedDistribution) SetQuantiles(quantiles []float64) {
+	sorted := sortedQuantiles(quantiles)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.quantiles = sorted
+}
+
+// rotate advances the ring so that the current slice contains now.
+// Caller must hold the lock.
+func (w *windowedDistribution) rotate(now time.Time) {
+	elapsed := now.Sub(w.currentStart)
+	if elapsed < w.sliceDuration {
+		return
+	}
+	slicesElapsed := elapsed / w.sliceDuration
+	w.currentStart = w.currentStart.Add(slicesElapsed * w.sliceDuration)
+	if slicesElapsed > time.Duration(len(w.ring)) {
+		slicesElapsed = time.Duration(len(w.ring))
+	}
+	for i := time.Duration(0); i < slicesElapsed; i++ {
+		w.current = (w.current + 1) % len(w.ring)
+		if w.ring[w.current].count > 0 {
+			w.generation++
+		}
+		w.ring[w.current] = w.newSlice()
+	}
+}
+
+// Snapshot merges the slices still inside the window.
+func (w *windowedDistribution) Snapshot() *snapshot {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.rotate(w.now())
+	merged := &distribution{
+		pieces:          w.pieces,
+		counts:          make([]uint64, len(w.pieces)),
+		isNotCumulative: true,
+		quantiles:       w.quantiles,
+		timeStamp:       w.currentStart,
+	}
+	for _, slice := range w.ring {
+		if slice.count == 0 {
+			continue
+		}
+		for i := range slice.counts {
+			merged.counts[i] += slice.counts[i]
+		}
+		if merged.count == 0 {
+			merged.min = slice.min
+			merged.max = slice.max
+		} else {
+			merged.min = math.Min(merged.min, slice.min)
+			merged.max = math.Max(merged.max, slice.max)
+		}
+		merged.total += slice.total
+		merged.count += slice.count
+		if slice.timeStamp.After(merged.timeStamp) {
+			merged.timeStamp = slice.timeStamp
+		}
+	}
+	merged.generation = w.generation
+	result := merged.Snapshot()
+	result.IsNotCumulative = true
+	result.Window = w.window
+	return result
+}
@@ -0,0 +1,90 @@
+package tricorder
+
+import (
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestWindowedDistribution(t *testing.T) {
+	clock := &fakeClock{now: kUsualTimeStamp}
+	dist := newWindowedDistribution(
+		NewArbitraryBucketer(10.0, 100.0), time.Minute, 3, clock.Now)
+	dist.SetUnit(units.Millisecond)
+	assertValueEquals(t, uint64(0), dist.Snapshot().Count)
+
+	dist.Add(5.0)
+	clock.Advance(20 * time.Second)
+	dist.Add(50.0)
+	dist.Add(time.Second)
+	clock.Advance(20 * time.Second)
+	dist.Add(7.0)
+	snapshot := dist.Snapshot()
+	assertValueEquals(t, uint64(4), snapshot.Count)
+	assertValueEquals(t, 5.0, snapshot.Min)
+	assertValueEquals(t, 1000.0, snapshot.Max)
+	assertValueEquals(t, 1062.0, snapshot.Sum)
+	assertValueEquals(t, true, snapshot.IsNotCumulative)
+	assertValueEquals(t, time.Minute, snapshot.Window)
+	assertValueEquals(t, uint64(2), snapshot.Breakdown[0].Count)
+	generation := snapshot.Generation
+
+	// The first slice leaves the window
+	clock.Advance(25 * time.Second)
+	snapshot = dist.Snapshot()
+	assertValueEquals(t, uint64(3), snapshot.Count)
+	assertValueEquals(t, 7.0, snapshot.Min)
+	assertValueEquals(t, 1057.0, snapshot.Sum)
+	if snapshot.Generation <= generation {
+		t.Error("Expected generation to increase as values leave window")
+	}
+
+	// Everything leaves the window
+	clock.Advance(time.Hour)
+	snapshot = dist.Snapshot()
+	assertValueEquals(t, uint64(0), snapshot.Count)
+	dist.Add(3.0)
+	assertValueEquals(t, uint64(1), dist.Snapshot().Count)
+}
+
+func TestWindowedDistributionMetric(t *testing.T) {
+	reg := NewRegistry()
+	dist := NewLinearBucketer(5, 0.0, 10.0).NewWindowedDistribution(
+		5*time.Minute, 10)
+	dist.SetQuantiles(0.5)
+	if err := reg.RegisterMetric(
+		"/latency", dist, units.Millisecond, "Latency"); err != nil {
+		t.Fatalf("Got error %v registering metric", err)
+	}
+	if err := reg.RegisterMetric(
+		"/again", dist, units.Second, "Latency"); err != ErrWrongUnit {
+		t.Errorf("Expected ErrWrongUnit, got %v", err)
+	}
+	dist.Add(12.0)
+	dist.Add(14.0)
+	list := reg.ReadMyMetrics("/latency")
+	if !assertValueEquals(t, 1, len(list)) {
+		return
+	}
+	actual := list[0].Value.(*messages.Distribution)
+	assertValueEquals(t, uint64(2), actual.Count)
+	assertValueEquals(t, 300.0, actual.Window)
+	assertValueEquals(t, true, actual.IsNotCumulative)
+	assertValueEquals(t, 1, len(actual.Quantiles))
+	assertPanics(t, func() {
+		NewLinearBucketer(5, 0.0, 10.0).NewWindowedDistribution(0, 10)
+	})
+}
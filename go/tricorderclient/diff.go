@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/Symantec/tricorder/go/tricorder/client"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// readMetricFile reads a metric list in JSON form such as the output of
+// dump with -format json.
+func readMetricFile(filename string) (messages.MetricList, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	result, err := client.DecodeJson(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	for _, m := range result {
+		m.ConvertToJson()
+	}
+	return result, nil
+}
+
+// readMetrics returns the metrics under path from source which is either
+// a saved JSON file or the host:port of a process.
+func readMetrics(env *environment, source, path string) (
+	messages.MetricList, error) {
+	if info, err := os.Stat(source); err == nil && !info.IsDir() {
+		metrics, err := readMetricFile(source)
+		if err != nil {
+			return nil, err
+		}
+		var result messages.MetricList
+		for _, m := range metrics {
+			if isUnder(m.Path, path) {
+				result = append(result, m)
+			}
+		}
+		return result, nil
+	}
+	c, err := newJsonClient(source, env.Transport, env.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.List(path)
+}
+
+// formatChange returns what changed about a metric as a single line.
+func formatChange(d *messages.MetricDiff) string {
+	var parts []string
+	if d.OldKind != d.NewKind {
+		parts = append(parts, fmt.Sprintf("kind:%s->%s", d.OldKind, d.NewKind))
+	}
+	if d.OldUnit != d.NewUnit {
+		parts = append(parts, fmt.Sprintf("unit:%s->%s", d.OldUnit, d.NewUnit))
+	}
+	if d.OldDescription != d.NewDescription {
+		parts = append(
+			parts,
+			fmt.Sprintf("description:%q->%q", d.OldDescription, d.NewDescription))
+	}
+	if d.Delta != nil {
+		parts = append(parts, "delta:"+formatDelta(*d.Delta))
+	}
+	if dist := d.Distribution; dist != nil {
+		parts = append(
+			parts,
+			"count:"+strconv.FormatInt(dist.Count, 10),
+			"sum:"+formatDelta(dist.Sum))
+		for _, r := range dist.Ranges {
+			if r.Count != 0 {
+				parts = append(parts, fmt.Sprintf(
+					"[%g,%g):%+d", r.Lower, r.Upper, r.Count))
+			}
+		}
+	}
+	if d.ValueChanged {
+		parts = append(parts, "value changed")
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatDelta(f float64) string {
+	return strconv.FormatFloat(f, 'g', 6, 64)
+}
+
+// writeDiff writes d in the given format. Added metrics start with +,
+// removed metrics with -, and changed metrics with ~.
+func writeDiff(w io.Writer, format string, d *messages.MetricListDiff) error {
+	if format == kFormatJson {
+		return writeJson(w, d)
+	}
+	if format == kFormatText {
+		for _, path := range d.Added {
+			if _, err := fmt.Fprintf(w, "+ %s\n", path); err != nil {
+				return err
+			}
+		}
+		for _, path := range d.Removed {
+			if _, err := fmt.Fprintf(w, "- %s\n", path); err != nil {
+				return err
+			}
+		}
+		for _, change := range d.Changed {
+			if _, err := fmt.Fprintf(
+				w, "~ %s %s\n", change.Path, formatChange(change)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tPATH\tCHANGE")
+	for _, path := range d.Added {
+		fmt.Fprintf(tw, "+\t%s\tadded\n", path)
+	}
+	for _, path := range d.Removed {
+		fmt.Fprintf(tw, "-\t%s\tremoved\n", path)
+	}
+	for _, change := range d.Changed {
+		fmt.Fprintf(tw, "~\t%s\t%s\n", change.Path, formatChange(change))
+	}
+	return tw.Flush()
+}
+
+func runDiff(env *environment, flags *flag.FlagSet, args []string) error {
+	path := flags.String("path", "/", "Compare only the metrics under this path")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	var beforeSource, afterSource string
+	switch flags.NArg() {
+	case 1:
+		beforeSource, afterSource = flags.Arg(0), env.Host
+	case 2:
+		beforeSource, afterSource = flags.Arg(0), flags.Arg(1)
+	default:
+		return errUsage
+	}
+	before, err := readMetrics(env, beforeSource, cleanPath(*path))
+	if err != nil {
+		return err
+	}
+	after, err := readMetrics(env, afterSource, cleanPath(*path))
+	if err != nil {
+		return err
+	}
+	return writeDiff(env.Out, env.Format, messages.Diff(before, after))
+}
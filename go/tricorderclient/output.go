@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats
+const (
+	kFormatTable = "table"
+	kFormatJson  = "json"
+	kFormatText  = "text"
+)
+
+func isValidFormat(format string) bool {
+	switch format {
+	case kFormatTable, kFormatJson, kFormatText:
+		return true
+	default:
+		return false
+	}
+}
+
+func writeJson(w io.Writer, value interface{}) error {
+	content, err := json.MarshalIndent(value, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", content)
+	return err
+}
+
+// formatValue returns the value of m, in JSON form, as a single line.
+func formatValue(m *messages.Metric) string {
+	if m.Err != "" {
+		return "error: " + m.Err
+	}
+	switch v := m.Value.(type) {
+	case nil:
+		return ""
+	case *messages.Distribution:
+		return formatDistribution(v)
+	case string:
+		return v
+	}
+	value := reflect.ValueOf(m.Value)
+	if value.Kind() == reflect.Slice {
+		parts := make([]string, value.Len())
+		for i := range parts {
+			parts[i] = fmt.Sprint(value.Index(i).Interface())
+		}
+		return "[" + strings.Join(parts, " ") + "]"
+	}
+	return fmt.Sprint(m.Value)
+}
+
+func formatDistribution(d *messages.Distribution) string {
+	parts := []string{
+		fmt.Sprintf("count:%d", d.Count),
+		fmt.Sprintf("min:%g", d.Min),
+		fmt.Sprintf("max:%g", d.Max),
+		fmt.Sprintf("avg:%g", d.Average),
+		fmt.Sprintf("median:%g", d.Median),
+	}
+	for _, q := range d.Quantiles {
+		parts = append(
+			parts, fmt.Sprintf("p%g:%g", q.Quantile*100, q.Value))
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatUnit(unit units.Unit) string {
+	if unit == units.None {
+		return ""
+	}
+	return string(unit)
+}
+
+// writeMetrics writes metrics in the given format.
+func writeMetrics(
+	w io.Writer, format string, metrics messages.MetricList) error {
+	switch format {
+	case kFormatJson:
+		return writeJson(w, metrics)
+	case kFormatText:
+		for _, m := range metrics {
+			if _, err := fmt.Fprintf(
+				w, "%s %s\n", m.Path, formatValue(m)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "PATH\tVALUE\tUNIT")
+		for _, m := range metrics {
+			fmt.Fprintf(
+				tw,
+				"%s\t%s\t%s\n",
+				m.Path,
+				formatValue(m),
+				formatUnit(m.Unit))
+		}
+		return tw.Flush()
+	}
+}
+
+// metadataName returns the name to show for m: the path relative to
+// the listed directory with a trailing slash for directories.
+func metadataName(m *messages.Metadata, dir string) string {
+	name := strings.TrimPrefix(m.Path, dir)
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		name = m.Path
+	}
+	if m.IsDirectory {
+		name += "/"
+	}
+	return name
+}
+
+// writeListing writes the contents of the directory dir in the given
+// format.
+func writeListing(
+	w io.Writer,
+	format string,
+	dir string,
+	list messages.MetadataList) error {
+	switch format {
+	case kFormatJson:
+		return writeJson(w, list)
+	case kFormatText:
+		for _, m := range list {
+			if _, err := fmt.Fprintln(w, metadataName(m, dir)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tKIND\tUNIT\tDESCRIPTION")
+		for _, m := range list {
+			fmt.Fprintf(
+				tw,
+				"%s\t%s\t%s\t%s\n",
+				metadataName(m, dir),
+				m.Kind,
+				formatUnit(m.Unit),
+				m.Description)
+		}
+		return tw.Flush()
+	}
+}
+
+// writeTree writes list, a listing of the directory dir in depth first
+// order, as an indented tree. The table and text formats are the same.
+func writeTree(
+	w io.Writer,
+	format string,
+	dir string,
+	list messages.MetadataList) error {
+	if format == kFormatJson {
+		return writeJson(w, list)
+	}
+	base := strings.Count(strings.TrimSuffix(dir, "/"), "/")
+	for _, m := range list {
+		depth := strings.Count(m.Path, "/") - base - 1
+		if depth < 0 {
+			depth = 0
+		}
+		name := m.Path[strings.LastIndex(m.Path, "/")+1:]
+		if m.IsDirectory {
+			name += "/"
+		}
+		if _, err := fmt.Fprintf(
+			w, "%s%s\n", strings.Repeat("  ", depth), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// Clears a terminal and moves the cursor to the top left
+	kClearScreen = "\033[H\033[2J"
+)
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// topRow is one line of the top display. Fields that don't apply are
+// nil.
+type topRow struct {
+	Path  string     `json:"path"`
+	Unit  units.Unit `json:"unit,omitempty"`
+	Value string     `json:"value"`
+	// The change in value or, for distributions, in count since the
+	// previous poll
+	Delta *float64 `json:"delta,omitempty"`
+	// Delta per second. Only for counters and distributions.
+	Rate *float64 `json:"rate,omitempty"`
+	// For distributions, the quantiles of the values added since the
+	// previous poll.
+	P50 *float64 `json:"p50,omitempty"`
+	P99 *float64 `json:"p99,omitempty"`
+	// The numeric value for sorting
+	number float64
+}
+
+// metricNumber returns the value of m, in JSON form, as a float64.
+// It returns false if m is not numeric.
+func metricNumber(m *messages.Metric) (float64, bool) {
+	if m.Err != "" || m.Value == nil {
+		return 0, false
+	}
+	if m.Kind == types.Duration {
+		str, ok := m.Value.(string)
+		if !ok {
+			return 0, false
+		}
+		result, err := strconv.ParseFloat(str, 64)
+		return result, err == nil
+	}
+	if !m.Kind.IsInt() && !m.Kind.IsUint() && !m.Kind.IsFloat() {
+		return 0, false
+	}
+	value := reflect.ValueOf(m.Value)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return float64(value.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		return float64(value.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// metricTime returns the timestamp of m, in JSON form, in seconds since
+// the epoch or pollTime if m has no timestamp.
+func metricTime(m *messages.Metric, pollTime time.Time) float64 {
+	if str, ok := m.TimeStamp.(string); ok && str != "" {
+		if result, err := strconv.ParseFloat(str, 64); err == nil {
+			return result
+		}
+	}
+	return float64(pollTime.UnixNano()) / float64(time.Second)
+}
+
+// rangesSince returns the values in cur added after prev. If prev is
+// nil or has different buckets, or if cur is not cumulative, it returns
+// the ranges of cur.
+func rangesSince(cur, prev *messages.Distribution) []*messages.RangeWithCount {
+	if prev == nil || cur.IsNotCumulative ||
+		len(prev.Ranges) != len(cur.Ranges) || prev.Count > cur.Count {
+		return cur.Ranges
+	}
+	result := make([]*messages.RangeWithCount, len(cur.Ranges))
+	for i, r := range cur.Ranges {
+		p := prev.Ranges[i]
+		if p.Lower != r.Lower || p.Upper != r.Upper || p.Count > r.Count {
+			return cur.Ranges
+		}
+		result[i] = &messages.RangeWithCount{
+			Lower: r.Lower, Upper: r.Upper, Count: r.Count - p.Count}
+	}
+	return result
+}
+
+// quantile estimates the value at quantile q, between 0 and 1, from
+// ranges by interpolating linearly within the bucket where q falls.
+// quantile returns false if ranges is empty.
+func quantile(ranges []*messages.RangeWithCount, q float64) (float64, bool) {
+	var total uint64
+	for _, r := range ranges {
+		total += r.Count
+	}
+	if total == 0 {
+		return 0, false
+	}
+	target := q * float64(total)
+	var cumulative float64
+	for i, r := range ranges {
+		count := float64(r.Count)
+		if r.Count == 0 || cumulative+count < target {
+			cumulative += count
+			continue
+		}
+		switch i {
+		case 0:
+			// The lowest range has no lower bound
+			return r.Upper, true
+		case len(ranges) - 1:
+			// The highest range has no upper bound
+			return r.Lower, true
+		}
+		return r.Lower + (target-cumulative)/count*(r.Upper-r.Lower), true
+	}
+	return ranges[len(ranges)-1].Lower, true
+}
+
+// serverQuantile returns the value at quantile q that the process
+// computed, if any. Sketch distributions have no ranges, only these.
+func serverQuantile(d *messages.Distribution, q float64) (float64, bool) {
+	if q == 0.5 {
+		return d.Median, d.Count > 0
+	}
+	for _, value := range d.Quantiles {
+		if value.Quantile == q {
+			return value.Value, true
+		}
+	}
+	return 0, false
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+// topRows computes the rows of the top display from the metrics of this
+// poll and, if not nil, the metrics of the previous poll.
+func topRows(
+	cur messages.MetricList,
+	prev map[string]*messages.Metric,
+	pollTime, prevPollTime time.Time) []*topRow {
+	result := make([]*topRow, 0, len(cur))
+	for _, m := range cur {
+		row := &topRow{Path: m.Path, Unit: m.Unit, Value: formatValue(m)}
+		last := prev[m.Path]
+		elapsed := 0.0
+		if last != nil {
+			elapsed = metricTime(m, pollTime) -
+				metricTime(last, prevPollTime)
+		}
+		if dist, ok := m.Value.(*messages.Distribution); ok {
+			row.Value = strconv.FormatUint(dist.Count, 10)
+			row.number = float64(dist.Count)
+			var lastDist *messages.Distribution
+			if last != nil {
+				lastDist, _ = last.Value.(*messages.Distribution)
+			}
+			if lastDist != nil && dist.Count >= lastDist.Count {
+				delta := float64(dist.Count - lastDist.Count)
+				row.Delta = floatPtr(delta)
+				if elapsed > 0 {
+					row.Rate = floatPtr(delta / elapsed)
+				}
+			}
+			if len(dist.Ranges) > 0 {
+				ranges := rangesSince(dist, lastDist)
+				if p50, ok := quantile(ranges, 0.5); ok {
+					row.P50 = floatPtr(p50)
+				}
+				if p99, ok := quantile(ranges, 0.99); ok {
+					row.P99 = floatPtr(p99)
+				}
+			} else {
+				if p50, ok := serverQuantile(dist, 0.5); ok {
+					row.P50 = floatPtr(p50)
+				}
+				if p99, ok := serverQuantile(dist, 0.99); ok {
+					row.P99 = floatPtr(p99)
+				}
+			}
+		} else if value, ok := metricNumber(m); ok {
+			row.number = value
+			var lastValue float64
+			if last != nil {
+				lastValue, ok = metricNumber(last)
+			}
+			if last != nil && ok {
+				delta := value - lastValue
+				// A counter going down means the process restarted.
+				if !m.IsMonotonic || delta >= 0 {
+					row.Delta = floatPtr(delta)
+				}
+				if m.IsMonotonic && delta >= 0 && elapsed > 0 {
+					row.Rate = floatPtr(delta / elapsed)
+				}
+			}
+		}
+		result = append(result, row)
+	}
+	return result
+}
+
+func optionalNumber(f *float64) float64 {
+	if f == nil {
+		return 0
+	}
+	return *f
+}
+
+// sortTopRows sorts rows by key: path ascending or value, delta, or
+// rate descending. It returns false if key is unknown.
+func sortTopRows(rows []*topRow, key string) bool {
+	var less func(i, j int) bool
+	switch key {
+	case "path":
+		less = func(i, j int) bool { return rows[i].Path < rows[j].Path }
+	case "value":
+		less = func(i, j int) bool { return rows[i].number > rows[j].number }
+	case "delta":
+		less = func(i, j int) bool {
+			return optionalNumber(rows[i].Delta) > optionalNumber(rows[j].Delta)
+		}
+	case "rate":
+		less = func(i, j int) bool {
+			return optionalNumber(rows[i].Rate) > optionalNumber(rows[j].Rate)
+		}
+	default:
+		return false
+	}
+	sort.SliceStable(rows, less)
+	return true
+}
+
+// topMetrics returns the metrics at or under any of paths. If there
+// are patterns, topMetrics returns only the metrics that at least one of
+// them selects and lets the process do the selecting.
+func topMetrics(c *jsonClient, paths, patterns []string) (
+	messages.MetricList, error) {
+	var result messages.MetricList
+	seen := make(map[string]bool)
+	add := func(metrics messages.MetricList, filter bool) {
+		for _, m := range metrics {
+			if seen[m.Path] {
+				continue
+			}
+			if filter && !isUnderAny(m.Path, paths) {
+				continue
+			}
+			seen[m.Path] = true
+			result = append(result, m)
+		}
+	}
+	if len(patterns) == 0 {
+		for _, path := range paths {
+			metrics, err := c.List(path)
+			if err != nil {
+				return nil, err
+			}
+			add(metrics, false)
+		}
+		return result, nil
+	}
+	for _, pattern := range patterns {
+		metrics, err := c.Select(pattern)
+		if err != nil {
+			return nil, err
+		}
+		add(metrics, true)
+	}
+	return result, nil
+}
+
+// isUnderAny returns true if metricPath is at or under any of paths.
+func isUnderAny(metricPath string, paths []string) bool {
+	for _, path := range paths {
+		if isUnder(metricPath, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatNumber(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'g', 6, 64)
+}
+
+func writeTopRows(
+	w io.Writer, format string, pollTime time.Time, rows []*topRow) error {
+	switch format {
+	case kFormatJson:
+		content, err := json.Marshal(rows)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", content)
+		return err
+	case kFormatText:
+		for _, row := range rows {
+			if _, err := fmt.Fprintf(
+				w,
+				"%s %s %s %s %s %s\n",
+				row.Path,
+				row.Value,
+				formatNumber(row.Delta),
+				formatNumber(row.Rate),
+				formatNumber(row.P50),
+				formatNumber(row.P99)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		fmt.Fprint(w, kClearScreen)
+		fmt.Fprintf(w, "%s\n\n", pollTime.Format(time.RFC3339))
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "PATH\tVALUE\tDELTA\tRATE/S\tP50\tP99\tUNIT\t")
+		for _, row := range rows {
+			fmt.Fprintf(
+				tw,
+				"%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+				row.Path,
+				row.Value,
+				formatNumber(row.Delta),
+				formatNumber(row.Rate),
+				formatNumber(row.P50),
+				formatNumber(row.P99),
+				formatUnit(row.Unit))
+		}
+		return tw.Flush()
+	}
+}
+
+func runTop(env *environment, flags *flag.FlagSet, args []string) error {
+	interval := flags.Duration("interval", 2*time.Second, "Time between refreshes")
+	count := flags.Int(
+		"count", 0, "Stop after this many refreshes. 0 means never stop")
+	sortKey := flags.String(
+		"sort", "path", "Sort by path, value, delta, or rate")
+	var patterns stringList
+	flags.Var(
+		&patterns,
+		"match",
+		"Show only metrics this glob or ~regular expression selects such as /proc/*/latency. May be repeated")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if *interval <= 0 || !sortTopRows(nil, *sortKey) {
+		return errUsage
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+	for i := range paths {
+		paths[i] = cleanPath(paths[i])
+	}
+	var prev map[string]*messages.Metric
+	var prevPollTime time.Time
+	for i := 0; *count == 0 || i < *count; i++ {
+		if i > 0 {
+			time.Sleep(*interval)
+		}
+		pollTime := time.Now()
+		cur, err := topMetrics(env.Client, paths, patterns)
+		if err != nil {
+			return err
+		}
+		rows := topRows(cur, prev, pollTime, prevPollTime)
+		sortTopRows(rows, *sortKey)
+		if err := writeTopRows(env.Out, env.Format, pollTime, rows); err != nil {
+			return err
+		}
+		prev = make(map[string]*messages.Metric, len(cur))
+		for _, m := range cur {
+			prev[m.Path] = m
+		}
+		prevPollTime = pollTime
+	}
+	return nil
+}
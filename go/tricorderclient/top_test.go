@@ -0,0 +1,105 @@
+package main
+
+import (
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"testing"
+	"time"
+)
+
+func TestQuantile(t *testing.T) {
+	ranges := []*messages.RangeWithCount{
+		{Upper: 10, Count: 1},
+		{Lower: 10, Upper: 20, Count: 2},
+		{Lower: 20, Upper: 30, Count: 0},
+		{Lower: 30, Count: 1},
+	}
+	testCases := []struct {
+		q        float64
+		expected float64
+	}{
+		{0.1, 10},
+		{0.5, 15},
+		{0.75, 20},
+		{0.99, 30},
+	}
+	for _, tc := range testCases {
+		if actual, ok := quantile(ranges, tc.q); !ok || actual != tc.expected {
+			t.Errorf("q=%v: expected %v, got %v", tc.q, tc.expected, actual)
+		}
+	}
+	if _, ok := quantile(nil, 0.5); ok {
+		t.Error("Expected no quantile for empty ranges")
+	}
+}
+
+func TestTopRows(t *testing.T) {
+	pollTime := time.Unix(1000, 0)
+	prevPollTime := time.Unix(998, 0)
+	prev := map[string]*messages.Metric{
+		"/count": {
+			Path: "/count", Kind: types.Uint64, IsMonotonic: true,
+			Value: uint64(10), TimeStamp: "998.000000000"},
+		"/gauge": {Path: "/gauge", Kind: types.Float64, Value: 7.5},
+		"/restarted": {
+			Path: "/restarted", Kind: types.Uint64, IsMonotonic: true,
+			Value: uint64(100)},
+		"/dist": {Path: "/dist", Kind: types.Dist, Value: &messages.Distribution{
+			Count: 2,
+			Ranges: []*messages.RangeWithCount{
+				{Upper: 10, Count: 2},
+				{Lower: 10, Upper: 20},
+				{Lower: 20},
+			}}},
+	}
+	cur := messages.MetricList{
+		{Path: "/count", Kind: types.Uint64, IsMonotonic: true,
+			Value: uint64(30), TimeStamp: "1000.000000000"},
+		{Path: "/gauge", Kind: types.Float64, Value: 5.5},
+		{Path: "/restarted", Kind: types.Uint64, IsMonotonic: true,
+			Value: uint64(3)},
+		{Path: "/dist", Kind: types.Dist, Value: &messages.Distribution{
+			Count: 6,
+			Ranges: []*messages.RangeWithCount{
+				{Upper: 10, Count: 2},
+				{Lower: 10, Upper: 20, Count: 4},
+				{Lower: 20},
+			}}},
+		{Path: "/name", Kind: types.String, Value: "name"},
+	}
+	rows := topRows(cur, prev, pollTime, prevPollTime)
+	byPath := make(map[string]*topRow)
+	for _, row := range rows {
+		byPath[row.Path] = row
+	}
+	expected := map[string][4]string{
+		// delta, rate, p50, p99
+		"/count":     {"20", "10", "", ""},
+		"/gauge":     {"-2", "", "", ""},
+		"/restarted": {"", "", "", ""},
+		// Only the values added since the last poll count.
+		"/dist": {"4", "2", "15", "19.9"},
+		"/name": {"", "", "", ""},
+	}
+	for path, e := range expected {
+		row := byPath[path]
+		actual := [4]string{
+			formatNumber(row.Delta),
+			formatNumber(row.Rate),
+			formatNumber(row.P50),
+			formatNumber(row.P99)}
+		if actual != e {
+			t.Errorf("%s: expected %v, got %v", path, e, actual)
+		}
+	}
+	if !sortTopRows(rows, "rate") {
+		t.Fatal("Expected rate to be a sort key")
+	}
+	if rows[0].Path != "/count" || rows[1].Path != "/dist" {
+		t.Errorf("Expected /count then /dist, got %s then %s",
+			rows[0].Path, rows[1].Path)
+	}
+	if sortTopRows(rows, "bogus") {
+		t.Error("Expected bogus not to be a sort key")
+	}
+}
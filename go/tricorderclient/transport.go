@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"github.com/Symantec/tricorder/go/tricorder/client"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"time"
+)
+
+// jsonClient reads metrics from a process with a client.Client. Every
+// metric it returns is in JSON form which is what the output code
+// expects.
+type jsonClient struct {
+	client *client.Client
+}
+
+// newJsonClient returns a jsonClient talking to host using Go RPC if
+// transport is "rpc" or the REST API if transport is "http". The
+// client connects on first use.
+func newJsonClient(host, transport string, timeout time.Duration) (
+	*jsonClient, error) {
+	c, err := client.New(
+		host,
+		&client.Config{
+			Transport: client.Transport(transport),
+			Timeout:   timeout,
+		})
+	if err != nil {
+		return nil, err
+	}
+	return &jsonClient{client: c}, nil
+}
+
+// List returns every metric at or under path.
+func (c *jsonClient) List(path string) (messages.MetricList, error) {
+	result, err := c.client.List(context.Background(), path)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range result {
+		m.ConvertToJson()
+	}
+	return result, nil
+}
+
+// Get returns the metric at path or messages.ErrMetricNotFound.
+func (c *jsonClient) Get(path string) (*messages.Metric, error) {
+	result, err := c.client.Get(context.Background(), path)
+	if err != nil {
+		return nil, err
+	}
+	result.ConvertToJson()
+	return result, nil
+}
+
+// Select returns the metrics that pattern selects. The process does the
+// selecting.
+func (c *jsonClient) Select(pattern string) (messages.MetricList, error) {
+	result, err := c.client.Select(context.Background(), pattern)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range result {
+		m.ConvertToJson()
+	}
+	return result, nil
+}
+
+// ListMetadata lists directories and metrics without their values.
+func (c *jsonClient) ListMetadata(
+	request messages.MetadataRequest) (messages.MetadataList, error) {
+	result, err := c.client.ListMetadata(context.Background(), request)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range result {
+		m.ConvertToJson()
+	}
+	return result, nil
+}
+
+// ListSince returns the metrics under path that changed since token.
+func (c *jsonClient) ListSince(path, token string) (
+	*messages.ChangedMetrics, error) {
+	result, err := c.client.ListSince(context.Background(), path, token)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range result.Metrics {
+		m.ConvertToJson()
+	}
+	return result, nil
+}
+
+func (c *jsonClient) Close() error {
+	return c.client.Close()
+}
@@ -1,68 +1,376 @@
+// tricorderclient is a command line tool for reading the tricorder
+// metrics of a process.
+//
+// Usage:
+//
+//	tricorderclient [flags] command [command flags] [args]
+//
+// Commands:
+//
+//	ls [path]       List the directories and metrics directly under path.
+//	get path...     Show the value of each metric.
+//	tree [path]     Show every directory and metric under path as a tree.
+//	watch [path]    Show metrics under path as they change.
+//	dump [path]     Show the value of every metric under path.
+//	top [path...]   Show metrics refreshed periodically with rates and
+//	                the recent p50 and p99 of distributions.
+//	diff before [after]
+//	                Compare the metrics of two processes or saved JSON
+//	                files. after defaults to -host.
+//
+// Exit codes:
+//
+//	0  Success
+//	1  Any other error
+//	2  Bad usage
+//	3  A path was not found
+//	4  Could not connect to the process
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/Symantec/tricorder/go/tricorder/client"
 	"github.com/Symantec/tricorder/go/tricorder/messages"
-	"log"
-	"net/rpc"
+	"io"
 	"os"
+	pathpkg "path"
+	"strings"
 	"time"
 )
 
-func printAsJson(desc string, value interface{}) {
-	fmt.Println(desc)
-	var buffer bytes.Buffer
-	content, err := json.Marshal(value)
+// Exit codes
+const (
+	kExitOk         = 0
+	kExitError      = 1
+	kExitUsage      = 2
+	kExitNotFound   = 3
+	kExitConnection = 4
+)
+
+var (
+	errUsage = errors.New("bad usage")
+)
+
+// environment is what every command gets.
+type environment struct {
+	Client *jsonClient
+	Out    io.Writer
+	Format string
+	// For commands that read from more than one process
+	Host      string
+	Transport string
+	Timeout   time.Duration
+}
+
+type command struct {
+	Name  string
+	Args  string
+	Usage string
+	// Run runs the command. flags is empty. Run adds its own flags to it
+	// before parsing args.
+	Run func(env *environment, flags *flag.FlagSet, args []string) error
+}
+
+var commands = []*command{
+	{Name: "ls", Args: "[path]",
+		Usage: "List the directories and metrics directly under path",
+		Run:   runLs},
+	{Name: "get", Args: "path...",
+		Usage: "Show the value of each metric",
+		Run:   runGet},
+	{Name: "tree", Args: "[path]",
+		Usage: "Show every directory and metric under path as a tree",
+		Run:   runTree},
+	{Name: "watch", Args: "[path]",
+		Usage: "Show the metrics under path as they change",
+		Run:   runWatch},
+	{Name: "dump", Args: "[path]",
+		Usage: "Show the value of every metric under path",
+		Run:   runDump},
+	{Name: "top", Args: "[path...]",
+		Usage: "Show metrics refreshed periodically with rates and recent quantiles",
+		Run:   runTop},
+	{Name: "diff", Args: "before [after]",
+		Usage: "Compare the metrics of two processes or saved JSON files. after defaults to -host",
+		Run:   runDiff},
+}
+
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// cleanPath returns path as an absolute path.
+func cleanPath(path string) string {
+	return pathpkg.Clean("/" + path)
+}
+
+// isUnder returns true if the metric at metricPath is at or under path.
+// Both paths are absolute.
+func isUnder(metricPath, path string) bool {
+	return path == "/" || metricPath == path ||
+		strings.HasPrefix(metricPath, path+"/")
+}
+
+// optionalPath returns the single path in args or "/" if args is empty.
+func optionalPath(args []string) (string, error) {
+	switch len(args) {
+	case 0:
+		return "/", nil
+	case 1:
+		return cleanPath(args[0]), nil
+	default:
+		return "", errUsage
+	}
+}
+
+// checkExists returns messages.ErrMetricNotFound if there is no
+// directory or metric at path. "/" always exists. Listing an empty
+// directory and a missing path give the same empty result so callers
+// use checkExists to tell them apart.
+func checkExists(c *jsonClient, path string) error {
+	if path == "/" {
+		return nil
+	}
+	list, err := c.ListMetadata(
+		messages.MetadataRequest{
+			Path: pathpkg.Dir(path), MaxDepth: 1, NamesOnly: true})
 	if err != nil {
-		log.Fatal("Marshalling:", err)
+		return err
+	}
+	for _, m := range list {
+		if m.Path == path {
+			return nil
+		}
 	}
-	json.Indent(&buffer, content, "", "\t")
-	buffer.WriteTo(os.Stdin)
-	fmt.Println()
+	return messages.ErrMetricNotFound
 }
 
-func main() {
-	client, err := rpc.DialHTTP("tcp", ":8080")
+func runLs(env *environment, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	path, err := optionalPath(flags.Args())
 	if err != nil {
-		log.Fatal("dialing:", err)
+		return err
 	}
-	defer client.Close()
-	var metrics messages.MetricList
-	err = client.Call("MetricsServer.ListMetrics", "", &metrics)
+	list, err := env.Client.ListMetadata(
+		messages.MetadataRequest{Path: path, MaxDepth: 1})
 	if err != nil {
-		log.Fatal("Calling:", err)
+		return err
+	}
+	if len(list) == 0 {
+		if err := checkExists(env.Client, path); err != nil {
+			return err
+		}
 	}
-	printAsJson("All metrics", metrics)
+	return writeListing(env.Out, env.Format, path, list)
+}
 
-	err = client.Call("MetricsServer.ListMetrics", "/aaa/bbb", &metrics)
-	if err != nil {
-		log.Fatal("Calling:", err)
+func runGet(env *environment, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+	var metrics messages.MetricList
+	var notFound error
+	for _, path := range flags.Args() {
+		m, err := env.Client.Get(cleanPath(path))
+		if err == messages.ErrMetricNotFound {
+			fmt.Fprintf(os.Stderr, "%s: not found\n", path)
+			notFound = err
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		metrics = append(metrics, m)
+	}
+	if len(metrics) > 0 {
+		if err := writeMetrics(env.Out, env.Format, metrics); err != nil {
+			return err
+		}
 	}
-	printAsJson("aaa/bbb metrics", metrics)
+	return notFound
+}
 
-	var single messages.Metric
-	err = client.Call("MetricsServer.GetMetric", "/proc/foo/bar/baz", &single)
+func runTree(env *environment, flags *flag.FlagSet, args []string) error {
+	depth := flags.Int("depth", 0, "Maximum depth to show. 0 means no limit")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	path, err := optionalPath(flags.Args())
+	if err != nil {
+		return err
+	}
+	list, err := env.Client.ListMetadata(
+		messages.MetadataRequest{
+			Path: path, MaxDepth: *depth, NamesOnly: true})
 	if err != nil {
-		log.Fatal("Calling:", err)
+		return err
 	}
-	printAsJson("/proc/foo/bar/baz metric", single)
+	if len(list) == 0 {
+		if err := checkExists(env.Client, path); err != nil {
+			return err
+		}
+	}
+	return writeTree(env.Out, env.Format, path, list)
+}
 
-	err = client.Call("MetricsServer.GetMetric", "/proc/foo/ddd", &single)
+func runWatch(env *environment, flags *flag.FlagSet, args []string) error {
+	interval := flags.Duration("interval", 5*time.Second, "Time between polls")
+	count := flags.Int(
+		"count", 0, "Stop after this many polls. 0 means never stop")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	path, err := optionalPath(flags.Args())
 	if err != nil {
-		log.Println("Got error for /proc/foo/ddd:", err)
-	} else {
-		printAsJson("/proc/foo/ddd metric", single)
+		return err
+	}
+	if *interval <= 0 {
+		return errUsage
 	}
+	token := ""
+	for i := 0; *count == 0 || i < *count; i++ {
+		if i > 0 {
+			time.Sleep(*interval)
+		}
+		changed, err := env.Client.ListSince(path, token)
+		if err != nil {
+			return err
+		}
+		token = changed.Token
+		if len(changed.Metrics) == 0 {
+			continue
+		}
+		if env.Format == kFormatTable {
+			fmt.Fprintf(env.Out, "--- %s\n", time.Now().Format(time.RFC3339))
+		}
+		if err := writeMetrics(
+			env.Out, env.Format, changed.Metrics); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	err = client.Call("MetricsServer.GetMetric", "/list/squares", &single)
+func runDump(env *environment, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	path, err := optionalPath(flags.Args())
+	if err != nil {
+		return err
+	}
+	metrics, err := env.Client.List(path)
 	if err != nil {
-		log.Println("Got error for /list/squares:", err)
-	} else {
-		printAsJson("/list/squares metric", single)
+		return err
+	}
+	if len(metrics) == 0 {
+		if err := checkExists(env.Client, path); err != nil {
+			return err
+		}
 	}
+	return writeMetrics(env.Out, env.Format, metrics)
+}
 
-	time.Sleep(5 * time.Second)
+// exitCode returns the exit code for err.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return kExitOk
+	case err == errUsage:
+		return kExitUsage
+	case err == messages.ErrMetricNotFound:
+		return kExitNotFound
+	case client.IsConnectionError(err):
+		return kExitConnection
+	default:
+		return kExitError
+	}
+}
+
+func usage(flags *flag.FlagSet) {
+	fmt.Fprintln(
+		os.Stderr,
+		"Usage: tricorderclient [flags] command [command flags] [args]")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %s %s\n\t%s\n", c.Name, c.Args, c.Usage)
+	}
+	fmt.Fprintln(os.Stderr, "Flags:")
+	flags.PrintDefaults()
+}
 
+// run runs the command line in args writing to out and returns the
+// exit code.
+func run(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("tricorderclient", flag.ContinueOnError)
+	host := flags.String(
+		"host", "localhost:8080", "host:port of the process to read")
+	transport := flags.String(
+		"transport", "rpc", "How to read metrics: rpc or http")
+	format := flags.String("format", kFormatTable, "Output format: table, json or text")
+	timeout := flags.Duration(
+		"timeout", 10*time.Second, "Timeout for each request")
+	flags.Usage = func() { usage(flags) }
+	if err := flags.Parse(args); err != nil {
+		return kExitUsage
+	}
+	if flags.NArg() == 0 || !isValidFormat(*format) {
+		flags.Usage()
+		return kExitUsage
+	}
+	cmd := findCommand(flags.Arg(0))
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", flags.Arg(0))
+		flags.Usage()
+		return kExitUsage
+	}
+	c, err := newJsonClient(*host, *transport, *timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return kExitUsage
+	}
+	defer c.Close()
+	cmdFlags := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	cmdFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: tricorderclient %s [flags] %s\n",
+			cmd.Name, cmd.Args)
+		cmdFlags.PrintDefaults()
+	}
+	env := &environment{
+		Client:    c,
+		Out:       out,
+		Format:    *format,
+		Host:      *host,
+		Transport: *transport,
+		Timeout:   *timeout,
+	}
+	err = cmd.Run(env, cmdFlags, flags.Args()[1:])
+	switch err {
+	case nil, messages.ErrMetricNotFound:
+		// get already reported which paths it didn't find.
+		if err != nil && cmd.Name != "get" {
+			fmt.Fprintln(os.Stderr, "Path not found")
+		}
+	case errUsage:
+		cmdFlags.Usage()
+	default:
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return exitCode(err)
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout))
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Symantec/tricorder/go/tricorder"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newServer serves a registry over both Go RPC and HTTP.
+func newServer(t *testing.T) *httptest.Server {
+	reg := tricorder.NewRegistry()
+	var count tricorder.Counter
+	count.Add(3)
+	name := "server"
+	reg.RegisterMetric("/a/count", &count, units.None, "A count")
+	reg.RegisterMetric("/a/b/name", &name, units.None, "A name")
+	reg.RegisterDirectory("/empty")
+	rpcServer := rpc.NewServer()
+	if err := reg.RegisterRpc(rpcServer); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, rpcServer)
+	mux.Handle("/", reg)
+	return httptest.NewServer(mux)
+}
+
+func TestCommands(t *testing.T) {
+	server := newServer(t)
+	defer server.Close()
+	host := server.Listener.Addr().String()
+	testCases := []struct {
+		args     []string
+		code     int
+		expected string
+	}{
+		{[]string{"get", "/a/count", "a/b/name"}, kExitOk,
+			"/a/count 3\n/a/b/name server\n"},
+		{[]string{"get", "/a/missing"}, kExitNotFound, ""},
+		{[]string{"get"}, kExitUsage, ""},
+		{[]string{"ls", "/a"}, kExitOk, "b/\ncount\n"},
+		{[]string{"ls", "/missing"}, kExitNotFound, ""},
+		{[]string{"ls", "/empty"}, kExitOk, ""},
+		{[]string{"tree"}, kExitOk,
+			"a/\n  b/\n    name\n  count\nempty/\n"},
+		{[]string{"tree", "-depth", "2"}, kExitOk,
+			"a/\n  b/\n  count\nempty/\n"},
+		{[]string{"tree", "/missing"}, kExitNotFound, ""},
+		{[]string{"dump", "/a"}, kExitOk,
+			"/a/b/name server\n/a/count 3\n"},
+		{[]string{"dump", "/empty"}, kExitOk, ""},
+		{[]string{"dump", "/a/missing"}, kExitNotFound, ""},
+		{[]string{"watch", "-count", "2", "-interval", "1ms", "/a/b"},
+			kExitOk, "/a/b/name server\n"},
+		{[]string{"top", "-count", "1", "-match", "/**/count", "/a"}, kExitOk,
+			"/a/count 3    \n"},
+		{[]string{"top", "-sort", "bogus"}, kExitUsage, ""},
+		{[]string{"bogus"}, kExitUsage, ""},
+	}
+	for _, transport := range []string{"rpc", "http"} {
+		for _, tc := range testCases {
+			var out bytes.Buffer
+			args := append(
+				[]string{
+					"-host", host,
+					"-transport", transport,
+					"-format", "text"},
+				tc.args...)
+			code := run(args, &out)
+			if code != tc.code {
+				t.Errorf("%s %v: expected exit code %d, got %d",
+					transport, tc.args, tc.code, code)
+			}
+			if out.String() != tc.expected {
+				t.Errorf("%s %v: expected %q, got %q",
+					transport, tc.args, tc.expected, out.String())
+			}
+		}
+	}
+}
+
+func TestConnectionFailure(t *testing.T) {
+	server := newServer(t)
+	host := server.Listener.Addr().String()
+	server.Close()
+	for _, transport := range []string{"rpc", "http"} {
+		var out bytes.Buffer
+		code := run(
+			[]string{"-host", host, "-transport", transport, "dump"},
+			&out)
+		if code != kExitConnection {
+			t.Errorf("%s: expected exit code %d, got %d",
+				transport, kExitConnection, code)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	server := newServer(t)
+	defer server.Close()
+	host := server.Listener.Addr().String()
+	var saved bytes.Buffer
+	if code := run(
+		[]string{"-host", host, "-format", "json", "dump"},
+		&saved); code != kExitOk {
+		t.Fatalf("dump: expected exit code %d, got %d", kExitOk, code)
+	}
+	// Pretend /a/b/name used to be /a/b/old
+	before := strings.Replace(
+		saved.String(), `"/a/b/name"`, `"/a/b/old"`, 1)
+	filename := filepath.Join(t.TempDir(), "before.json")
+	if err := os.WriteFile(filename, []byte(before), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expected := "+ /a/b/name\n- /a/b/old\n"
+	for _, transport := range []string{"rpc", "http"} {
+		var out bytes.Buffer
+		code := run(
+			[]string{
+				"-host", host,
+				"-transport", transport,
+				"-format", "text",
+				"diff", filename},
+			&out)
+		if code != kExitOk {
+			t.Errorf("%s: expected exit code %d, got %d",
+				transport, kExitOk, code)
+		}
+		if out.String() != expected {
+			t.Errorf("%s: expected %q, got %q",
+				transport, expected, out.String())
+		}
+	}
+	// The same process has no differences
+	var out bytes.Buffer
+	if code := run(
+		[]string{"-format", "text", "diff", "-path", "/a", host, host},
+		&out); code != kExitOk || out.String() != "" {
+		t.Errorf("Expected no differences, got %d %q", code, out.String())
+	}
+}